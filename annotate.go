@@ -0,0 +1,161 @@
+package messagefix
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Annotation is an annotated view of the structure of a message and of the fixes applied to
+// it, for debugging, for example when a fixed message is still rejected by a consumer.
+//
+// An Annotation can be marshaled to JSON with encoding/json.
+type Annotation struct {
+	// Message is the entity of the message itself.
+	Message *AnnotatedEntity `json:"message"`
+	// Size is the size in bytes of the fixed message.
+	Size     int64              `json:"size"`
+	Findings []AnnotatedFinding `json:"findings,omitempty"`
+}
+
+// AnnotatedEntity is a MIME entity of an Annotation.
+type AnnotatedEntity struct {
+	// Path is the IMAP part specifier of the entity, as in PartSummary.
+	Path string `json:"path"`
+	// MediaType is the lowercase media type of the entity, or "" if it has no Content-Type.
+	MediaType string `json:"mediaType,omitempty"`
+	// Embedded is set for a message embedded in a message/rfc822 or message/global part.
+	Embedded bool `json:"embedded,omitempty"`
+	// StartLine and EndLine are the 1-based input line numbers of the first and last lines
+	// of the entity.
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+	// Fixes are the fixes applied to the entity, in the order they were applied.
+	Fixes []AnnotatedFix `json:"fixes,omitempty"`
+	// Children are the parts of a multipart entity, or the embedded message of a
+	// message/rfc822 part.
+	Children []*AnnotatedEntity `json:"children,omitempty"`
+}
+
+// AnnotatedFix is a fix of an AnnotatedEntity.
+type AnnotatedFix struct {
+	ID FixID `json:"id"`
+	// Line is the 1-based input line number where the fix was applied, or 0 for fixes of part
+	// bodies.
+	Line int `json:"line,omitempty"`
+}
+
+// AnnotatedFinding is a Finding of an Annotation.
+type AnnotatedFinding struct {
+	Kind   string `json:"kind"`
+	Line   int    `json:"line"`
+	Detail string `json:"detail"`
+}
+
+// Annotate fixes the message read from r with the passed options, discarding the fixed
+// message, and returns an annotated view of its structure and fixes.
+func Annotate(r io.Reader, opts ...Option) (*Annotation, error) {
+	var mu sync.Mutex
+	var fixes []Fix
+	collect := func(o *options) {
+		next := o.onFix
+		o.onFix = func(f Fix) {
+			mu.Lock()
+			fixes = append(fixes, f)
+			mu.Unlock()
+			if next != nil {
+				next(f)
+			}
+		}
+	}
+	fr := NewReader(r, append(append([]Option(nil), opts...), collect)...)
+	if _, err := io.Copy(io.Discard, fr); err != nil {
+		return nil, err
+	}
+
+	a := &Annotation{
+		Size: fr.summary.Size,
+	}
+	// the last entity of each path, to which the fixes of the path and its children belong,
+	// since an embedded message has the path of the part containing it
+	last := make(map[string]*AnnotatedEntity)
+	for i, e := range fr.parts {
+		p := fr.summary.Parts[i]
+		ae := &AnnotatedEntity{
+			Path:      p.Path,
+			MediaType: p.MediaType,
+			Embedded:  e.embedded,
+			StartLine: p.StartLine,
+			EndLine:   p.EndLine,
+		}
+		if i == 0 {
+			a.Message = ae
+		} else {
+			parent := e.path
+			if !e.embedded {
+				parent = parentPath(e.path)
+			}
+			if pe := last[parent]; pe != nil {
+				pe.Children = append(pe.Children, ae)
+			}
+		}
+		last[e.path] = ae
+	}
+	for _, f := range fixes {
+		if ae := last[f.Path]; ae != nil {
+			ae.Fixes = append(ae.Fixes, AnnotatedFix{ID: f.ID, Line: f.Line})
+		}
+	}
+	for _, f := range fr.findings {
+		a.Findings = append(a.Findings, AnnotatedFinding{
+			Kind:   f.Kind.String(),
+			Line:   f.Line,
+			Detail: f.Detail,
+		})
+	}
+	return a, nil
+}
+
+// parentPath returns the path of the entity containing the part at path.
+func parentPath(path string) string {
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// WriteText writes the annotation to w as indented text, with a line per entity, fix and
+// finding.
+func (a *Annotation) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	a.Message.writeText(&sb, "")
+	fmt.Fprintf(&sb, "size: %v bytes\n", a.Size)
+	for _, f := range a.Findings {
+		fmt.Fprintf(&sb, "finding %v at line %v: %v\n", f.Kind, f.Line, f.Detail)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (e *AnnotatedEntity) writeText(sb *strings.Builder, indent string) {
+	name := "part " + e.Path
+	if e.Path == "" || e.Embedded {
+		name = "message"
+	}
+	mediaType := e.MediaType
+	if mediaType == "" {
+		mediaType = "(no Content-Type)"
+	}
+	fmt.Fprintf(sb, "%v%v: %v, lines %v-%v\n", indent, name, mediaType, e.StartLine, e.EndLine)
+	for _, f := range e.Fixes {
+		if f.Line > 0 {
+			fmt.Fprintf(sb, "%v  fix %v at line %v\n", indent, f.ID, f.Line)
+		} else {
+			fmt.Fprintf(sb, "%v  fix %v\n", indent, f.ID)
+		}
+	}
+	for _, c := range e.Children {
+		c.writeText(sb, indent+"  ")
+	}
+}
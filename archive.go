@@ -0,0 +1,208 @@
+package messagefix
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// ArchiveVersion is the version of the canonical form produced by ProfileArchive, which is
+// incremented each time the canonical form changes, so that archives can tell which form a
+// message was stored in.
+//
+// Version 2 writes well-known header field names, such as BIMI-Selector, MT-Priority,
+// TLS-Required, NNTP-Posting-Host and X-MS-Has-Attach, in their usual form rather than in the
+// form of textproto.CanonicalMIMEHeaderKey. Version 3 removes the Content-Transfer-Encoding
+// field of message/rfc822 entities declared as base64 or quoted-printable whose body is not
+// actually encoded, and keeps it otherwise, where version 2 always removed it and version 1
+// always kept it. Version 1 is produced by ProfileArchive1.
+const ArchiveVersion = 3
+
+// WithFoldHeaders enables folding the header fields with lines longer than width characters,
+// at whitespace, into lines of at most width characters where possible. Fields whose lines
+// are all short enough are kept as read. RFC 5322 recommends folding at 78 characters.
+// A width of 0 disables folding.
+func WithFoldHeaders(width int) Option {
+	return func(o *options) {
+		o.foldWidth = width
+	}
+}
+
+// WithEncodeAttachments enables encoding the body of the leaf parts whose media type is
+// neither text nor message, such as attachments, as base64 in lines of 76 characters,
+// decoding any quoted-printable or base64 encoding first. It has no effect with WithBinary.
+func WithEncodeAttachments(enabled bool) Option {
+	return func(o *options) {
+		o.encodeAttachments = enabled
+	}
+}
+
+// foldHeader folds the fields of the header with lines longer than width, except the trace
+// fields if trace is set, reporting whether the header was fixed.
+func foldHeader(header []*field, width int, trace bool) bool {
+	fixed := false
+	for _, f := range header {
+		if trace && isTraceField(f) {
+			continue
+		}
+		if foldField(f, width) {
+			fixed = true
+		}
+	}
+	return fixed
+}
+
+// foldField folds a field with lines longer than width at whitespace, reporting whether it
+// was folded. Each line is folded at its last whitespace within width characters, or at its
+// first whitespace if there is none.
+func foldField(f *field, width int) bool {
+	long := false
+	for _, line := range f.lines {
+		long = long || len(line) > width
+	}
+	if !long {
+		return false
+	}
+	rest := strings.Join(f.lines, "")
+	min := len(f.name) + 1
+	var lines []string
+	for len(rest) > width {
+		i := foldIndex(rest, min, width)
+		if i < 0 {
+			break
+		}
+		lines = append(lines, rest[:i])
+		rest = rest[i:]
+		min = 0
+	}
+	lines = append(lines, rest)
+	if len(lines) == len(f.lines) {
+		same := true
+		for i := range lines {
+			same = same && lines[i] == f.lines[i]
+		}
+		if same {
+			return false
+		}
+	}
+	// fix: fold long header lines
+	f.lines = lines
+	return true
+}
+
+// foldIndex returns the index of the whitespace of s to fold at, after some non-whitespace
+// past min, or -1 if there is none.
+func foldIndex(s string, min, width int) int {
+	i := -1
+	content := false
+	for j := min; j < len(s); j++ {
+		ws := s[j] == ' ' || s[j] == '\t'
+		if ws && content && s[j-1] != ' ' && s[j-1] != '\t' {
+			if j > width && i >= 0 {
+				break
+			}
+			i = j
+			if j > width {
+				break
+			}
+		}
+		content = content || !ws
+	}
+	return i
+}
+
+// isAttachment reports whether the part is a leaf part whose media type is neither text
+// nor message.
+func (p *part) isAttachment() bool {
+	return p.mediaType != "" && !strings.HasPrefix(p.mediaType, "text/") && !strings.HasPrefix(p.mediaType, "message/") && !p.isContainer()
+}
+
+// encodesAttachment reports whether the body of the current part is encoded as base64.
+func (r *Reader) encodesAttachment() bool {
+	if !r.opts.encodeAttachments || r.opts.binary || !r.part.isAttachment() {
+		return false
+	}
+	switch r.part.encoding {
+	case "", "7bit", "8bit", "binary", "base64", "quoted-printable":
+		return true
+	}
+	return false
+}
+
+// setEncoding sets the Content-Transfer-Encoding field of the header block.
+func (r *Reader) setEncoding(encoding string) {
+	if f := lookup(r.header, "Content-Transfer-Encoding"); f != nil {
+		f.setValue(encoding)
+	} else {
+		r.header = append(r.header, newField("Content-Transfer-Encoding: "+encoding))
+	}
+}
+
+// base64Line is the count of bytes encoded in each line of base64.
+const base64Line = 57
+
+// base64Encoder encodes the decoded body of a part as base64.
+type base64Encoder struct {
+	dec  lineWriter
+	buf  []byte
+	next lineWriter
+}
+
+func newBase64Encoder(p *part, next lineWriter) *base64Encoder {
+	e := &base64Encoder{next: next}
+	e.dec = p.decoder(&e.buf, &identityDecoder{out: &e.buf})
+	return e
+}
+
+func (e *base64Encoder) writeLine(line string) {
+	e.dec.writeLine(line)
+	e.flush(false)
+}
+
+func (e *base64Encoder) end(delimiter bool) {
+	e.dec.end(delimiter)
+	if delimiter {
+		// the CRLF preceding the delimiter belongs to it
+		e.buf = e.buf[:len(e.buf)-2]
+	}
+	e.flush(true)
+	e.next.end(delimiter)
+}
+
+// flush encodes the complete lines of the decoded body, or all of it if last is set.
+func (e *base64Encoder) flush(last bool) {
+	n := len(e.buf) / base64Line * base64Line
+	if last {
+		n = len(e.buf)
+	}
+	for i := 0; i < n; i += base64Line {
+		j := i + base64Line
+		if j > n {
+			j = n
+		}
+		e.next.writeLine(base64.StdEncoding.EncodeToString(e.buf[i:j]))
+	}
+	e.buf = append(e.buf[:0], e.buf[n:]...)
+}
+
+// identityDecoder writes the body of a part that is not encoded to out, as its lines
+// terminated by CRLF.
+type identityDecoder struct {
+	out *[]byte
+	// started is set once a line was written.
+	started bool
+}
+
+func (d *identityDecoder) writeLine(line string) {
+	if d.started {
+		*d.out = append(*d.out, "\r\n"...)
+	}
+	*d.out = append(*d.out, line...)
+	d.started = true
+}
+
+func (d *identityDecoder) end(delimiter bool) {
+	if d.started || delimiter {
+		*d.out = append(*d.out, "\r\n"...)
+	}
+	d.started = false
+}
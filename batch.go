@@ -0,0 +1,122 @@
+package messagefix
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Source is a message fixed by FixAll.
+type Source struct {
+	// Name identifies the message in its Result, such as its file name.
+	Name string
+	// Open returns the message, which is closed once it is fixed.
+	Open func() (io.ReadCloser, error)
+	// Create returns the writer of the fixed message, which is closed once it is written.
+	// If Create is nil, the fixed message is discarded, and only its report is returned.
+	Create func() (io.WriteCloser, error)
+}
+
+// Result is the result of fixing a Source with FixAll.
+type Result struct {
+	// Name is the name of the Source.
+	Name string
+	// Size is the size of the fixed message.
+	Size int64
+	// Report is the report of the Reader that fixed the message.
+	Report Report
+	// Err is set if the message could not be fixed or written.
+	Err error
+}
+
+// FixAll fixes the messages returned by next concurrently, on at most workers goroutines, or
+// runtime.GOMAXPROCS(0) if workers is not positive, and sends their results to the returned
+// channel, in the order the messages were fixed.
+//
+// next is called from a single goroutine, as messages are needed, until it returns false: it
+// can open sources lazily, for migrating very large stores. The channel is closed once all
+// messages were fixed, or once ctx is done, in which case the messages being fixed stop with
+// ctx.Err() and the rest are not fixed. The caller must receive all results until the channel
+// is closed, or cancel ctx.
+func FixAll(ctx context.Context, next func() (Source, bool), workers int, opts ...Option) <-chan Result {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	sources := make(chan Source)
+	results := make(chan Result, workers)
+	go func() {
+		defer close(sources)
+		for ctx.Err() == nil {
+			s, ok := next()
+			if !ok {
+				return
+			}
+			select {
+			case sources <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the Reader of the worker is reused across its messages
+			var r *Reader
+			defer func() {
+				if r != nil {
+					r.Close()
+				}
+			}()
+			for s := range sources {
+				res := fixSource(s, &r, opts)
+				select {
+				case results <- res:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// fixSource fixes a source of FixAll with *r, which is created on first use.
+func fixSource(s Source, r **Reader, opts []Option) Result {
+	res := Result{Name: s.Name}
+	src, err := s.Open()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer src.Close()
+	if *r == nil {
+		*r = NewReader(src, opts...)
+	} else {
+		(*r).Reset(src)
+	}
+	var w io.Writer = io.Discard
+	var dst io.WriteCloser
+	if s.Create != nil {
+		if dst, err = s.Create(); err != nil {
+			res.Err = err
+			return res
+		}
+		w = dst
+	}
+	res.Size, res.Err = (*r).WriteTo(w)
+	res.Report = (*r).Report()
+	if dst != nil {
+		if err := dst.Close(); res.Err == nil {
+			res.Err = err
+		}
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+package messagefix
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// benchMessage returns a multipart message of about size bytes, made of a text part and of
+// a base64 attachment in lines of 76 characters.
+func benchMessage(size int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: a@example.org\r\nTo: b@example.org\r\nSubject: benchmark\r\nDate: Mon, 1 Jan 2024 00:00:00 +0000\r\nMessage-ID: <bench@example.org>\r\nMIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog.\r\n", size/2/46+1)
+	buf.WriteString(text)
+	buf.WriteString("--b\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n")
+	line := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0, 1, 2, 0xfe, 0xff}, 12)[:57])
+	for buf.Len() < size {
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--b--\r\n")
+	return buf.Bytes()
+}
+
+var benchMessages = sync.OnceValue(func() map[string][]byte {
+	return map[string][]byte{
+		"4KB":   benchMessage(4 << 10),
+		"1MB":   benchMessage(1 << 20),
+		"100MB": benchMessage(100 << 20),
+	}
+})
+
+func benchmarkReader(b *testing.B, name string, opts ...Option) {
+	msg := benchMessages()[name]
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.Copy(io.Discard, NewReader(bytes.NewReader(msg), opts...)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	for _, name := range []string{"4KB", "1MB", "100MB"} {
+		b.Run(name, func(b *testing.B) {
+			benchmarkReader(b, name)
+		})
+	}
+}
+
+func BenchmarkReaderBufferSize(b *testing.B) {
+	for _, name := range []string{"4KB", "100MB"} {
+		for _, initial := range []int{512, 4 << 10, 16 << 10, 64 << 10} {
+			b.Run(fmt.Sprintf("%v/%v", name, initial), func(b *testing.B) {
+				benchmarkReader(b, name, WithBufferSize(initial, 64<<10))
+			})
+		}
+	}
+}
+
+func BenchmarkReaderReadSize(b *testing.B) {
+	for _, name := range []string{"4KB", "100MB"} {
+		for _, n := range []int{64, 512, 4 << 10, 64 << 10} {
+			b.Run(fmt.Sprintf("%v/%v", name, n), func(b *testing.B) {
+				benchmarkReader(b, name, WithReadSize(n))
+			})
+		}
+	}
+}
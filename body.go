@@ -0,0 +1,422 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// lineWriter receives the lines of a part body, without their line terminator.
+//
+// Body fixes are implemented as a chain of lineWriter, ending with the Reader output.
+type lineWriter interface {
+	writeLine(line string)
+	// end is called when the part ends. delimiter is set when the part is followed
+	// by a boundary delimiter rather than by the end of the message.
+	end(delimiter bool)
+}
+
+// output is the lineWriter appending lines to an output buffer.
+type output struct {
+	out *[]byte
+	// r is set with WithPreserveLineEndings when out is the buffer of r, so that the lines
+	// output as read keep their line terminator.
+	r *Reader
+}
+
+func (o output) writeLine(line string) {
+	if o.r != nil {
+		o.r.emit(line)
+		return
+	}
+	*o.out = append(*o.out, line...)
+	*o.out = append(*o.out, "\r\n"...)
+}
+
+func (o output) end(delimiter bool) {}
+
+// body sets up the chain of lineWriter processing the current part body to out,
+// rewriting the current header block accordingly.
+func (r *Reader) body(out *[]byte) lineWriter {
+	var w lineWriter = output{out: out}
+	if r.opts.preserveLineEnds && out == &r.buffer {
+		w = output{out: out, r: r}
+	}
+	if r.part.signed {
+		return w
+	}
+	if r.part.mediaType == "message/partial" {
+		// the fragment may end anywhere in the original message, which is fixed once
+		// reassembled
+		return w
+	}
+	if r.part.encoding == "x-uuencode" && r.opts.mapEncodings && !r.part.isContainer() {
+		// fix: encode an uuencoded body as base64
+		r.fixed(FixUnknownEncodings)
+		r.setEncoding("base64")
+		r.part.encoding = "base64"
+		return newUUDecoder(w)
+	}
+	if r.expandsTNEF() {
+		r.part.held = true
+		return newTNEFExpander(r, w)
+	}
+	if r.part.isContainer() {
+		if !r.part.opaque && r.opts.fixContainerEncoding && !validContainerEncoding(r.part.encoding) && !r.part.decoded {
+			if r.part.embedded {
+				// fix: remove the encoding of an embedded message, which is parsed as not encoded
+				r.fixed(FixContainerEncoding)
+				r.removeEncoding()
+			} else {
+				w = &containerChecker{r: r, next: w}
+				r.part.held = true
+			}
+		}
+		if r.opts.fixUnusedBoundaries && r.part.boundary != "" && !r.part.held {
+			w = &boundaryChecker{r: r, next: w}
+			r.part.held = true
+		}
+		return w
+	}
+	if r.opts.binary {
+		if r.part.rewriteEncoding(r.header) {
+			r.fixed(FixDecodeEncoding)
+		}
+		w = r.part.decoder(out, w)
+	} else if r.encodesAttachment() {
+		if r.part.encoding != "base64" {
+			// fix: encode the attachment as base64
+			r.fixed(FixEncodeAttachments)
+			r.setEncoding("base64")
+		}
+		w = newBase64Encoder(&r.part, w)
+	}
+	if r.holdsHeader() {
+		w = &encodingChecker{r: r, next: w}
+		r.part.held = true
+	}
+	if r.opts.transcode {
+		w = r.transcode(w)
+	}
+	if r.opts.replaceUTF8 {
+		w = r.replaceUTF8(w)
+	}
+	if r.extractsFiles() {
+		// the files are extracted before the text fixes, which would break yEnc-encoded
+		// files
+		w = &fileExtractor{r: r, next: w}
+		r.part.held = true
+	}
+	if r.opts.wrapQP && r.part.encoding == "quoted-printable" {
+		w = qpWrapper{next: w, report: r.bodyReporter()}
+	}
+	if r.opts.fixQuotedPrintable && r.part.encoding == "quoted-printable" {
+		w = &qpRepairer{next: w, report: r.bodyReporter()}
+	}
+	if r.opts.fixBase64Padding && r.part.encoding == "base64" {
+		w = &base64Repairer{next: w, report: r.bodyReporter()}
+	}
+	if r.opts.normalizeBase64 && r.part.encoding == "base64" {
+		w = base64Normalizer{next: w, report: r.bodyReporter()}
+	}
+	if r.opts.fixDoubleQP && r.part.encoding == "quoted-printable" {
+		w = &doubleQPDecoder{next: w, report: r.bodyReporter()}
+	}
+	if (r.opts.unescapeFrom || r.fromLine && r.opts.detectFromLine) && (r.part.mediaType == "" || strings.HasPrefix(r.part.mediaType, "text/")) {
+		w = fromUnescaper{next: w, report: r.bodyReporter()}
+	}
+	h := &Header{r: r}
+	for i := len(r.opts.fixers) - 1; i >= 0; i-- {
+		if fix := r.opts.fixers[i].FixBody(h); fix != nil {
+			w = lineFixer{fix: fix, next: w}
+		}
+	}
+	return w
+}
+
+// fromUnescaper removes the mbox quoting of lines starting with From.
+type fromUnescaper struct {
+	next   lineWriter
+	report reporter
+}
+
+func (u fromUnescaper) writeLine(line string) {
+	if strings.HasPrefix(line, ">") && strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+		// fix: unescape mbox From quoting
+		u.report.fixed(FixFromQuoting)
+		line = line[1:]
+	}
+	u.next.writeLine(line)
+}
+
+func (u fromUnescaper) end(delimiter bool) {
+	u.next.end(delimiter)
+}
+
+// qpRepairer fixes invalid quoted-printable lines.
+type qpRepairer struct {
+	next   lineWriter
+	report reporter
+	// last is the last line written, which is held back until it is known whether
+	// it ends the part.
+	last    string
+	hasLast bool
+}
+
+func (q *qpRepairer) writeLine(line string) {
+	if q.hasLast {
+		q.next.writeLine(q.repair(q.last, false))
+	}
+	q.last = line
+	q.hasLast = true
+}
+
+func (q *qpRepairer) end(delimiter bool) {
+	if q.hasLast {
+		q.next.writeLine(q.repair(q.last, true))
+		q.hasLast = false
+	}
+	q.next.end(delimiter)
+}
+
+func (q *qpRepairer) repair(line string, last bool) string {
+	repaired := repairQP(line, last)
+	if repaired != line {
+		q.report.fixed(FixQuotedPrintable)
+	}
+	return repaired
+}
+
+const upperHex = "0123456789ABCDEF"
+
+// repairQP returns a valid quoted-printable encoding of a possibly invalid
+// quoted-printable line. last is set for the last line of the part.
+func repairQP(line string, last bool) string {
+	trimmed := trimRight(line)
+	soft := len(trimmed) > 0 && trimmed[len(trimmed)-1] == '='
+	if soft {
+		// fix: remove whitespace after a soft line break
+		line = trimmed[:len(trimmed)-1]
+	}
+	if soft && last {
+		// fix: remove a soft line break ending the part, protecting the whitespace it preceded
+		soft = false
+		trimmed = trimRight(line)
+		if len(trimmed) < len(line) {
+			line = trimmed + qpEscape(line[len(trimmed)]) + line[len(trimmed)+1:]
+		}
+	}
+	var sb strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '=' && i+2 < len(line) && isHex(line[i+1]) && isHex(line[i+2]):
+			// fix: use uppercase hexadecimal digits
+			sb.WriteByte('=')
+			sb.WriteByte(upperHex[unhex(line[i+1])])
+			sb.WriteByte(upperHex[unhex(line[i+2])])
+			i += 2
+		case c == '=' || c != '\t' && (c < ' ' || c > '~'):
+			// fix: escape a stray equal sign or an unescaped byte
+			sb.WriteString(qpEscape(c))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if soft {
+		sb.WriteByte('=')
+	}
+	return sb.String()
+}
+
+func qpEscape(c byte) string {
+	return string([]byte{'=', upperHex[c>>4], upperHex[c&0x0f]})
+}
+
+// base64Repairer fixes the length of the last base64 quantum of a part.
+type base64Repairer struct {
+	next   lineWriter
+	report reporter
+	// held are the last line with base64 characters and the lines following it.
+	held []string
+	// n is the count of characters of the last quantum, of which pad are padding.
+	n   int
+	pad int
+}
+
+func (b *base64Repairer) writeLine(line string) {
+	chars := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if !isBase64(c) && c != '=' {
+			continue
+		}
+		chars = true
+		if c == '=' {
+			b.pad++
+		}
+		b.n++
+		if b.n == 4 {
+			b.n = 0
+			b.pad = 0
+		}
+	}
+	if !chars && len(b.held) > 0 {
+		b.held = append(b.held, line)
+		return
+	}
+	for _, l := range b.held {
+		b.next.writeLine(l)
+	}
+	b.held = append(b.held[:0], line)
+}
+
+func (b *base64Repairer) end(delimiter bool) {
+	if len(b.held) > 0 {
+		b.next.writeLine(b.repair(b.held[0]))
+		for _, l := range b.held[1:] {
+			b.next.writeLine(l)
+		}
+		b.held = b.held[:0]
+	}
+	b.n = 0
+	b.pad = 0
+	b.next.end(delimiter)
+}
+
+// repair returns the last line of the part with base64 characters, with its last quantum
+// fixed.
+func (b *base64Repairer) repair(line string) string {
+	if b.n == 0 {
+		return line
+	}
+	line = trimRight(line)
+	if b.n-b.pad >= 2 {
+		// fix: pad the truncated last quantum
+		b.report.fixed(FixBase64Padding)
+		return line + strings.Repeat("=", 4-b.n)
+	}
+	// fix: remove the last quantum, which cannot be decoded, if it is on the last line
+	n := b.n
+	i := len(line)
+	for i > 0 && n > 0 {
+		i--
+		if c := line[i]; isBase64(c) || c == '=' {
+			n--
+		}
+	}
+	if n > 0 {
+		return line
+	}
+	b.report.fixed(FixBase64Padding)
+	return line[:i]
+}
+
+// maxBase64Line is the length of the lines of base64 part bodies written by base64Normalizer.
+const maxBase64Line = 76
+
+// base64Normalizer strips the whitespace of base64 lines, and splits the lines longer than
+// maxBase64Line.
+type base64Normalizer struct {
+	next   lineWriter
+	report reporter
+}
+
+func (b base64Normalizer) writeLine(line string) {
+	stripped := strings.Map(func(c rune) rune {
+		if c == ' ' || c == '\t' {
+			return -1
+		}
+		return c
+	}, line)
+	if stripped != line || len(stripped) > maxBase64Line {
+		// fix: normalize an irregular base64 line
+		b.report.fixed(FixBase64Lines)
+	}
+	for len(stripped) > maxBase64Line {
+		b.next.writeLine(stripped[:maxBase64Line])
+		stripped = stripped[maxBase64Line:]
+	}
+	b.next.writeLine(stripped)
+}
+
+func (b base64Normalizer) end(delimiter bool) {
+	b.next.end(delimiter)
+}
+
+// holdsHeader reports whether the header block of the current part must be held back
+// until its body is checked.
+func (r *Reader) holdsHeader() bool {
+	return r.opts.encodingMismatch != EncodingMismatchIgnore && (r.part.encoding == "" || r.part.encoding == "7bit") && !r.encodesAttachment()
+}
+
+// maxHeldBody is the maximum size of a part body held back while checking its content.
+const maxHeldBody = 1 << 20
+
+// encodingChecker fixes 7bit parts containing 8-bit bytes. The header block is held back
+// until an 8-bit byte is found or the part ends, since it may have to be rewritten.
+type encodingChecker struct {
+	r     *Reader
+	next  lineWriter
+	lines []string
+	size  int
+	// fixed is set once the part is known to contain 8-bit bytes.
+	fixed bool
+}
+
+func (c *encodingChecker) writeLine(line string) {
+	if !c.r.part.held {
+		c.write(line)
+		return
+	}
+	c.lines = append(c.lines, line)
+	c.size += len(line)
+	if has8Bit(line) {
+		c.release(true)
+	} else if c.size > maxHeldBody {
+		// the fix is harmless for 7-bit content: apply it rather than holding more lines
+		c.release(true)
+	}
+}
+
+func (c *encodingChecker) end(delimiter bool) {
+	if c.r.part.held {
+		c.release(false)
+	}
+	c.next.end(delimiter)
+}
+
+func (c *encodingChecker) release(fix bool) {
+	if fix {
+		// fix: declare the actual encoding of the part
+		c.r.fixed(FixEncodingMismatch)
+		c.fixed = true
+		encoding := "8bit"
+		if c.r.opts.encodingMismatch == EncodingMismatchQuotedPrintable {
+			encoding = "quoted-printable"
+		}
+		c.r.setEncoding(encoding)
+	}
+	c.r.releaseHeader()
+	for _, line := range c.lines {
+		c.write(line)
+	}
+	c.lines = nil
+}
+
+func (c *encodingChecker) write(line string) {
+	if c.fixed && c.r.opts.encodingMismatch == EncodingMismatchQuotedPrintable {
+		for _, l := range encodeQP([]byte(line)) {
+			c.next.writeLine(l)
+		}
+		return
+	}
+	c.next.writeLine(line)
+}
+
+func has8Bit(line string) bool {
+	for i := 0; i < len(line); i++ {
+		if line[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
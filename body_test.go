@@ -0,0 +1,226 @@
+package messagefix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFixQuotedPrintable(t *testing.T) {
+	const header = "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n"
+	opts := []Option{WithFixQuotedPrintable(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "valid",
+			input: header + "caf=C3=A9 a=3Db=\r\nc\r\n",
+			opts:  opts,
+			want:  header + "caf=C3=A9 a=3Db=\r\nc\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "stray equal signs",
+			input: header + "a=b =zz 100%=\r\n",
+			opts:  opts,
+			want:  header + "a=3Db =3Dzz 100%\r\n",
+			fixes: []FixID{FixQuotedPrintable},
+		},
+		{
+			name:  "lowercase hexadecimal digits",
+			input: header + "caf=c3=a9\r\n",
+			opts:  opts,
+			want:  header + "caf=C3=A9\r\n",
+			fixes: []FixID{FixQuotedPrintable},
+		},
+		{
+			name:  "8-bit and control bytes",
+			input: header + "caf\xc3\xa9\x01\r\n",
+			opts:  opts,
+			want:  header + "caf=C3=A9=01\r\n",
+			fixes: []FixID{FixQuotedPrintable},
+		},
+		{
+			name:  "trailing whitespace",
+			input: header + "a \t\r\nb= \r\nc\r\n",
+			opts:  opts,
+			want:  header + "a \t\r\nb=\r\nc\r\n",
+			fixes: []FixID{FixQuotedPrintable},
+		},
+		{
+			name:  "final soft line break",
+			input: header + "end=\r\n",
+			opts:  opts,
+			want:  header + "end\r\n",
+			fixes: []FixID{FixQuotedPrintable},
+		},
+		{
+			name:  "final soft line break before a delimiter",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "end=\r\n--b--\r\n",
+			opts:  opts,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "end\r\n--b--\r\n",
+			fixes: []FixID{FixQuotedPrintable},
+		},
+		{
+			name:  "disabled",
+			input: header + "a=b =zz\r\n",
+			want:  header + "a=b =zz\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
+
+func TestFixBase64Padding(t *testing.T) {
+	const header = "Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n"
+	opts := []Option{WithFixBase64Padding(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "padded",
+			input: header + "aGVsbG8=\r\n",
+			opts:  opts,
+			want:  header + "aGVsbG8=\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "missing padding",
+			input: header + "aGVsbG8\r\n",
+			opts:  opts,
+			want:  header + "aGVsbG8=\r\n",
+			fixes: []FixID{FixBase64Padding},
+		},
+		{
+			name:  "missing padding of two characters",
+			input: header + "aGVsbA\r\n",
+			opts:  opts,
+			want:  header + "aGVsbA==\r\n",
+			fixes: []FixID{FixBase64Padding},
+		},
+		{
+			name:  "undecodable last quantum",
+			input: header + "aGVsbG8gd\r\n",
+			opts:  opts,
+			want:  header + "aGVsbG8g\r\n",
+			fixes: []FixID{FixBase64Padding},
+		},
+		{
+			name:  "truncated across lines",
+			input: header + "aGVs\r\nbG8\r\n\r\n",
+			opts:  opts,
+			want:  header + "aGVs\r\nbG8=\r\n\r\n",
+			fixes: []FixID{FixBase64Padding},
+		},
+		{
+			name:  "truncated before a delimiter",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "aGVsbG8\r\n--b\r\n\r\ntext\r\n--b--\r\n",
+			opts:  opts,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "aGVsbG8=\r\n--b\r\n\r\ntext\r\n--b--\r\n",
+			fixes: []FixID{FixBase64Padding},
+		},
+		{
+			name:  "truncated at EOF",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "aGVsbG8",
+			opts:  opts,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "aGVsbG8=\r\n--b--\r\n",
+			fixes: []FixID{FixBase64Padding, FixCloseMultiparts},
+		},
+		{
+			name:  "disabled",
+			input: header + "aGVsbG8\r\n",
+			want:  header + "aGVsbG8\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
+
+func TestEncodingMismatch(t *testing.T) {
+	runFixTests(t, []fixTest{
+		{
+			name:  "8bit",
+			input: "Subject: test\r\n\r\nascii\r\ncaf\xc3\xa9\r\n",
+			opts:  []Option{WithEncodingMismatch(EncodingMismatch8Bit)},
+			want:  "Subject: test\r\nContent-Transfer-Encoding: 8bit\r\n\r\nascii\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{FixEncodingMismatch},
+		},
+		{
+			name:  "8bit of a 7bit part",
+			input: "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 7bit\r\n\r\ncaf\xc3\xa9\r\n",
+			opts:  []Option{WithEncodingMismatch(EncodingMismatch8Bit)},
+			want:  "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{FixEncodingMismatch},
+		},
+		{
+			name:  "quoted-printable",
+			input: "Subject: test\r\n\r\nascii\r\ncaf\xc3\xa9 a=b\r\n",
+			opts:  []Option{WithEncodingMismatch(EncodingMismatchQuotedPrintable)},
+			want:  "Subject: test\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nascii\r\ncaf=C3=A9 a=3Db\r\n",
+			fixes: []FixID{FixEncodingMismatch},
+		},
+		{
+			name:  "part of a multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nascii\r\n--b\r\n\r\ncaf\xc3\xa9\r\n--b--\r\n",
+			opts:  []Option{WithEncodingMismatch(EncodingMismatch8Bit)},
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nascii\r\n--b\r\nContent-Transfer-Encoding: 8bit\r\n\r\ncaf\xc3\xa9\r\n--b--\r\n",
+			fixes: []FixID{FixEncodingMismatch},
+		},
+		{
+			name:  "7-bit part",
+			input: "Subject: test\r\n\r\nascii\r\n",
+			opts:  []Option{WithEncodingMismatch(EncodingMismatch8Bit)},
+			want:  "Subject: test\r\n\r\nascii\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "8bit part",
+			input: "Content-Transfer-Encoding: 8bit\r\n\r\ncaf\xc3\xa9\r\n",
+			opts:  []Option{WithEncodingMismatch(EncodingMismatchQuotedPrintable)},
+			want:  "Content-Transfer-Encoding: 8bit\r\n\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "ignored",
+			input: "Subject: test\r\n\r\ncaf\xc3\xa9\r\n",
+			want:  "Subject: test\r\n\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
+
+func TestNormalizeBase64(t *testing.T) {
+	const header = "Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n"
+	long := strings.Repeat("QUJD", 40)
+	opts := []Option{WithNormalizeBase64(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "whitespace",
+			input: header + "aGVs bG8g\td29y\r\nbGQ= \r\n",
+			opts:  opts,
+			want:  header + "aGVsbG8gd29y\r\nbGQ=\r\n",
+			fixes: []FixID{FixBase64Lines, FixBase64Lines},
+		},
+		{
+			name:  "long line",
+			input: header + long + "\r\n",
+			opts:  opts,
+			want:  header + long[:76] + "\r\n" + long[76:152] + "\r\n" + long[152:] + "\r\n",
+			fixes: []FixID{FixBase64Lines},
+		},
+		{
+			name:  "long line with whitespace",
+			input: header + long[:80] + " " + long[80:] + "\r\n",
+			opts:  opts,
+			want:  header + long[:76] + "\r\n" + long[76:152] + "\r\n" + long[152:] + "\r\n",
+			fixes: []FixID{FixBase64Lines},
+		},
+		{
+			name:  "regular lines",
+			input: header + long[:76] + "\r\n" + long[76:152] + "\r\n",
+			opts:  opts,
+			want:  header + long[:76] + "\r\n" + long[76:152] + "\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "text part",
+			input: "Content-Type: text/plain\r\n\r\naGVs bG8g\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain\r\n\r\naGVs bG8g\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,310 @@
+package messagefix
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BodyStructure returns the IMAP BODYSTRUCTURE of the entity (RFC 3501), or its BODY if
+// extended is not set, from the fixed message.
+//
+// Only the header blocks of the entities and the bodies of text and message parts, whose
+// lines are counted, are read from message at the offsets of the structure: the fixed message
+// is not parsed again. Invalid Content-Type fields default to text/plain.
+func (p *Part) BodyStructure(message io.ReaderAt, extended bool) (string, error) {
+	w := imapWriter{message: message, extended: extended}
+	if err := w.body(p); err != nil {
+		return "", err
+	}
+	return w.b.String(), nil
+}
+
+// Envelope returns the IMAP ENVELOPE of the entity (RFC 3501), from the fixed message.
+// The entity is usually the message itself or an embedded message.
+func (p *Part) Envelope(message io.ReaderAt) (string, error) {
+	w := imapWriter{message: message}
+	h, err := w.header(p)
+	if err != nil {
+		return "", err
+	}
+	w.envelope(h)
+	return w.b.String(), nil
+}
+
+// imapWriter writes IMAP structures of the entities of message.
+type imapWriter struct {
+	b        strings.Builder
+	message  io.ReaderAt
+	extended bool
+}
+
+// header returns the header block of p, as parsed by net/textproto.
+func (w *imapWriter) header(p *Part) (textproto.MIMEHeader, error) {
+	b := make([]byte, p.HeaderSize)
+	if n, err := w.message.ReadAt(b, p.Offset); n < len(b) {
+		return nil, err
+	}
+	// the fixed header block may still be rejected by net/textproto without WithProfile:
+	// keep the fields parsed before the error
+	h, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(b))).ReadMIMEHeader()
+	if h == nil {
+		h = make(textproto.MIMEHeader)
+	}
+	return h, nil
+}
+
+// lines returns the count of lines of the body of p.
+func (w *imapWriter) lines(p *Part) (int, error) {
+	var c lineCounter
+	if _, err := io.Copy(&c, io.NewSectionReader(w.message, p.Offset+p.HeaderSize, p.BodySize)); err != nil {
+		return 0, err
+	}
+	if c.partial {
+		c.n++
+	}
+	return c.n, nil
+}
+
+func (w *imapWriter) body(p *Part) error {
+	h, err := w.header(p)
+	if err != nil {
+		return err
+	}
+	mediaType, params := parseContentType(h.Get("Content-Type"))
+	typ, subtype, ok := strings.Cut(p.MediaType, "/")
+	if !ok || subtype == "" {
+		typ, subtype = "text", "plain"
+		params = map[string]string{"charset": "us-ascii"}
+	} else if mediaType != p.MediaType {
+		params = nil
+	}
+
+	if typ == "multipart" && p.Boundary != "" {
+		w.b.WriteByte('(')
+		if len(p.Children) == 0 {
+			// a multipart must have at least one part
+			w.b.WriteString(`("text" "plain" ("charset" "us-ascii") NIL NIL "7bit" 0 0)`)
+		}
+		for _, c := range p.Children {
+			if err := w.body(c); err != nil {
+				return err
+			}
+		}
+		w.b.WriteByte(' ')
+		w.string(subtype)
+		if w.extended {
+			w.b.WriteByte(' ')
+			w.params(params)
+			w.extension(h)
+		}
+		w.b.WriteByte(')')
+		return nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding")))
+	if encoding == "" {
+		encoding = "7bit"
+	}
+	w.b.WriteByte('(')
+	w.string(typ)
+	w.b.WriteByte(' ')
+	w.string(subtype)
+	w.b.WriteByte(' ')
+	w.params(params)
+	w.b.WriteByte(' ')
+	w.nstring(strings.TrimSpace(h.Get("Content-ID")))
+	w.b.WriteByte(' ')
+	w.nstring(strings.TrimSpace(h.Get("Content-Description")))
+	w.b.WriteByte(' ')
+	w.string(encoding)
+	w.b.WriteByte(' ')
+	w.b.WriteString(strconv.FormatInt(p.BodySize, 10))
+	embedded := len(p.Children) == 1 && p.Children[0].Embedded
+	if embedded {
+		eh, err := w.header(p.Children[0])
+		if err != nil {
+			return err
+		}
+		w.b.WriteByte(' ')
+		w.envelope(eh)
+		w.b.WriteByte(' ')
+		if err := w.body(p.Children[0]); err != nil {
+			return err
+		}
+	}
+	if embedded || typ == "text" {
+		n, err := w.lines(p)
+		if err != nil {
+			return err
+		}
+		w.b.WriteByte(' ')
+		w.b.WriteString(strconv.Itoa(n))
+	}
+	if w.extended {
+		w.b.WriteByte(' ')
+		w.nstring(strings.TrimSpace(h.Get("Content-MD5")))
+		w.extension(h)
+	}
+	w.b.WriteByte(')')
+	return nil
+}
+
+// extension writes the disposition, language and location extension data of an entity.
+func (w *imapWriter) extension(h textproto.MIMEHeader) {
+	w.b.WriteByte(' ')
+	if v := h.Get("Content-Disposition"); v != "" {
+		disposition, params := parseContentType(v)
+		w.b.WriteByte('(')
+		w.string(disposition)
+		w.b.WriteByte(' ')
+		w.params(params)
+		w.b.WriteByte(')')
+	} else {
+		w.b.WriteString("NIL")
+	}
+	w.b.WriteByte(' ')
+	var languages []string
+	for _, l := range strings.Split(h.Get("Content-Language"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			languages = append(languages, l)
+		}
+	}
+	switch len(languages) {
+	case 0:
+		w.b.WriteString("NIL")
+	case 1:
+		w.string(languages[0])
+	default:
+		w.b.WriteByte('(')
+		for i, l := range languages {
+			if i > 0 {
+				w.b.WriteByte(' ')
+			}
+			w.string(l)
+		}
+		w.b.WriteByte(')')
+	}
+	w.b.WriteByte(' ')
+	w.nstring(strings.TrimSpace(h.Get("Content-Location")))
+}
+
+func (w *imapWriter) envelope(h textproto.MIMEHeader) {
+	from := h.Get("From")
+	w.b.WriteByte('(')
+	w.nstring(strings.TrimSpace(h.Get("Date")))
+	w.b.WriteByte(' ')
+	w.nstring(strings.TrimSpace(h.Get("Subject")))
+	for _, key := range []string{"From", "Sender", "Reply-To", "To", "Cc", "Bcc"} {
+		v := h.Get(key)
+		if v == "" && (key == "Sender" || key == "Reply-To") {
+			v = from
+		}
+		w.b.WriteByte(' ')
+		w.addresses(v)
+	}
+	w.b.WriteByte(' ')
+	w.nstring(strings.TrimSpace(h.Get("In-Reply-To")))
+	w.b.WriteByte(' ')
+	w.nstring(strings.TrimSpace(h.Get("Message-Id")))
+	w.b.WriteByte(')')
+}
+
+func (w *imapWriter) addresses(v string) {
+	list, err := mail.ParseAddressList(v)
+	if err != nil || len(list) == 0 {
+		w.b.WriteString("NIL")
+		return
+	}
+	w.b.WriteByte('(')
+	for _, a := range list {
+		name := a.Name
+		if has8Bit(name) {
+			name = mime.QEncoding.Encode("utf-8", name)
+		}
+		mailbox, host := a.Address, ""
+		if i := strings.LastIndexByte(mailbox, '@'); i >= 0 {
+			mailbox, host = mailbox[:i], mailbox[i+1:]
+		}
+		w.b.WriteByte('(')
+		w.nstring(name)
+		w.b.WriteString(" NIL ")
+		w.nstring(mailbox)
+		w.b.WriteByte(' ')
+		w.nstring(host)
+		w.b.WriteByte(')')
+	}
+	w.b.WriteByte(')')
+}
+
+// params writes a parenthesized list of parameters, sorted by name, or NIL.
+func (w *imapWriter) params(params map[string]string) {
+	if len(params) == 0 {
+		w.b.WriteString("NIL")
+		return
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.b.WriteByte('(')
+	for i, k := range keys {
+		if i > 0 {
+			w.b.WriteByte(' ')
+		}
+		w.string(k)
+		w.b.WriteByte(' ')
+		w.string(params[k])
+	}
+	w.b.WriteByte(')')
+}
+
+// nstring writes s, or NIL if it is empty.
+func (w *imapWriter) nstring(s string) {
+	if s == "" {
+		w.b.WriteString("NIL")
+		return
+	}
+	w.string(s)
+}
+
+// string writes s as a quoted string, or as a literal if it cannot be quoted.
+func (w *imapWriter) string(s string) {
+	if strings.ContainsAny(s, "\r\n\x00") || has8Bit(s) {
+		w.b.WriteByte('{')
+		w.b.WriteString(strconv.Itoa(len(s)))
+		w.b.WriteString("}\r\n")
+		w.b.WriteString(s)
+		return
+	}
+	w.b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			w.b.WriteByte('\\')
+		}
+		w.b.WriteByte(s[i])
+	}
+	w.b.WriteByte('"')
+}
+
+// lineCounter counts the lines written to it.
+type lineCounter struct {
+	n int
+	// partial is set when the last line written has no line terminator.
+	partial bool
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		c.n += bytes.Count(p, []byte("\n"))
+		c.partial = p[len(p)-1] != '\n'
+	}
+	return len(p), nil
+}
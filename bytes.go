@@ -0,0 +1,27 @@
+package messagefix
+
+import (
+	"bytes"
+	"strings"
+)
+
+// FixBytes returns the fixed form of a message already in memory.
+func FixBytes(message []byte, opts ...Option) ([]byte, error) {
+	var b bytes.Buffer
+	// fixes are local, so the fixed message is usually about the size of the original
+	b.Grow(len(message) + len(message)/16)
+	if _, err := NewReader(bytes.NewReader(message), opts...).WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// FixString returns the fixed form of a message already in memory, as a string.
+func FixString(message string, opts ...Option) (string, error) {
+	var b strings.Builder
+	b.Grow(len(message) + len(message)/16)
+	if _, err := NewReader(strings.NewReader(message), opts...).WriteTo(&b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,141 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// charsetAliases maps common bogus charset labels, lowercased, to their IANA preferred MIME name.
+var charsetAliases = map[string]string{
+	"utf8":              "UTF-8",
+	"utf_8":             "UTF-8",
+	"unicode-1-1-utf-8": "UTF-8",
+	// mail generators labelling content as unicode almost always mean UTF-8
+	"unicode":   "UTF-8",
+	"ascii":     "US-ASCII",
+	"us_ascii":  "US-ASCII",
+	"usascii":   "US-ASCII",
+	"ansi":      "windows-1252",
+	"latin1":    "ISO-8859-1",
+	"latin-1":   "ISO-8859-1",
+	"sjis":      "Shift_JIS",
+	"x-sjis":    "Shift_JIS",
+	"shift-jis": "Shift_JIS",
+	"shiftjis":  "Shift_JIS",
+	"eucjp":     "EUC-JP",
+	"euc_jp":    "EUC-JP",
+	"x-euc-jp":  "EUC-JP",
+	"euckr":     "EUC-KR",
+	"euc_kr":    "EUC-KR",
+	"koi8r":     "KOI8-R",
+	"koi8_r":    "KOI8-R",
+	"koi8u":     "KOI8-U",
+	"koi8_u":    "KOI8-U",
+	"gbk":       "GBK",
+	"cp936":     "GBK",
+	"big-5":     "Big5",
+	"big_5":     "Big5",
+}
+
+// canonicalCharset returns the IANA preferred MIME name of a bogus charset label, if known.
+func canonicalCharset(label string) (string, bool) {
+	l := strings.ToLower(label)
+	name, ok := charsetAliases[l]
+	if !ok {
+		if digits := charsetNumber(l, "iso", "8859"); digits != "" {
+			name, ok = "ISO-8859-"+digits, true
+		} else {
+			for _, prefix := range []string{"windows", "win", "cp"} {
+				if digits := charsetNumber(l, prefix, "125"); len(digits) == 1 {
+					name, ok = "windows-125"+digits, true
+					break
+				}
+			}
+		}
+	}
+	if !ok || strings.EqualFold(name, label) {
+		return "", false
+	}
+	return name, true
+}
+
+// charsetNumber matches labels such as "iso8859-1" or "iso_8859_1" made of a prefix,
+// a number and a suffix of digits, separated by optional dashes or underscores, returning
+// the suffix digits, or "" if the label does not match.
+func charsetNumber(l, prefix, number string) string {
+	if !strings.HasPrefix(l, prefix) {
+		return ""
+	}
+	l = strings.TrimLeft(l[len(prefix):], "-_ ")
+	if !strings.HasPrefix(l, number) {
+		return ""
+	}
+	l = strings.TrimLeft(l[len(number):], "-_ ")
+	if l == "" || strings.Trim(l, "0123456789") != "" {
+		return ""
+	}
+	return l
+}
+
+// normalizeCharset rewrites the charset parameter of the Content-Type field to its
+// canonical name, in place so that the field folding is kept. It reports whether the
+// header was fixed.
+func normalizeCharset(header []*field) bool {
+	f := lookup(header, "Content-Type")
+	if f == nil {
+		return false
+	}
+	fixed := false
+	for i, line := range f.lines {
+		start, end := findParam(line, "charset")
+		if start < 0 {
+			continue
+		}
+		if name, ok := canonicalCharset(line[start:end]); ok && name != line[start:end] {
+			// fix: use the canonical charset name
+			f.lines[i] = line[:start] + name + line[end:]
+			fixed = true
+		}
+	}
+	return fixed
+}
+
+// findParam returns the bounds of the value of the passed parameter in a header line,
+// excluding any quotes, or -1 if it is not found.
+func findParam(line, key string) (start, end int) {
+	lower := strings.ToLower(line)
+	for i := 0; ; {
+		j := strings.Index(lower[i:], key)
+		if j < 0 {
+			return -1, -1
+		}
+		j += i
+		i = j + len(key)
+		if j > 0 && !strings.ContainsRune("; \t", rune(line[j-1])) {
+			continue
+		}
+		k := i
+		for k < len(line) && (line[k] == ' ' || line[k] == '\t') {
+			k++
+		}
+		if k == len(line) || line[k] != '=' {
+			continue
+		}
+		k++
+		for k < len(line) && (line[k] == ' ' || line[k] == '\t') {
+			k++
+		}
+		if k < len(line) && line[k] == '"' {
+			k++
+			end := strings.IndexByte(line[k:], '"')
+			if end < 0 {
+				end = len(line) - k
+			}
+			return k, k + end
+		}
+		end := k
+		for end < len(line) && !strings.ContainsRune("; \t", rune(line[end])) {
+			end++
+		}
+		return k, end
+	}
+}
@@ -0,0 +1,98 @@
+package messagefix
+
+import (
+	"bytes"
+)
+
+// NextChunk returns the next chunk of the fixed message, of at most max bytes.
+//
+// Chunks always end with a CRLF line terminator, unless a single line is longer than max,
+// in which case it is split across several chunks, or the message does not end with a CRLF.
+// This is suitable for sending the fixed message with SMTP BDAT commands (RFC 3030) without
+// buffering it whole.
+//
+// The returned slice is only valid until the next call to Read or NextChunk.
+// NextChunk returns io.EOF once the whole message has been returned.
+func (r *Reader) NextChunk(max int) ([]byte, error) {
+	if max <= 0 {
+		panic("messagefix: non-positive chunk size")
+	}
+	r.chunk = r.chunk[:0]
+	var err error
+	for len(r.chunk) < max && err == nil {
+		var b []byte
+		if b, err = r.head(); err == nil {
+			n := max - len(r.chunk)
+			if n > len(b) {
+				n = len(b)
+			}
+			r.chunk = append(r.chunk, b[:n]...)
+			r.advance(n)
+		}
+	}
+	if len(r.chunk) == 0 {
+		return nil, err
+	}
+	n := len(r.chunk)
+	if err == nil {
+		if next, err := r.head(); err == nil {
+			if i := bytes.LastIndex(r.chunk, []byte("\r\n")); i >= 0 {
+				n = i + 2
+			} else if n > 1 && r.chunk[n-1] == '\r' && next[0] == '\n' {
+				// avoid splitting a CRLF across chunks
+				n--
+			}
+		}
+	}
+	if n < len(r.chunk) {
+		// return the rest of the chunk to the Reader
+		r.rest = append(append([]byte(nil), r.chunk[n:]...), r.rest...)
+	}
+	r.stats.written(n)
+	return r.chunk[:n], nil
+}
+
+// ReadLines reads the next lines of the fixed message into p, as many complete lines as fit,
+// and returns the count of bytes read.
+//
+// As with NextChunk, the bytes read always end with a CRLF line terminator, unless a single
+// line is longer than p, in which case it is split across several calls, or the message does
+// not end with a CRLF. This is suitable for writers that frame their output by lines, such as
+// NNTP or SMTP relays. ReadLines returns io.EOF once the whole message has been read.
+func (r *Reader) ReadLines(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := r.NextChunk(len(p))
+	return copy(p, b), err
+}
+
+// NextLine returns the next line of the fixed message, along with its CRLF line terminator,
+// unless it is the last line and the message does not end with a CRLF.
+//
+// This is suitable for consumers that process the fixed message line by line, such as
+// dot-stuffing writers, without splitting the output of Read again. The returned slice is
+// only valid until the next call to Read, NextChunk or NextLine. NextLine returns io.EOF once
+// the whole message has been returned.
+func (r *Reader) NextLine() ([]byte, error) {
+	r.chunk = r.chunk[:0]
+	for {
+		b, err := r.head()
+		if err != nil {
+			if len(r.chunk) == 0 {
+				return nil, err
+			}
+			// return the error on the next call
+			break
+		}
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			r.chunk = append(r.chunk, b[:i+1]...)
+			r.advance(i + 1)
+			break
+		}
+		r.chunk = append(r.chunk, b...)
+		r.advance(len(b))
+	}
+	r.stats.written(len(r.chunk))
+	return r.chunk, nil
+}
@@ -0,0 +1,37 @@
+package messagefix
+
+import (
+	"io"
+)
+
+// WithCloseInput enables closing the input io.Reader of the Reader when it is closed, if it
+// is an io.Closer, such as an HTTP response body. The Reader can then be passed to APIs taking
+// an io.ReadCloser in place of its input.
+func WithCloseInput(enabled bool) Option {
+	return func(o *options) {
+		o.closeInput = enabled
+	}
+}
+
+// Close discards any output not returned yet, waits for the parts processed on other
+// goroutines with WithParallelism and removes their temporary files, then closes the input
+// io.Reader if enabled with WithCloseInput. Read then returns ErrClosed.
+//
+// Close is only needed with WithParallelism or WithCloseInput, for Readers that are not
+// read until EOF.
+func (r *Reader) Close() error {
+	if r.err == ErrClosed {
+		return nil
+	}
+	r.discardJobs()
+	r.queue = nil
+	r.buffer = nil
+	r.rest = nil
+	r.stuffed = r.stuffed[:0]
+	r.lf = r.lf[:0]
+	r.err = ErrClosed
+	if c, ok := r.src.(io.Closer); ok && r.opts.closeInput {
+		return c.Close()
+	}
+	return nil
+}
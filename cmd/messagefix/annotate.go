@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/delthas/go-messagefix"
+)
+
+// annotate runs the annotate subcommand with the passed arguments.
+func annotate(args []string) {
+	asJSON := flag.Bool("json", false, "print the annotations as JSON, one object per line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: messagefix annotate [flags] [file or glob...]\n")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+
+	opts, err := options()
+	if err != nil {
+		log.Print(err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *inPlace {
+		log.Fatal("-w is not supported by annotate")
+	}
+
+	paths := expand(flag.Args())
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	failed := false
+	for _, path := range paths {
+		a, err := annotateFile(path, opts)
+		if err != nil {
+			log.Printf("%v: %v", path, err)
+			failed = true
+			continue
+		}
+		if *asJSON {
+			if err := json.NewEncoder(os.Stdout).Encode(a); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		fmt.Printf("%v:\n", path)
+		if err := a.WriteText(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// annotateFile returns the annotation of the message at path, or from the standard input if
+// path is "-".
+func annotateFile(path string, opts []messagefix.Option) (*messagefix.Annotation, error) {
+	if path == "-" {
+		return messagefix.Annotate(os.Stdin, opts...)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return messagefix.Annotate(f, opts...)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/delthas/go-messagefix"
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+)
+
+// consumer is a strict parser of fixed messages.
+type consumer struct {
+	name  string
+	parse func(b []byte) error
+}
+
+var consumers = []consumer{
+	{"net/mail", parseMail},
+	{"mime/multipart", parseMultipart},
+	{"go-message", parseGoMessage},
+}
+
+// compat runs the compat subcommand with the passed arguments.
+func compat(args []string) {
+	names := flag.String("consumers", "net/mail,mime/multipart,go-message", "comma-separated list of the consumers to check")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: messagefix compat [flags] [file or glob...]\n")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+
+	opts, err := options()
+	if err != nil {
+		log.Print(err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	var selected []consumer
+	for _, name := range strings.Split(*names, ",") {
+		c, ok := lookupConsumer(name)
+		if !ok {
+			log.Printf("invalid -consumers value: unknown consumer %q", name)
+			flag.Usage()
+			os.Exit(2)
+		}
+		selected = append(selected, c)
+	}
+	if *inPlace {
+		log.Fatal("-w is not supported by compat")
+	}
+
+	paths := expand(flag.Args())
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	accepted := make([]int, len(selected))
+	failed := false
+	for _, path := range paths {
+		errs, err := check(path, selected, opts)
+		if err != nil {
+			log.Printf("%v: %v", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%v:\n", path)
+		for i, c := range selected {
+			if errs[i] != nil {
+				fmt.Printf("\t%v: rejected: %v\n", c.name, errs[i])
+				failed = true
+			} else {
+				fmt.Printf("\t%v: accepted\n", c.name)
+				accepted[i]++
+			}
+		}
+	}
+	if len(paths) > 1 {
+		for i, c := range selected {
+			fmt.Printf("%v: %v/%v accepted\n", c.name, accepted[i], len(paths))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func lookupConsumer(name string) (consumer, bool) {
+	for _, c := range consumers {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return consumer{}, false
+}
+
+// check fixes the message at path, or from the standard input if path is "-", and returns the
+// error of each consumer parsing the fixed message.
+func check(path string, selected []consumer, opts []messagefix.Option) ([]error, error) {
+	var buf bytes.Buffer
+	if path == "-" {
+		if err := fix(path, os.Stdin, &buf, opts); err != nil {
+			return nil, err
+		}
+	} else if err := fixFile(path, &buf, opts); err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(selected))
+	for i, c := range selected {
+		errs[i] = c.parse(buf.Bytes())
+	}
+	return errs, nil
+}
+
+// parseMail parses a message with net/mail, reading its body.
+func parseMail(b []byte) error {
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, m.Body)
+	return err
+}
+
+// parseMultipart parses a message with net/mail, then each of its multipart and embedded
+// message entities, recursively, with mime.ParseMediaType and mime/multipart.
+func parseMultipart(b []byte) error {
+	m, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return parseEntity(textproto.MIMEHeader(m.Header), m.Body)
+}
+
+func parseEntity(header textproto.MIMEHeader, body io.Reader) error {
+	var mediaType string
+	var params map[string]string
+	if v := header.Get("Content-Type"); v != "" {
+		var err error
+		mediaType, params, err = mime.ParseMediaType(v)
+		if err != nil {
+			return fmt.Errorf("Content-Type %q: %v", v, err)
+		}
+	}
+	switch {
+	case mediaType == "message/rfc822" && header.Get("Content-Transfer-Encoding") == "":
+		m, err := mail.ReadMessage(bufio.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("embedded message: %v", err)
+		}
+		return parseEntity(textproto.MIMEHeader(m.Header), m.Body)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := parseEntity(p.Header, p); err != nil {
+				return err
+			}
+		}
+	default:
+		_, err := io.Copy(io.Discard, body)
+		return err
+	}
+}
+
+// parseGoMessage parses a message with go-message, decoding the body of each of its parts.
+func parseGoMessage(b []byte) error {
+	e, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return e.Walk(func(path []int, e *message.Entity, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.MultipartReader() != nil {
+			return nil
+		}
+		_, err = io.Copy(io.Discard, e.Body)
+		return err
+	})
+}
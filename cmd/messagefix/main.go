@@ -0,0 +1,531 @@
+// Command messagefix fixes broken email messages.
+//
+// Usage:
+//
+//	messagefix [flags] [file or glob...]
+//
+// Without arguments, messagefix reads a message from the standard input and writes the
+// fixed message to the standard output. Otherwise, it fixes each message file, writing the
+// fixed messages to the standard output one after the other, or to the files themselves
+// with -w. With -diff, it writes a unified diff from each message to its fixed form instead,
+// to review the fixes before writing them with -w.
+//
+// The flags enable the options of the messagefix package; run messagefix -h for a list.
+//
+// The compat subcommand fixes each message, then parses the fixed message with a set of strict
+// consumers, and reports which of them accept it:
+//
+//	messagefix compat [flags] [file or glob...]
+//
+// It accepts the same flags, along with -consumers, a comma-separated list of consumers among
+// net/mail, mime/multipart and go-message.
+//
+// The annotate subcommand fixes each message, then prints the tree of its parts with their
+// input line ranges and the fixes applied to them, as text or, with -json, as JSON:
+//
+//	messagefix annotate [flags] [file or glob...]
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/delthas/go-messagefix"
+)
+
+var (
+	inPlace = flag.Bool("w", false, "write the fixed messages to their files instead of the standard output")
+	diff    = flag.Bool("diff", false, "write a unified diff from each message to its fixed form instead of the fixed message")
+	report  = flag.Bool("report", false, "print a report of each message to the standard error")
+	logFix  = flag.Bool("log", false, "log each applied fix to the standard error")
+
+	binary               = flag.Bool("binary", false, "decode the Content-Transfer-Encoding of all leaf parts")
+	fixQuotedPrintable   = flag.Bool("fix-quoted-printable", false, "repair invalid quoted-printable part bodies")
+	fixBase64Padding     = flag.Bool("fix-base64-padding", false, "repair truncated base64 part bodies")
+	fixContainerEncoding = flag.Bool("fix-container-encoding", false, "remove invalid encodings of multipart and message entities")
+	fixUnusedBoundaries  = flag.Bool("fix-unused-boundaries", false, "rewrite multiparts whose boundary never appears as text/plain")
+	quoteBoundaries      = flag.Bool("quote-boundaries", false, "quote boundary parameters that are not tokens")
+	renameBoundaries     = flag.Bool("rename-boundaries", false, "rename boundaries of multiparts colliding with an enclosing multipart")
+	finalEmptyLine       = flag.Bool("final-empty-line", false, "end the fixed messages with an empty line")
+	encodeAttachments    = flag.Bool("encode-attachments", false, "encode the body of attachments as base64")
+	uuAttachments        = flag.Bool("uuencoded-attachments", false, "rewrite messages containing uuencoded files as multipart/mixed with attachments")
+	dispositionFromName  = flag.Bool("disposition-from-name", false, "add a Content-Disposition field to parts only named by their Content-Type")
+	expandTNEF           = flag.Bool("expand-tnef", false, "replace TNEF containers (winmail.dat) with the files they contain")
+	yencAttachments      = flag.Bool("yenc-attachments", false, "rewrite messages containing yEnc-encoded files as multipart/mixed with attachments")
+	foldHeaders          = flag.Int("fold-headers", 0, "fold header lines longer than this width at whitespace, or 0 to disable")
+	foldTraceFields      = flag.Int("fold-trace-fields", 0, "fold Received and Authentication-Results fields longer than this width between their clauses, or 0 to disable")
+	decodeMultiparts     = flag.Bool("decode-multiparts", false, "decode the body of multiparts and embedded messages declared as base64")
+	fixDoubleQP          = flag.Bool("fix-double-qp", false, "decode a layer of quoted-printable parts encoded twice")
+	wrapQP               = flag.Bool("wrap-qp", false, "split quoted-printable lines longer than 76 characters")
+	normalizeBase64      = flag.Bool("normalize-base64", false, "strip whitespace from base64 lines and split the long ones")
+	insertColons         = flag.Bool("insert-colons", false, "insert the missing colon of header lines starting with a known field name")
+	mapEncodings         = flag.Bool("map-encodings", false, "rewrite unknown Content-Transfer-Encoding values to a standard encoding")
+	fixMIMEVersion       = flag.Bool("fix-mime-version", false, "collapse duplicate or invalid MIME-Version fields into a single valid one")
+	fixMisplacedParams   = flag.Bool("fix-misplaced-params", false, "relocate parameters of fields that take none, such as Content-Transfer-Encoding, to Content-Type")
+	stripComments        = flag.Bool("strip-comments", false, "remove the comments of MIME header fields")
+	normalizeCharsets    = flag.Bool("normalize-charsets", false, "rewrite bogus charset labels to their IANA name")
+	empty                = flag.String("empty", "pass-through", "behavior on empty input: pass-through, error or synthesize")
+	headerless           = flag.String("headerless", "ignore", "behavior on input without a header block: ignore, body or synthesize")
+	orphans              = flag.String("orphans", "keep", "behavior on header blocks starting with a continuation line: keep, unindent, promote or drop")
+	blankHeaderLines     = flag.String("blank-header-lines", "keep", "behavior on whitespace-only lines in header blocks: keep, separate or remove")
+	defaultContentType   = flag.String("default-content-type", "", "Content-Type field to add to messages without one, such as \"text/plain; charset=us-ascii\"")
+	sniffContentType     = flag.Bool("sniff-content-type", false, "declare the media type of messages without a Content-Type field from their body")
+	dkim                 = flag.String("dkim", "ignore", "behavior on messages signed with DKIM: ignore, report or safe")
+	contentLength        = flag.String("content-length", "keep", "behavior on Content-Length fields: keep, remove or recompute")
+	transcode            = flag.Bool("transcode", false, "transcode text parts to UTF-8")
+	canonicalKeys        = flag.Bool("canonical-keys", false, "rewrite header field names to their canonical form")
+	replaceInvalidUTF8   = flag.Bool("replace-invalid-utf8", false, "replace invalid UTF-8 in text parts declared as UTF-8 with U+FFFD")
+	stripControls        = flag.Bool("strip-controls", false, "replace control characters in header field bodies with spaces")
+	truncateMailLoops    = flag.Bool("truncate-mail-loops", false, "remove trace fields repeated by a mail loop")
+	encodingMismatch     = flag.String("encoding-mismatch", "ignore", "fix for 7bit parts containing 8-bit bytes: ignore, 8bit or quoted-printable")
+	stripFromLine        = flag.Bool("strip-from-line", false, "remove a leading mbox From_ line")
+	unescapeFrom         = flag.Bool("unescape-from", false, "remove the mbox quoting of >From lines")
+	detectFromLine       = flag.Bool("detect-from-line", false, "remove a leading mbox From_ line, and then the mbox quoting of >From lines")
+	rejoinDelimiters     = flag.Bool("rejoin-delimiters", false, "rejoin boundary delimiter lines split across two lines")
+	priority             = flag.String("priority", "keep", "form of the priority header fields: keep, x-priority, importance or all")
+	autoSubmitted        = flag.String("auto-submitted", "", "stamp the message with an Auto-Submitted field of this value")
+	precedence           = flag.String("precedence", "", "stamp the message with a Precedence field of this value")
+	fixReferences        = flag.Bool("fix-references", false, "rewrite References and In-Reply-To fields as lists of msg-ids")
+	dotUnstuffing        = flag.Bool("dot-unstuffing", false, "read the input as dot-stuffed SMTP DATA")
+	dotStuffing          = flag.Bool("dot-stuffing", false, "write the output as dot-stuffed SMTP DATA")
+	lfOutput             = flag.Bool("lf", false, "write the output with LF line endings")
+	preserveLineEnds     = flag.Bool("preserve-line-endings", false, "keep the line terminators of the lines needing no fix")
+	smtputf8             = flag.Bool("smtputf8", false, "leave raw UTF-8 in header field values untouched, for SMTPUTF8 pipelines")
+	downgradeUTF8        = flag.Bool("downgrade-utf8", false, "downgrade internationalized header fields to ASCII")
+	parallelism          = flag.Int("parallelism", 1, "count of parts processed at once")
+	bufferSize           = flag.Int("buffer-size", 0, "initial size of the line buffer, in bytes")
+	maxBufferSize        = flag.Int("max-buffer-size", 0, "maximum size of the line buffer, in bytes, which is the maximum line length")
+	readSize             = flag.Int("read-size", 0, "maximum size of each read of the input, in bytes")
+	maxHeaderSize        = flag.Int("max-header-size", 0, "maximum size of each header block, in bytes")
+	maxHeaderFields      = flag.Int("max-header-fields", 0, "maximum count of fields of each header block")
+	maxMessageSize       = flag.Int64("max-message-size", 0, "maximum size of each input message, in bytes")
+	reject               = flag.String("reject", "", "comma-separated list of fix IDs to fail on instead of applying them, or all")
+	maxDepth             = flag.Int("max-depth", 0, "maximum nesting depth of parts, past which their body is opaque")
+	preset               = flag.String("preset", "lenient", "level of fixes: lenient, standard or aggressive")
+	profile              = flag.String("profile", "default", "consumer the output must be suitable for: default, stdlib, go-message, dovecot, gmail-import, archive or archive1")
+)
+
+var emptyModes = map[string]messagefix.EmptyMode{
+	"pass-through": messagefix.EmptyPassThrough,
+	"error":        messagefix.EmptyError,
+	"synthesize":   messagefix.EmptySynthesize,
+}
+
+var headerlessModes = map[string]messagefix.HeaderlessMode{
+	"ignore":     messagefix.HeaderlessIgnore,
+	"body":       messagefix.HeaderlessBody,
+	"synthesize": messagefix.HeaderlessSynthesize,
+}
+
+var orphanModes = map[string]messagefix.OrphanMode{
+	"keep":     messagefix.OrphanKeep,
+	"unindent": messagefix.OrphanUnindent,
+	"promote":  messagefix.OrphanPromote,
+	"drop":     messagefix.OrphanDrop,
+}
+
+var blankLineModes = map[string]messagefix.BlankLineMode{
+	"keep":     messagefix.BlankLinesKeep,
+	"separate": messagefix.BlankLinesSeparate,
+	"remove":   messagefix.BlankLinesRemove,
+}
+
+var dkimModes = map[string]messagefix.DKIMMode{
+	"ignore": messagefix.DKIMIgnore,
+	"report": messagefix.DKIMReport,
+	"safe":   messagefix.DKIMSafe,
+}
+
+var contentLengthModes = map[string]messagefix.ContentLengthMode{
+	"keep":      messagefix.ContentLengthKeep,
+	"remove":    messagefix.ContentLengthRemove,
+	"recompute": messagefix.ContentLengthRecompute,
+}
+
+var encodingMismatches = map[string]messagefix.EncodingMismatch{
+	"ignore":           messagefix.EncodingMismatchIgnore,
+	"8bit":             messagefix.EncodingMismatch8Bit,
+	"quoted-printable": messagefix.EncodingMismatchQuotedPrintable,
+}
+
+var priorityForms = map[string]messagefix.PriorityForm{
+	"keep":       messagefix.PriorityKeep,
+	"x-priority": messagefix.PriorityXPriority,
+	"importance": messagefix.PriorityImportance,
+	"all":        messagefix.PriorityAll,
+}
+
+var presets = map[string]messagefix.Preset{
+	"lenient":    messagefix.PresetLenient,
+	"standard":   messagefix.PresetStandard,
+	"aggressive": messagefix.PresetAggressive,
+}
+
+var profiles = map[string]messagefix.Profile{
+	"default":      messagefix.ProfileDefault,
+	"stdlib":       messagefix.ProfileStdlib,
+	"go-message":   messagefix.ProfileGoMessage,
+	"dovecot":      messagefix.ProfileDovecot,
+	"gmail-import": messagefix.ProfileGmailImport,
+	"archive":      messagefix.ProfileArchive,
+	"archive1":     messagefix.ProfileArchive1,
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("messagefix: ")
+	if len(os.Args) > 1 && os.Args[1] == "compat" {
+		compat(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "annotate" {
+		annotate(os.Args[2:])
+		return
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: messagefix [flags] [file or glob...]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       messagefix compat [flags] [file or glob...]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       messagefix annotate [flags] [file or glob...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	opts, err := options()
+	if err != nil {
+		log.Print(err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *inPlace && *diff {
+		log.Print("-w and -diff are incompatible")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if flag.NArg() == 0 {
+		if *inPlace {
+			log.Fatal("-w requires files")
+		}
+		if err := fix("-", os.Stdin, os.Stdout, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	failed := false
+	stdout := bufio.NewWriter(os.Stdout)
+	paths := expand(flag.Args())
+	for _, path := range paths {
+		if *inPlace {
+			err = fixInPlace(path, opts)
+		} else {
+			err = fixFile(path, stdout, opts)
+		}
+		if err != nil {
+			log.Printf("%v: %v", path, err)
+			failed = true
+		}
+	}
+	if err := stdout.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if *report && len(paths) > 1 {
+		printAggregate(&aggregate)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// expand returns the paths of the files matching the passed files or globs.
+func expand(args []string) []string {
+	var paths []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			log.Fatalf("%v: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			// let opening the file report the error
+			matches = []string{arg}
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// options returns the messagefix options set by the flags.
+func options() ([]messagefix.Option, error) {
+	emptyMode, ok := emptyModes[*empty]
+	if !ok {
+		return nil, fmt.Errorf("invalid -empty value: %q", *empty)
+	}
+	headerlessMode, ok := headerlessModes[*headerless]
+	if !ok {
+		return nil, fmt.Errorf("invalid -headerless value: %q", *headerless)
+	}
+	orphanMode, ok := orphanModes[*orphans]
+	if !ok {
+		return nil, fmt.Errorf("invalid -orphans value: %q", *orphans)
+	}
+	blankLineMode, ok := blankLineModes[*blankHeaderLines]
+	if !ok {
+		return nil, fmt.Errorf("invalid -blank-header-lines value: %q", *blankHeaderLines)
+	}
+	dkimMode, ok := dkimModes[*dkim]
+	if !ok {
+		return nil, fmt.Errorf("invalid -dkim value: %q", *dkim)
+	}
+	contentLengthMode, ok := contentLengthModes[*contentLength]
+	if !ok {
+		return nil, fmt.Errorf("invalid -content-length value: %q", *contentLength)
+	}
+	mismatch, ok := encodingMismatches[*encodingMismatch]
+	if !ok {
+		return nil, fmt.Errorf("invalid -encoding-mismatch value: %q", *encodingMismatch)
+	}
+	form, ok := priorityForms[*priority]
+	if !ok {
+		return nil, fmt.Errorf("invalid -priority value: %q", *priority)
+	}
+	pre, ok := presets[*preset]
+	if !ok {
+		return nil, fmt.Errorf("invalid -preset value: %q", *preset)
+	}
+	prof, ok := profiles[*profile]
+	if !ok {
+		return nil, fmt.Errorf("invalid -profile value: %q", *profile)
+	}
+	flagOptions := map[string]messagefix.Option{
+		"binary":                 messagefix.WithBinary(*binary),
+		"fix-quoted-printable":   messagefix.WithFixQuotedPrintable(*fixQuotedPrintable),
+		"fix-base64-padding":     messagefix.WithFixBase64Padding(*fixBase64Padding),
+		"fix-misplaced-params":   messagefix.WithFixMisplacedParams(*fixMisplacedParams),
+		"strip-comments":         messagefix.WithStripComments(*stripComments),
+		"fix-mime-version":       messagefix.WithFixMIMEVersion(*fixMIMEVersion),
+		"map-encodings":          messagefix.WithMapEncodings(*mapEncodings),
+		"fix-double-qp":          messagefix.WithFixDoubleQuotedPrintable(*fixDoubleQP),
+		"wrap-qp":                messagefix.WithWrapQuotedPrintable(*wrapQP),
+		"normalize-base64":       messagefix.WithNormalizeBase64(*normalizeBase64),
+		"insert-colons":          messagefix.WithInsertColons(*insertColons),
+		"fix-container-encoding": messagefix.WithFixContainerEncoding(*fixContainerEncoding),
+		"fix-unused-boundaries":  messagefix.WithFixUnusedBoundaries(*fixUnusedBoundaries),
+		"quote-boundaries":       messagefix.WithQuoteBoundaries(*quoteBoundaries),
+		"rename-boundaries":      messagefix.WithRenameBoundaries(*renameBoundaries),
+		"final-empty-line":       messagefix.WithFinalEmptyLine(*finalEmptyLine),
+		"decode-multiparts":      messagefix.WithDecodeMultiparts(*decodeMultiparts),
+		"encode-attachments":     messagefix.WithEncodeAttachments(*encodeAttachments),
+		"uuencoded-attachments":  messagefix.WithUUEncodedAttachments(*uuAttachments),
+		"yenc-attachments":       messagefix.WithYEncAttachments(*yencAttachments),
+		"expand-tnef":            messagefix.WithExpandTNEF(*expandTNEF),
+		"disposition-from-name":  messagefix.WithDispositionFromName(*dispositionFromName),
+		"fold-headers":           messagefix.WithFoldHeaders(*foldHeaders),
+		"fold-trace-fields":      messagefix.WithFoldTraceFields(*foldTraceFields),
+		"normalize-charsets":     messagefix.WithNormalizeCharsets(*normalizeCharsets),
+		"empty":                  messagefix.WithEmptyMode(emptyMode),
+		"headerless":             messagefix.WithHeaderless(headerlessMode),
+		"orphans":                messagefix.WithOrphanContinuations(orphanMode),
+		"blank-header-lines":     messagefix.WithBlankHeaderLines(blankLineMode),
+		"default-content-type":   messagefix.WithDefaultContentType(*defaultContentType),
+		"sniff-content-type":     messagefix.WithSniffContentType(*sniffContentType),
+		"dkim":                   messagefix.WithDKIM(dkimMode),
+		"content-length":         messagefix.WithContentLength(contentLengthMode),
+		"transcode":              messagefix.WithTranscode(*transcode),
+		"canonical-keys":         messagefix.WithCanonicalKeys(*canonicalKeys),
+		"truncate-mail-loops":    messagefix.WithTruncateMailLoops(*truncateMailLoops),
+		"encoding-mismatch":      messagefix.WithEncodingMismatch(mismatch),
+		"strip-from-line":        messagefix.WithStripFromLine(*stripFromLine),
+		"unescape-from":          messagefix.WithUnescapeFrom(*unescapeFrom),
+		"detect-from-line":       messagefix.WithFromLineDetection(*detectFromLine),
+		"rejoin-delimiters":      messagefix.WithRejoinDelimiters(*rejoinDelimiters),
+		"priority":               messagefix.WithPriority(form),
+		"auto-submitted":         messagefix.WithAutoSubmitted(*autoSubmitted),
+		"precedence":             messagefix.WithPrecedence(*precedence),
+		"fix-references":         messagefix.WithFixReferences(*fixReferences),
+		"dot-unstuffing":         messagefix.WithDotUnstuffing(*dotUnstuffing),
+		"dot-stuffing":           messagefix.WithDotStuffing(*dotStuffing),
+		"lf":                     messagefix.WithLFOutput(*lfOutput),
+		"preserve-line-endings":  messagefix.WithPreserveLineEndings(*preserveLineEnds),
+		"smtputf8":               messagefix.WithSMTPUTF8(*smtputf8),
+		"downgrade-utf8":         messagefix.WithDowngradeUTF8(*downgradeUTF8),
+		"parallelism":            messagefix.WithParallelism(*parallelism),
+		"buffer-size":            messagefix.WithBufferSize(*bufferSize, *maxBufferSize),
+		"max-buffer-size":        messagefix.WithBufferSize(*bufferSize, *maxBufferSize),
+		"read-size":              messagefix.WithReadSize(*readSize),
+		"max-depth":              messagefix.WithMaxDepth(*maxDepth),
+	}
+	limits := messagefix.WithLimits(messagefix.Limits{
+		HeaderSize:   *maxHeaderSize,
+		HeaderFields: *maxHeaderFields,
+		MessageSize:  *maxMessageSize,
+	})
+	for _, name := range []string{"max-header-size", "max-header-fields", "max-message-size"} {
+		flagOptions[name] = limits
+	}
+	if *reject != "" {
+		var ids []messagefix.FixID
+		for _, name := range strings.Split(*reject, ",") {
+			if name == "all" {
+				for _, d := range messagefix.Fixes() {
+					ids = append(ids, d.ID)
+				}
+				continue
+			}
+			if _, ok := messagefix.LookupFix(messagefix.FixID(name)); !ok {
+				return nil, fmt.Errorf("invalid -reject value: unknown fix %q", name)
+			}
+			ids = append(ids, messagefix.FixID(name))
+		}
+		flagOptions["reject"] = messagefix.WithRejectFixes(ids...)
+	}
+	if *replaceInvalidUTF8 {
+		flagOptions["replace-invalid-utf8"] = messagefix.WithReplaceInvalidUTF8("\uFFFD")
+	}
+	if *stripControls {
+		flagOptions["strip-controls"] = messagefix.WithStripControls(" ")
+	}
+	// the flags that were set override the preset and profile
+	opts := []messagefix.Option{
+		messagefix.WithPreset(pre),
+		messagefix.WithProfile(prof),
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if opt, ok := flagOptions[f.Name]; ok {
+			opts = append(opts, opt)
+		}
+	})
+	return opts, nil
+}
+
+// aggregate is the aggregate of the reports of the fixed messages, with -report.
+var aggregate messagefix.Aggregate
+
+// fix writes the fixed message read from r to w.
+func fix(name string, r io.Reader, w io.Writer, opts []messagefix.Option) error {
+	if *logFix {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, nil)).With("file", name)
+		opts = append(opts[:len(opts):len(opts)], messagefix.WithLogger(logger))
+	}
+	if *diff {
+		_, err := messagefix.WriteDiff(w, name, r, opts...)
+		return err
+	}
+	fr := messagefix.NewReader(r, opts...)
+	if _, err := io.Copy(w, fr); err != nil {
+		return err
+	}
+	if *report {
+		printReport(name, fr.Report(), fr.Stats())
+		aggregate.Add(fr.Report())
+	}
+	return nil
+}
+
+func fixFile(path string, w io.Writer, opts []messagefix.Option) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fix(path, f, w, opts)
+}
+
+// fixInPlace fixes a message file, replacing it atomically.
+func fixInPlace(path string, opts []messagefix.Option) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".messagefix-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := fixFile(path, tmp, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the content must be on disk before it replaces the original file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir commits the entries of the directory at path to disk, such as a rename.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func printReport(name string, report messagefix.Report, stats messagefix.Stats) {
+	w := os.Stderr
+	fmt.Fprintf(w, "%v:\n", name)
+	if s := report.Summary; s != nil {
+		fmt.Fprintf(w, "\tsize: %v bytes\n", s.Size)
+		if s.EndedInHeader {
+			fmt.Fprintf(w, "\tended in a header block\n")
+		}
+		for _, boundary := range s.ClosedBoundaries {
+			fmt.Fprintf(w, "\tclosed boundary: %q\n", boundary)
+		}
+		for _, p := range s.Parts {
+			path := p.Path
+			if path == "" {
+				path = "message"
+			}
+			fmt.Fprintf(w, "\tpart %v: %v, header %v bytes, body %v bytes\n", path, p.MediaType, p.HeaderSize, p.BodySize)
+		}
+	}
+	for _, f := range report.Findings {
+		fmt.Fprintf(w, "\t%v at line %v: %v\n", f.Kind, f.Line, f.Detail)
+	}
+	for _, d := range messagefix.Fixes() {
+		if n := stats.Fixes[d.ID]; n > 0 {
+			lossy := ""
+			if d.Lossy {
+				lossy = " (lossy)"
+			}
+			fmt.Fprintf(w, "\tfix %v: applied %v times%v\n", d.ID, n, lossy)
+		}
+	}
+}
+
+// printAggregate prints the aggregate of the reports of several messages.
+func printAggregate(a *messagefix.Aggregate) {
+	w := os.Stderr
+	fmt.Fprintf(w, "total: %v messages, %v fixed\n", a.Messages, a.Fixed)
+	for _, risk := range []messagefix.Risk{messagefix.RiskLow, messagefix.RiskMedium, messagefix.RiskHigh} {
+		if n := a.MaxRisk[risk]; n > 0 {
+			fmt.Fprintf(w, "\t%v risk: %v messages\n", risk, n)
+		}
+	}
+	for _, d := range messagefix.Fixes() {
+		if n := a.Fixes[d.ID]; n > 0 {
+			fmt.Fprintf(w, "\tfix %v: applied %v times to %v messages\n", d.ID, n, a.FixedBy[d.ID])
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package messagefix
+
+import (
+	"bytes"
+	"strings"
+)
+
+// validContainerEncoding reports whether encoding is allowed on a multipart or message
+// entity, which RFC 2045 restricts to the identity encodings.
+func validContainerEncoding(encoding string) bool {
+	switch encoding {
+	case "", "7bit", "8bit", "binary":
+		return true
+	}
+	return false
+}
+
+// removeEncoding removes the Content-Transfer-Encoding fields of the header block.
+func (r *Reader) removeEncoding() {
+	header := r.header[:0]
+	for _, f := range r.header {
+		if !f.is("Content-Transfer-Encoding") {
+			header = append(header, f)
+		}
+	}
+	r.header = header
+}
+
+// containerChecker fixes multiparts declaring an encoding other than an identity encoding,
+// whose body is processed as not encoded. The header block is held back while the preamble is
+// read: the encoding is removed if the preamble is ended by a delimiter of the multipart, and
+// kept otherwise, since its body is then likely actually encoded.
+type containerChecker struct {
+	r     *Reader
+	next  lineWriter
+	lines []string
+	size  int
+	// parted is set when the preamble is ended by a delimiter of the multipart.
+	parted bool
+}
+
+func (c *containerChecker) writeLine(line string) {
+	if !c.r.part.held {
+		c.next.writeLine(line)
+		return
+	}
+	c.lines = append(c.lines, line)
+	c.size += len(line)
+	if c.size > maxHeldBody {
+		c.release(false)
+	}
+}
+
+func (c *containerChecker) end(delimiter bool) {
+	if c.r.part.held {
+		c.release(delimiter && c.parted)
+	}
+	c.next.end(delimiter)
+}
+
+func (c *containerChecker) release(fix bool) {
+	if fix {
+		// fix: remove the encoding of a multipart that is not actually encoded
+		c.r.fixed(FixContainerEncoding)
+		c.r.removeEncoding()
+	}
+	c.r.releaseHeader()
+	for _, line := range c.lines {
+		c.next.writeLine(line)
+	}
+	c.lines = nil
+}
+
+// boundaryChecker fixes multiparts whose boundary never appears in their body. The header
+// block is held back while the preamble is read: the multipart is rewritten as text/plain if
+// the preamble is not ended by a delimiter of the multipart, and kept otherwise.
+type boundaryChecker struct {
+	r     *Reader
+	next  lineWriter
+	lines []string
+	size  int
+	// parted is set when the preamble is ended by a delimiter of the multipart.
+	parted bool
+}
+
+func (c *boundaryChecker) writeLine(line string) {
+	if !c.r.part.held {
+		c.next.writeLine(line)
+		return
+	}
+	c.lines = append(c.lines, line)
+	c.size += len(line)
+	if c.size > maxHeldBody {
+		c.release(false)
+	}
+}
+
+func (c *boundaryChecker) end(delimiter bool) {
+	if c.r.part.held {
+		c.release(!c.parted)
+	}
+	c.next.end(delimiter)
+}
+
+func (c *boundaryChecker) release(fix bool) {
+	r := c.r
+	if fix {
+		// fix: rewrite a multipart whose boundary never appears as text/plain, so that its
+		// body is kept as its content rather than as the preamble of a multipart without parts
+		r.fixed(FixUnusedBoundaries)
+		params := make(map[string]string, len(r.part.params))
+		for k, v := range r.part.params {
+			if k != "boundary" {
+				params[k] = v
+			}
+		}
+		value := formatMediaType("text/plain", params, r.opts.smtputf8)
+		if value == "" {
+			value = "text/plain"
+		}
+		lookup(r.header, "Content-Type").setValue(value)
+		// the boundary of the multipart is the innermost one, since it has no parts
+		n := len(r.delimiters) - 1
+		r.delimiters = r.delimiters[:n]
+		r.containers = r.containers[:n]
+		e := r.entities[len(r.entities)-1]
+		e.mediaType = "text/plain"
+		e.boundary = ""
+		r.part.mediaType = "text/plain"
+		r.part.boundary = ""
+	}
+	r.releaseHeader()
+	for _, line := range c.lines {
+		c.next.writeLine(line)
+	}
+	c.lines = nil
+}
+
+// containerDecoder decodes the body of a multipart or message declared as base64, whose
+// decoded lines are then processed as input lines, so that its entities are fixed as any
+// other entities. Decoded bodies can contain further encoded containers, whose decoders are
+// stacked.
+type containerDecoder struct {
+	// decoding is set once the body was found to be actually encoded; until then, the header
+	// block of the container is held back and empty lines are counted in empty.
+	decoding bool
+	empty    int
+	// embedded is set when the container is a message/rfc822 part.
+	embedded bool
+	dec      base64Decoder
+	out      []byte
+}
+
+// startDecoding makes the Reader decode the body of the current container. Its header block
+// must be held back.
+func (r *Reader) startDecoding() {
+	d := &containerDecoder{embedded: r.part.embedded}
+	d.dec.out = &d.out
+	r.decoders = append(r.decoders, d)
+}
+
+// readLevel processes a line at the passed decoding level: the input lines are at level 0,
+// and the lines decoded by the decoder at level i are at level i+1.
+func (r *Reader) readLevel(level int, line string) {
+	if level < len(r.decoders) {
+		r.decodeInput(level, line)
+	} else {
+		r.readLine(line)
+	}
+}
+
+// decodeInput processes a line of the body of the container decoded at the passed level.
+func (r *Reader) decodeInput(level int, line string) {
+	d := r.decoders[level]
+	if _, _, ok := r.matchDelimiter(line); ok {
+		r.endDecoding(level)
+		r.readLevel(level, line)
+		return
+	}
+	if !d.decoding {
+		if trimRight(line) == "" {
+			d.empty++
+			return
+		}
+		if !isBase64Line(line) {
+			r.endDecoding(level)
+			r.readLevel(level, line)
+			return
+		}
+		// fix: decode the body of a container that is actually encoded
+		r.fixed(FixEncodedMultiparts)
+		r.removeEncoding()
+		r.releaseHeader()
+		d.decoding = true
+		d.empty = 0
+	}
+	d.dec.writeLine(line)
+	r.readDecoded(level, false)
+}
+
+// readDecoded processes the lines decoded so far by the decoder at the passed level,
+// including any unterminated last line if last is set.
+func (r *Reader) readDecoded(level int, last bool) {
+	d := r.decoders[level]
+	rest := d.out
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		r.readLevel(level+1, string(bytes.TrimSuffix(rest[:i], []byte("\r"))))
+		rest = rest[i+1:]
+	}
+	if last && len(rest) > 0 {
+		r.readLevel(level+1, string(rest))
+		rest = nil
+	}
+	d.out = append(d.out[:0], rest...)
+}
+
+// endDecoding stops decoding the body of the container at the passed level, and of the
+// containers it contains, at a non-encoded delimiter line or at EOF.
+func (r *Reader) endDecoding(level int) {
+	for len(r.decoders) > level {
+		i := len(r.decoders) - 1
+		d := r.decoders[i]
+		if d.decoding {
+			d.out = d.dec.flush(d.out)
+			r.readDecoded(i, true)
+			r.decoders = r.decoders[:i]
+			continue
+		}
+		r.decoders = r.decoders[:i]
+		if r.opts.fixContainerEncoding && d.embedded {
+			// fix: remove the encoding of an embedded message that is not actually encoded
+			r.fixed(FixContainerEncoding)
+			r.removeEncoding()
+			r.releaseHeader()
+		} else if r.opts.fixContainerEncoding {
+			r.part.body = &containerChecker{r: r, next: r.part.body}
+		} else {
+			r.releaseHeader()
+		}
+		for j := 0; j < d.empty; j++ {
+			r.readLine("")
+		}
+	}
+}
+
+// isBase64Line reports whether line only contains base64 characters, followed by any
+// whitespace.
+func isBase64Line(line string) bool {
+	line = trimRight(line)
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; !isBase64(c) && c != '=' {
+			return false
+		}
+	}
+	return true
+}
+
+// embeddedChecker fixes message/rfc822 parts declared as quoted-printable or base64 which are
+// not actually encoded. The header block is held back along with the body: the encoding is
+// removed and the body is processed as an embedded message if it starts with a header field,
+// and, for quoted-printable, if decoding it would change nothing. Otherwise the part is kept
+// as an opaque leaf part.
+type embeddedChecker struct {
+	r     *Reader
+	lines []string
+	size  int
+	// first is the input line number of the first body line.
+	first int
+}
+
+func (c *embeddedChecker) writeLine(line string) {
+	r := c.r
+	if len(c.lines) == 0 {
+		c.first = r.line
+		if !isField(line) || isBase64Line(line) {
+			c.release(false, line)
+			return
+		}
+	}
+	if r.part.encoding == "base64" {
+		// a header field is not base64
+		c.release(true, line)
+		return
+	}
+	if !qpInvariant(line) {
+		c.release(false, line)
+		return
+	}
+	c.lines = append(c.lines, line)
+	c.size += len(line)
+	if c.size > maxHeldBody {
+		c.release(false)
+	}
+}
+
+func (c *embeddedChecker) end(delimiter bool) {
+	r := c.r
+	if len(c.lines) == 0 {
+		c.release(false)
+		r.part.body.end(delimiter)
+		return
+	}
+	c.release(true)
+	// end the embedded message, whose lines were all read
+	r.endPart(delimiter)
+}
+
+// release processes the held lines followed by lines, as an embedded message whose encoding
+// is removed if embed is set, or as the body of a leaf part otherwise.
+func (c *embeddedChecker) release(embed bool, lines ...string) {
+	r := c.r
+	lines = append(c.lines, lines...)
+	c.lines = nil
+	r.part.held = false
+	if !embed {
+		r.part.body = r.body(&r.buffer)
+		if !r.part.held {
+			r.releaseHeader()
+		}
+		for _, line := range lines {
+			r.part.body.writeLine(line)
+		}
+		return
+	}
+	// fix: remove the encoding of an embedded message that is not actually encoded
+	r.fixed(FixContainerEncoding)
+	r.removeEncoding()
+	r.part = newPart(r.header, r.opts.strict)
+	r.checkDepth()
+	r.part.body = r.body(&r.buffer)
+	r.releaseHeader()
+	r.openEntity(r.entities[len(r.entities)-1].path)
+	e := r.entities[len(r.entities)-1]
+	e.embedded = true
+	e.startLine = c.first
+	r.state = stateHeader
+	for _, line := range lines {
+		r.readLine(line)
+	}
+}
+
+// qpInvariant reports whether decoding line as quoted-printable leaves it unchanged: it has no
+// trailing whitespace, soft line break or escaped byte.
+func qpInvariant(line string) bool {
+	if trimRight(line) != line || strings.HasSuffix(line, "=") {
+		return false
+	}
+	for i := 0; i+2 < len(line); i++ {
+		if line[i] == '=' && isHex(line[i+1]) && isHex(line[i+2]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+const containerTestHeader = "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/rfc822\r\n"
+
+func TestFixContainerEncoding(t *testing.T) {
+	fix := []Option{WithFixContainerEncoding(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "base64 multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			opts:  fix,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+		},
+		{
+			name:  "encoded base64 multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmJvZHkNCi0tYi0tDQo=\r\n",
+			opts:  fix,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmJvZHkNCi0tYi0tDQo=\r\n--b--\r\n",
+		},
+		{
+			name:  "quoted-printable multipart with a preamble",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\npreamble\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			opts:  fix,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\npreamble\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{FixContainerEncoding},
+		},
+		{
+			name:  "nested multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: multipart/alternative; boundary=c\r\nContent-Transfer-Encoding: base64\r\n\r\n--c\r\n\r\nbody\r\n--c--\r\n--b--\r\n",
+			opts:  fix,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: multipart/alternative; boundary=c\r\n\r\n--c\r\n\r\nbody\r\n--c--\r\n--b--\r\n",
+			fixes: []FixID{FixContainerEncoding},
+		},
+		{
+			name:  "8bit multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: 8bit\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			opts:  fix,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: 8bit\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "base64 multipart without the fix",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			want:  "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "base64 message",
+			input: containerTestHeader + "Content-Transfer-Encoding: base64\r\n\r\nSubject: plain\r\n\r\nbody\r\n--b--\r\n",
+			opts:  fix,
+			want:  containerTestHeader + "\r\nSubject: plain\r\n\r\nbody\r\n--b--\r\n",
+		},
+		{
+			name:  "encoded base64 message",
+			input: containerTestHeader + "Content-Transfer-Encoding: base64\r\n\r\nU3ViamVjdDogaGkNCg0KYm9keQ0K\r\n--b--\r\n",
+			opts:  fix,
+			want:  containerTestHeader + "Content-Transfer-Encoding: base64\r\n\r\nU3ViamVjdDogaGkNCg0KYm9keQ0K\r\n--b--\r\n",
+		},
+		{
+			name:  "quoted-printable message",
+			input: containerTestHeader + "Content-Transfer-Encoding: quoted-printable\r\n\r\nSubject: plain\r\nContent-Type: text/plain; charset=us-ascii\r\n\r\nbody\r\n--b--\r\n",
+			opts:  fix,
+			want:  containerTestHeader + "\r\nSubject: plain\r\nContent-Type: text/plain; charset=us-ascii\r\n\r\nbody\r\n--b--\r\n",
+		},
+		{
+			name:  "encoded quoted-printable message",
+			input: containerTestHeader + "Content-Transfer-Encoding: quoted-printable\r\n\r\nSubject: caf=C3=A9\r\n\r\nbody\r\n--b--\r\n",
+			opts:  fix,
+			want:  containerTestHeader + "Content-Transfer-Encoding: quoted-printable\r\n\r\nSubject: caf=C3=A9\r\n\r\nbody\r\n--b--\r\n",
+		},
+		{
+			name:  "quoted-printable message with a soft line break",
+			input: containerTestHeader + "Content-Transfer-Encoding: quoted-printable\r\n\r\nSubject: plain\r\n\r\nsoft=\r\nbreak\r\n--b--\r\n",
+			opts:  fix,
+			want:  containerTestHeader + "Content-Transfer-Encoding: quoted-printable\r\n\r\nSubject: plain\r\n\r\nsoft=\r\nbreak\r\n--b--\r\n",
+		},
+		{
+			name:  "unclosed quoted-printable message",
+			input: "Content-Type: message/rfc822\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nSubject: plain\r\n\r\nbody",
+			opts:  fix,
+			want:  "Content-Type: message/rfc822\r\n\r\nSubject: plain\r\n\r\nbody\r\n",
+		},
+		{
+			name:  "base64 message with ProfileArchive1",
+			input: containerTestHeader + "Content-Transfer-Encoding: base64\r\n\r\nSubject: plain\r\n\r\nbody\r\n--b--\r\n",
+			opts:  []Option{WithProfile(ProfileArchive1)},
+			want:  containerTestHeader + "Content-Transfer-Encoding: base64\r\n\r\nSubject: plain\r\n\r\nbody\r\n--b--\r\n",
+		},
+	})
+}
+
+func TestDecodeMultiparts(t *testing.T) {
+	decode := []Option{WithDecodeMultiparts(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "base64 multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmhlbGxvDQotLWItLQ0K\r\n",
+			opts:  decode,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nhello\r\n--b--\r\n",
+			fixes: []FixID{FixEncodedMultiparts},
+		},
+		{
+			name:  "base64 multipart split across lines",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmhl\r\nbGxvDQotLWItLQ0K\r\n",
+			opts:  decode,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nhello\r\n--b--\r\n",
+			fixes: []FixID{FixEncodedMultiparts},
+		},
+		{
+			name:  "unclosed base64 multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQpDb250ZW50LVR5cGU6IHRleHQvcGxhaW4NCg0KaGVsbG8=\r\n",
+			opts:  decode,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nhello\r\n--b--\r\n",
+			fixes: []FixID{FixEncodedMultiparts, FixCloseMultiparts},
+		},
+		{
+			name:  "base64 message",
+			input: containerTestHeader + "Content-Transfer-Encoding: base64\r\n\r\nU3ViamVjdDogaW5uZXINCg0KYm9keQ0K\r\n--b--\r\n",
+			opts:  decode,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/rfc822\r\n\r\nSubject: inner\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{FixEncodedMultiparts},
+		},
+		{
+			name:  "multipart not encoded",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\n--b\r\n\r\nhello\r\n--b--\r\n",
+			opts:  decode,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\n--b\r\n\r\nhello\r\n--b--\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "disabled",
+			input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmhlbGxvDQotLWItLQ0K\r\n",
+			want:  "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmhlbGxvDQotLWItLQ0K\r\n--b--\r\n",
+			fixes: []FixID{FixCloseMultiparts},
+		},
+	})
+}
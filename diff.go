@@ -0,0 +1,110 @@
+package messagefix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// diffContext is the count of unchanged lines around the changes of a diff.
+const diffContext = 3
+
+// WriteDiff fixes the message read from r with the passed options, discarding the fixed
+// message, and writes a unified diff from the message to its fixed form to w, with name as
+// the file name of both. It reports whether the message was changed.
+//
+// This is useful to review the changes of the Reader on a corpus before fixing the messages
+// in place. Line terminators are ignored, since the fixed message always uses CRLF. The diff
+// is computed as for Diff, which keeps the lines of a change close together.
+func WriteDiff(w io.Writer, name string, r io.Reader, opts ...Option) (changed bool, err error) {
+	original, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	fixed, err := io.ReadAll(NewReader(bytes.NewReader(original), opts...))
+	if err != nil {
+		return false, err
+	}
+	a, _ := splitLines(original)
+	b, _ := splitLines(fixed)
+	edits := lineEdits(a, b)
+	if len(edits) == 0 {
+		return false, nil
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- %v\n+++ %v\n", name, name)
+	for k := 0; k < len(edits); {
+		m := k
+		for m+1 < len(edits) && edits[m+1].i-(edits[m].i+edits[m].x) <= 2*diffContext {
+			m++
+		}
+		first, last := edits[k], edits[m]
+		aStart := first.i - diffContext
+		if aStart < 0 {
+			aStart = 0
+		}
+		aEnd := last.i + last.x + diffContext
+		if aEnd > len(a) {
+			aEnd = len(a)
+		}
+		bStart := aStart + first.j - first.i
+		bEnd := aEnd + (last.j + last.y) - (last.i + last.x)
+		fmt.Fprintf(bw, "@@ -%v +%v @@\n", diffRange(aStart, aEnd-aStart), diffRange(bStart, bEnd-bStart))
+		p := aStart
+		for _, e := range edits[k : m+1] {
+			writeDiffLines(bw, ' ', a[p:e.i])
+			writeDiffLines(bw, '-', a[e.i:e.i+e.x])
+			writeDiffLines(bw, '+', b[e.j:e.j+e.y])
+			p = e.i + e.x
+		}
+		writeDiffLines(bw, ' ', a[p:aEnd])
+		k = m + 1
+	}
+	return true, bw.Flush()
+}
+
+// lineEdit is the replacement of the lines a[i:i+x] of the original message with the lines
+// b[j:j+y] of the fixed message.
+type lineEdit struct {
+	i, j, x, y int
+}
+
+// lineEdits returns the edits from the lines a to the lines b, aligned as for Diff.
+func lineEdits(a, b [][]byte) []lineEdit {
+	var edits []lineEdit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && bytes.Equal(a[i], b[j]) {
+			i++
+			j++
+			continue
+		}
+		x, y := resync(a[i:], b[j:])
+		edits = append(edits, lineEdit{i, j, x, y})
+		i += x
+		j += y
+	}
+	return edits
+}
+
+// diffRange formats the range of n lines starting at the 0-based line start for a unified
+// diff hunk header.
+func diffRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, n)
+}
+
+func writeDiffLines(w *bufio.Writer, prefix byte, lines [][]byte) {
+	for _, line := range lines {
+		w.WriteByte(prefix)
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+}
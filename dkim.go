@@ -0,0 +1,430 @@
+package messagefix
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// DKIMMode is the behavior of a Reader on messages signed with DKIM (RFC 6376), whose
+// signatures break when their signed header fields or body are changed.
+type DKIMMode int
+
+const (
+	// DKIMIgnore fixes signed messages as any other message. This is the default.
+	DKIMIgnore DKIMMode = iota
+	// DKIMReport fixes signed messages as any other message, and reports each signature
+	// broken by the fixes as FindingDKIMBroken.
+	DKIMReport
+	// DKIMSafe restricts the fixes of signed messages to those that keep their signatures
+	// valid: the signed header fields of the message header and its whole body are output
+	// as is, only the other header fields are fixed. Signatures broken anyway, for example
+	// by fixes made while reading the signed fields, are reported as with DKIMReport.
+	DKIMSafe
+)
+
+// WithDKIM sets the behavior of the Reader on messages signed with DKIM, for re-delivering
+// fixed messages.
+//
+// The signatures are parsed from the DKIM-Signature fields of the message header. The input
+// and output messages are compared with the header and body canonicalizations of each
+// signature, so that only changes that actually break a signature are reported; signatures
+// are not verified. They are reported once the whole fixed message was read.
+//
+// The body of signed messages is not parsed with DKIMSafe, so that embedded parts are not
+// reported by Reader.Structure. Signatures with a body length limit (l= tag) are checked on
+// the signed body only.
+func WithDKIM(mode DKIMMode) Option {
+	return func(o *options) {
+		o.dkim = mode
+	}
+}
+
+// dkimSignature is a DKIM signature of the message header.
+type dkimSignature struct {
+	domain        string
+	relaxedHeader bool
+	// headers are the names of the signed header fields, in lower case.
+	headers []string
+	// index is the index of the DKIM-Signature field among those of the header.
+	index int
+	// line is the input line number of the DKIM-Signature field.
+	line int
+	// in and out are the body hashes of the input and output messages.
+	in, out bodyHash
+}
+
+// parseSignature parses the value of a DKIM-Signature field.
+func parseSignature(value string) *dkimSignature {
+	s := &dkimSignature{}
+	length := int64(-1)
+	header, body := "simple", "simple"
+	for _, tag := range strings.Split(value, ";") {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok {
+			continue
+		}
+		v = strings.Join(strings.Fields(v), "")
+		switch strings.TrimSpace(k) {
+		case "d":
+			s.domain = v
+		case "c":
+			header, body, ok = strings.Cut(strings.ToLower(v), "/")
+			if !ok {
+				body = "simple"
+			}
+		case "h":
+			for _, name := range strings.Split(v, ":") {
+				s.headers = append(s.headers, strings.ToLower(name))
+			}
+		case "l":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+				length = n
+			}
+		}
+	}
+	s.relaxedHeader = header == "relaxed"
+	for _, h := range []*bodyHash{&s.in, &s.out} {
+		h.h = sha256.New()
+		h.relaxed = body == "relaxed"
+		h.limit = length
+	}
+	return s
+}
+
+// signs reports whether the signature covers the header fields of the passed name, in lower
+// case.
+func (s *dkimSignature) signs(name string) bool {
+	if name == "dkim-signature" {
+		return true
+	}
+	for _, h := range s.headers {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// signedFields returns the canonical forms of the header fields signed by s. As in RFC 6376
+// section 5.4.2, each listing of a name signs one instance of the name, from the bottom of
+// the header up, and listings past the instances of the name sign nothing.
+func (s *dkimSignature) signedFields(fields []rawField) []string {
+	var signed []string
+	// next is the index of the field below which the next instance of each name is looked up
+	next := make(map[string]int)
+	for _, name := range s.headers {
+		i, ok := next[name]
+		if !ok {
+			i = len(fields)
+		}
+		for i--; i >= 0 && fields[i].name != name; i-- {
+		}
+		next[name] = i
+		if i >= 0 {
+			signed = append(signed, fields[i].canonical(s.relaxedHeader))
+		}
+	}
+	n := 0
+	for _, f := range fields {
+		if f.name != "dkim-signature" {
+			continue
+		}
+		if n == s.index {
+			signed = append(signed, f.canonical(s.relaxedHeader))
+		}
+		n++
+	}
+	return signed
+}
+
+// rawField is a header field of a message as is, such as seen by a DKIM verifier.
+type rawField struct {
+	// name is the field name, in lower case.
+	name  string
+	lines []string
+	line  int
+}
+
+// rawFields groups header lines into fields. lines are the input line numbers of the header
+// lines, or nil.
+func rawFields(header []string, lines []int) []rawField {
+	var fields []rawField
+	for i, line := range header {
+		if len(fields) > 0 && isContinuation(line) {
+			f := &fields[len(fields)-1]
+			f.lines = append(f.lines, line)
+			continue
+		}
+		f := rawField{lines: []string{line}}
+		if j := strings.Index(line, ":"); j >= 0 {
+			f.name = strings.ToLower(strings.TrimRight(line[:j], " \t"))
+		}
+		if lines != nil {
+			f.line = lines[i]
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// canonical returns the canonical form of the field, with the simple or relaxed header
+// canonicalization.
+func (f rawField) canonical(relaxed bool) string {
+	if !relaxed {
+		return strings.Join(f.lines, "\r\n")
+	}
+	s := strings.Join(f.lines, "")
+	_, value, _ := strings.Cut(s, ":")
+	return f.name + ":" + strings.Join(strings.FieldsFunc(value, func(c rune) bool {
+		return c == ' ' || c == '\t'
+	}), " ")
+}
+
+// bodyHash hashes a message body line by line, with the simple or relaxed body
+// canonicalization.
+type bodyHash struct {
+	h       hash.Hash
+	relaxed bool
+	// limit is the count of bytes of the canonical body to hash, or -1.
+	limit int64
+	n     int64
+	// empty is the count of empty lines not hashed yet, which are removed at the end of the
+	// body.
+	empty int
+	buf   []byte
+}
+
+func (b *bodyHash) line(line []byte) {
+	if b.relaxed {
+		b.buf = b.buf[:0]
+		for i := 0; i < len(line); i++ {
+			if c := line[i]; c != ' ' && c != '\t' {
+				b.buf = append(b.buf, c)
+				continue
+			}
+			for i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '\t') {
+				i++
+			}
+			if i+1 < len(line) {
+				b.buf = append(b.buf, ' ')
+			}
+		}
+		line = b.buf
+	}
+	if len(line) == 0 {
+		b.empty++
+		return
+	}
+	for ; b.empty > 0; b.empty-- {
+		b.write([]byte("\r\n"))
+	}
+	b.write(line)
+	b.write([]byte("\r\n"))
+}
+
+func (b *bodyHash) write(p []byte) {
+	if b.limit >= 0 && b.n+int64(len(p)) > b.limit {
+		p = p[:b.limit-b.n]
+	}
+	b.h.Write(p)
+	b.n += int64(len(p))
+}
+
+func (b *bodyHash) sum() []byte {
+	if b.n == 0 && !b.relaxed {
+		// an empty body is a single CRLF
+		b.write([]byte("\r\n"))
+	}
+	return b.h.Sum(nil)
+}
+
+// dkimChecker compares the input and output messages of a Reader with the canonicalizations
+// of the DKIM signatures of the message.
+type dkimChecker struct {
+	// header and lines are the lines of the input header block and their line numbers.
+	header []string
+	lines  []int
+	body   bool
+	// signatures are set once parsed, at the end of the input header block.
+	signatures []*dkimSignature
+	parsed     bool
+
+	// partial is the last output line, until its line terminator.
+	partial   []byte
+	outHeader []string
+	outBody   bool
+	// pending are the output body lines produced before the signatures were parsed.
+	pending [][]byte
+}
+
+// input processes an input line, without its line terminator.
+func (c *dkimChecker) input(line []byte, n int) {
+	if c.body {
+		for _, s := range c.signatures {
+			s.in.line(line)
+		}
+		return
+	}
+	if len(line) == 0 {
+		c.body = true
+		c.parse()
+		return
+	}
+	c.header = append(c.header, string(line))
+	c.lines = append(c.lines, n)
+}
+
+// parse parses the signatures of the input header block read so far, once.
+func (c *dkimChecker) parse() {
+	if c.parsed {
+		return
+	}
+	c.parsed = true
+	i := 0
+	for _, f := range rawFields(c.header, c.lines) {
+		if f.name != "dkim-signature" {
+			continue
+		}
+		s := parseSignature(rawValue(f))
+		s.index = i
+		s.line = f.line
+		c.signatures = append(c.signatures, s)
+		i++
+	}
+	for _, line := range c.pending {
+		c.outputLine(line)
+	}
+	c.pending = nil
+}
+
+// output processes output bytes.
+func (c *dkimChecker) output(b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			c.partial = append(c.partial, b...)
+			return
+		}
+		line := b[:i]
+		if len(c.partial) > 0 {
+			c.partial = append(c.partial, line...)
+			line = c.partial
+		}
+		c.outputLine(bytes.TrimSuffix(line, []byte("\r")))
+		c.partial = c.partial[:0]
+		b = b[i+1:]
+	}
+}
+
+// outputLine processes an output line, without its line terminator.
+func (c *dkimChecker) outputLine(line []byte) {
+	switch {
+	case !c.outBody:
+		if len(line) == 0 {
+			c.outBody = true
+		} else {
+			c.outHeader = append(c.outHeader, string(line))
+		}
+	case !c.parsed:
+		c.pending = append(c.pending, append([]byte(nil), line...))
+	default:
+		for _, s := range c.signatures {
+			s.out.line(line)
+		}
+	}
+}
+
+// broken returns the details of the signatures broken by the fixes, once the whole input was
+// read and the whole output was processed.
+func (c *dkimChecker) broken() []Finding {
+	if len(c.partial) > 0 {
+		c.outputLine(c.partial)
+	}
+	c.parse()
+	in, out := rawFields(c.header, nil), rawFields(c.outHeader, nil)
+	var findings []Finding
+	for _, s := range c.signatures {
+		var changes []string
+		a, b := s.signedFields(in), s.signedFields(out)
+		if len(a) != len(b) {
+			changes = append(changes, "signed header fields")
+		} else {
+			for i := range a {
+				if a[i] != b[i] {
+					changes = append(changes, "signed header fields")
+					break
+				}
+			}
+		}
+		if !bytes.Equal(s.in.sum(), s.out.sum()) {
+			changes = append(changes, "body")
+		}
+		if len(changes) > 0 {
+			findings = append(findings, Finding{
+				Kind:   FindingDKIMBroken,
+				Line:   s.line,
+				Detail: "DKIM signature of " + strconv.Quote(s.domain) + " broken by changes to the " + strings.Join(changes, " and "),
+			})
+		}
+	}
+	return findings
+}
+
+// checkSignatures reports the DKIM signatures broken by the fixes, once the whole output was
+// read.
+func (r *Reader) checkSignatures() {
+	r.findings = append(r.findings, r.dkim.broken()...)
+	r.dkim = nil
+}
+
+// hideSigned removes the header fields signed by the DKIM signatures of the message from
+// the header, so that the fixers keep them as is. It returns them along with their indexes,
+// for restoreSigned.
+func (r *Reader) hideSigned() (hidden []*field, indexes []int) {
+	header := r.header[:0]
+	for i, f := range r.header {
+		if r.signed(f) {
+			hidden = append(hidden, f)
+			indexes = append(indexes, i)
+		} else {
+			header = append(header, f)
+		}
+	}
+	r.header = header
+	return hidden, indexes
+}
+
+// restoreSigned puts the fields removed by hideSigned back at their indexes, removing any
+// field of the same names added by the fixers.
+func (r *Reader) restoreSigned(hidden []*field, indexes []int) {
+	header := r.header[:0]
+	for _, f := range r.header {
+		if !r.signed(f) {
+			header = append(header, f)
+		}
+	}
+	for k, f := range hidden {
+		i := indexes[k]
+		if i > len(header) {
+			i = len(header)
+		}
+		header = append(header, nil)
+		copy(header[i+1:], header[i:])
+		header[i] = f
+	}
+	r.header = header
+}
+
+// signed reports whether a field of the message header is signed by a DKIM signature.
+func (r *Reader) signed(f *field) bool {
+	name := strings.ToLower(strings.TrimRight(f.name, " \t"))
+	for _, s := range r.dkim.signatures {
+		if s.signs(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package messagefix
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDKIMSignedFields(t *testing.T) {
+	const signature = "DKIM-Signature: v=1; a=rsa-sha256; d=example.org; s=s; c=relaxed/relaxed;\r\n h=from:subject; bh=; b=\r\n"
+	drop := func(value string) Option {
+		return WithHeaderHook(func(name, v string) (string, string, bool) {
+			return name, v, !strings.EqualFold(name, "Subject") || v != value
+		})
+	}
+	tests := []struct {
+		name   string
+		input  string
+		opts   []Option
+		broken bool
+	}{
+		{
+			name:  "unsigned duplicate removed",
+			input: signature + "Subject: old\r\nFrom: a@example.org\r\nSubject: new\r\n\r\nbody\r\n",
+			opts:  []Option{drop("old")},
+		},
+		{
+			name:   "signed instance removed",
+			input:  signature + "Subject: old\r\nFrom: a@example.org\r\nSubject: new\r\n\r\nbody\r\n",
+			opts:   []Option{drop("new")},
+			broken: true,
+		},
+		{
+			name:   "oversigned field added",
+			input:  signature + "From: a@example.org\r\n\r\nbody\r\n",
+			opts:   []Option{WithFixers(HeaderFixerFunc(func(h *Header) { h.Add("Subject", "added") }))},
+			broken: true,
+		},
+		{
+			name:  "unsigned field added",
+			input: signature + "From: a@example.org\r\n\r\nbody\r\n",
+			opts:  []Option{WithFixers(HeaderFixerFunc(func(h *Header) { h.Add("To", "b@example.org") }))},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tc.input), append([]Option{WithDKIM(DKIMReport)}, tc.opts...)...)
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				t.Fatal(err)
+			}
+			var broken bool
+			for _, f := range r.Findings() {
+				if f.Kind == FindingDKIMBroken {
+					broken = true
+				}
+			}
+			if broken != tc.broken {
+				t.Errorf("broken signature: %v, want %v (findings: %v)", broken, tc.broken, r.Findings())
+			}
+		})
+	}
+}
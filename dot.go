@@ -0,0 +1,67 @@
+package messagefix
+
+import (
+	"io"
+)
+
+// dotTerminator is the line ending dot-stuffed SMTP DATA.
+const dotTerminator = ".\r\n"
+
+// scan advances the input scanner to the next line, returning false at EOF.
+func (r *Reader) scan() bool {
+	if r.dotEnd || !r.sc.Scan() {
+		return false
+	}
+	if r.opts.dotUnstuffing && string(r.sc.Bytes()) == "." {
+		r.dotEnd = true
+		return false
+	}
+	return true
+}
+
+// stuffedHead returns the next bytes of the dot-stuffed output.
+func (r *Reader) stuffedHead() ([]byte, error) {
+	for len(r.stuffed) == 0 {
+		b, err := r.signedHead()
+		if err == io.EOF && !r.stuffer.terminated {
+			r.stuffed = r.stuffer.terminate(r.stuffed[:0])
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.stuffed = r.stuffer.stuff(r.stuffed[:0], b)
+		r.skip(len(b))
+	}
+	return r.stuffed, nil
+}
+
+// stuffer dot-stuffs a stream of bytes.
+type stuffer struct {
+	// midLine is set when the last byte was not the end of a CRLF.
+	midLine bool
+	cr      bool
+	// terminated is set once the terminating line was output.
+	terminated bool
+}
+
+// stuff appends b to dst, dot-stuffed.
+func (s *stuffer) stuff(dst, b []byte) []byte {
+	for _, c := range b {
+		if !s.midLine && c == '.' {
+			dst = append(dst, '.')
+		}
+		dst = append(dst, c)
+		s.midLine = !(s.cr && c == '\n')
+		s.cr = c == '\r'
+	}
+	return dst
+}
+
+// terminate appends the terminating line to dst.
+func (s *stuffer) terminate(dst []byte) []byte {
+	if s.midLine {
+		dst = append(dst, "\r\n"...)
+	}
+	s.terminated = true
+	return append(dst, dotTerminator...)
+}
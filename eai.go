@@ -0,0 +1,357 @@
+package messagefix
+
+import (
+	"mime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// WithSMTPUTF8 sets whether the output of the Reader is consumed by a pipeline supporting
+// internationalized header fields (RFC 6532), such as one relaying messages with the SMTPUTF8
+// extension, in which case raw UTF-8 in header field values is left untouched.
+//
+// The Content-Type fields rewritten by fixes then keep their parameter values containing
+// UTF-8 as quoted strings, rather than encoding them as RFC 2231 extended parameters.
+func WithSMTPUTF8(enabled bool) Option {
+	return func(o *options) {
+		o.smtputf8 = enabled
+	}
+}
+
+// formatMediaType returns mime.FormatMediaType(mediaType, params). With smtputf8, parameter
+// values made of valid UTF-8 with 8-bit bytes are kept as quoted strings, as RFC 6532 allows.
+func formatMediaType(mediaType string, params map[string]string, smtputf8 bool) string {
+	if !smtputf8 {
+		return mime.FormatMediaType(mediaType, params)
+	}
+	ascii := make(map[string]string, len(params))
+	var raw []string
+	for key, value := range params {
+		if has8Bit(value) && utf8.ValidString(value) {
+			if !isToken(key) {
+				return ""
+			}
+			raw = append(raw, key)
+		} else {
+			ascii[key] = value
+		}
+	}
+	s := mime.FormatMediaType(mediaType, ascii)
+	if s == "" {
+		return ""
+	}
+	sort.Strings(raw)
+	var sb strings.Builder
+	sb.WriteString(s)
+	for _, key := range raw {
+		sb.WriteString("; ")
+		sb.WriteString(strings.ToLower(key))
+		sb.WriteByte('=')
+		sb.WriteString(quoteParam(params[key]))
+	}
+	return sb.String()
+}
+
+// WithDowngradeUTF8 enables downgrading internationalized messages (RFC 6532) to messages
+// whose header blocks are ASCII, as RFC 6857 describes, so that messages received over
+// SMTPUTF8 can be delivered to servers and stores that only accept ASCII messages:
+//   - non-ASCII display names, group names and comments of address fields are encoded as
+//     RFC 2047 encoded-words;
+//   - mailboxes with a non-ASCII address are rewritten as empty groups named after the
+//     mailbox, such as "=?utf-8?b?asO2cmdAZXhhbXBsZS5vcmc=?= :;";
+//   - non-ASCII parameter values of Content-Type and Content-Disposition fields are encoded
+//     as RFC 2231 extended parameters;
+//   - non-ASCII Message-ID, In-Reply-To, References, Received and other structured fields
+//     are renamed with a "Downgraded-" prefix, and their body encoded as encoded-words;
+//   - the body of other non-ASCII fields is encoded as encoded-words;
+//   - message/global and message/global-headers parts that are not encoded are declared as
+//     message/rfc822 and text/rfc822-headers, and their embedded header block is downgraded.
+//
+// Invalid UTF-8 is replaced with U+FFFD, and downgraded fields are folded at 78 characters.
+// Part bodies are kept as is. WithSMTPUTF8 has no effect with WithDowngradeUTF8.
+func WithDowngradeUTF8(enabled bool) Option {
+	return func(o *options) {
+		o.downgradeUTF8 = enabled
+	}
+}
+
+// addressFields are the fields whose body is a list of addresses (RFC 5322).
+var addressFields = map[string]bool{
+	"From":                        true,
+	"Sender":                      true,
+	"Reply-To":                    true,
+	"To":                          true,
+	"Cc":                          true,
+	"Bcc":                         true,
+	"Resent-From":                 true,
+	"Resent-Sender":               true,
+	"Resent-To":                   true,
+	"Resent-Cc":                   true,
+	"Resent-Bcc":                  true,
+	"Mail-Followup-To":            true,
+	"Mail-Reply-To":               true,
+	"Disposition-Notification-To": true,
+}
+
+// encapsulatedFields are the structured fields that cannot be downgraded in place, which
+// are renamed with a "Downgraded-" prefix when they are not ASCII (RFC 6857).
+var encapsulatedFields = map[string]bool{
+	"Message-ID":                true,
+	"Resent-Message-ID":         true,
+	"In-Reply-To":               true,
+	"References":                true,
+	"Content-ID":                true,
+	"Received":                  true,
+	"Return-Path":               true,
+	"Date":                      true,
+	"Resent-Date":               true,
+	"Original-Recipient":        true,
+	"Final-Recipient":           true,
+	"MIME-Version":              true,
+	"Content-Transfer-Encoding": true,
+}
+
+// globalTypes are the media types of internationalized messages and header blocks, and
+// their downgraded media type.
+var globalTypes = map[string]string{
+	"message/global":         "message/rfc822",
+	"message/global-headers": "text/rfc822-headers",
+}
+
+// downgradeHeader rewrites the non-ASCII fields of the header block as ASCII, and declares
+// the message/global parts as message/rfc822. It reports whether the header was fixed.
+func downgradeHeader(header []*field) bool {
+	fixed := false
+	for _, f := range header {
+		value := f.value()
+		if !has8Bit(value) {
+			continue
+		}
+		// fix: downgrade a non-ASCII field
+		fixed = true
+		key := canonicalHeaderKey(strings.TrimSpace(f.name))
+		downgraded := ""
+		switch {
+		case addressFields[key]:
+			downgraded = downgradeAddresses(value)
+		case key == "Content-Type" || key == "Content-Disposition":
+			downgraded = downgradeParams(value)
+		case !encapsulatedFields[key]:
+			downgraded = encodeWords(value, false)
+		}
+		if downgraded == "" || has8Bit(downgraded) {
+			f.name = "Downgraded-" + strings.TrimSpace(f.name)
+			downgraded = encodeWords(value, false)
+		}
+		f.setValue(downgraded)
+		foldField(f, 78)
+	}
+	ct := lookup(header, "Content-Type")
+	if ct == nil {
+		return fixed
+	}
+	value := ct.value()
+	mediaType, rest, _ := strings.Cut(value, ";")
+	downgraded, ok := globalTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+	if !ok {
+		return fixed
+	}
+	if f := lookup(header, "Content-Transfer-Encoding"); f != nil && !validContainerEncoding(strings.ToLower(f.value())) {
+		return fixed
+	}
+	// fix: declare an internationalized message as message/rfc822
+	if rest != "" {
+		downgraded += ";" + rest
+	}
+	ct.setValue(downgraded)
+	return true
+}
+
+// downgradeAddresses returns an address list with its non-ASCII display names, group names
+// and comments encoded as encoded-words, and its non-ASCII mailboxes rewritten as groups.
+func downgradeAddresses(value string) string {
+	var sb strings.Builder
+	for {
+		i := indexTopLevel(value, ",:;")
+		if i < 0 {
+			sb.WriteString(downgradeMailbox(value))
+			return sb.String()
+		}
+		if value[i] == ':' {
+			sb.WriteString(downgradePhrase(value[:i]))
+		} else {
+			sb.WriteString(downgradeMailbox(value[:i]))
+		}
+		sb.WriteByte(value[i])
+		value = value[i+1:]
+	}
+}
+
+// downgradeMailbox returns a mailbox with its non-ASCII display name and comments encoded
+// as encoded-words, or as an empty group named after the mailbox if its address is not
+// ASCII.
+func downgradeMailbox(s string) string {
+	if !has8Bit(s) {
+		return s
+	}
+	text := strings.TrimLeft(s, " \t")
+	lead := s[:len(s)-len(text)]
+	text = strings.TrimRight(text, " \t")
+	trail := s[len(lead)+len(text):]
+	var addr string
+	i := indexTopLevel(text, "<")
+	j := -1
+	if i >= 0 {
+		j = strings.IndexByte(text[i:], '>')
+	}
+	if j >= 0 {
+		addr = text[i+1 : i+j]
+	} else {
+		addr, _ = stripComments(text)
+	}
+	if has8Bit(addr) {
+		return lead + encodeWords(text, true) + " :;" + trail
+	}
+	if j < 0 {
+		return lead + downgradeComments(text) + trail
+	}
+	return lead + downgradePhrase(text[:i]) + text[i:i+j+1] + downgradeComments(text[i+j+1:]) + trail
+}
+
+// downgradePhrase returns a non-ASCII display name or group name as encoded-words,
+// keeping its surrounding whitespace, or only its non-ASCII comments if the rest is ASCII.
+func downgradePhrase(s string) string {
+	if !has8Bit(s) {
+		return s
+	}
+	if c := downgradeComments(s); !has8Bit(c) {
+		return c
+	}
+	text := strings.TrimLeft(s, " \t")
+	lead := s[:len(s)-len(text)]
+	text = strings.TrimRight(text, " \t")
+	trail := s[len(lead)+len(text):]
+	if unquoted, ok := unquote(text); ok {
+		text = unquoted
+	}
+	return lead + encodeWords(text, true) + trail
+}
+
+// unquote returns the content of s if it is a single quoted string.
+func unquote(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' {
+		return "", false
+	}
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			sb.WriteByte(s[i])
+		case c == '"':
+			return sb.String(), i == len(s)-1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// downgradeComments returns s with the content of its non-ASCII comments encoded as
+// encoded-words.
+func downgradeComments(s string) string {
+	var sb strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(s):
+			sb.WriteString(s[i : i+2])
+			i++
+			continue
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			n := commentLen(s[i:])
+			if n < 0 {
+				sb.WriteString(s[i:])
+				return sb.String()
+			}
+			if comment := s[i+1 : i+n-1]; has8Bit(comment) {
+				sb.WriteString("(" + encodeWords(comment, true) + ")")
+			} else {
+				sb.WriteString(s[i : i+n])
+			}
+			i += n - 1
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// indexTopLevel returns the index of the first byte of s among chars that is outside of
+// quoted strings, comments and angle addresses, or -1 if there is none.
+func indexTopLevel(s, chars string) int {
+	depth := 0
+	quoted, angle := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0):
+			i++
+		case quoted:
+			quoted = c != '"'
+		case c == '(':
+			depth++
+		case depth > 0:
+			if c == ')' {
+				depth--
+			}
+		case !angle && strings.IndexByte(chars, c) >= 0:
+			return i
+		case c == '"':
+			quoted = true
+		case c == '<':
+			angle = true
+		case c == '>':
+			angle = false
+		}
+	}
+	return -1
+}
+
+// downgradeParams returns a Content-Type or Content-Disposition field body with its
+// non-ASCII parameter values encoded as RFC 2231 extended parameters, or "" if it cannot
+// be downgraded, such as with a non-ASCII boundary.
+func downgradeParams(value string) string {
+	value = strings.ToValidUTF8(value, "\uFFFD")
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		mediaType, params = parseContentType(value)
+	}
+	if has8Bit(params["boundary"]) {
+		return ""
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// encodeWords returns s as RFC 2047 encoded-words, with invalid UTF-8 replaced with U+FFFD.
+// Encoded-words that replace a phrase or a comment only use the characters allowed there.
+func encodeWords(s string, phrase bool) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if phrase && strings.IndexFunc(s, isPhraseSpecial) >= 0 {
+		return mime.BEncoding.Encode("utf-8", s)
+	}
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// isPhraseSpecial reports whether r is an ASCII character that cannot appear in the
+// Q-encoded words of a phrase (RFC 2047 section 5), other than a space.
+func isPhraseSpecial(r rune) bool {
+	if r >= utf8.RuneSelf || r == ' ' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return false
+	}
+	return !strings.ContainsRune("!*+-/", r)
+}
@@ -0,0 +1,67 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+func TestDowngradeUTF8(t *testing.T) {
+	opts := []Option{WithDowngradeUTF8(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "display name",
+			input: "From: J\xc3\xb6rg <jorg@example.org>\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "From: =?utf-8?q?J=C3=B6rg?= <jorg@example.org>\r\n\r\nbody\r\n",
+			fixes: []FixID{FixDowngradeUTF8},
+		},
+		{
+			name:  "non-ASCII address",
+			input: "To: j\xc3\xb6rg@example.org, b@example.org\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "To: =?utf-8?b?asO2cmdAZXhhbXBsZS5vcmc=?= :;, b@example.org\r\n\r\nbody\r\n",
+			fixes: []FixID{FixDowngradeUTF8},
+		},
+		{
+			name:  "unstructured field",
+			input: "Subject: caf\xc3\xa9\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "Subject: =?utf-8?q?caf=C3=A9?=\r\n\r\nbody\r\n",
+			fixes: []FixID{FixDowngradeUTF8},
+		},
+		{
+			name:  "structured field",
+			input: "Message-ID: <caf\xc3\xa9@example.org>\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "Downgraded-Message-ID: =?utf-8?q?<caf=C3=A9@example.org>?=\r\n\r\nbody\r\n",
+			fixes: []FixID{FixDowngradeUTF8},
+		},
+		{
+			name:  "parameter",
+			input: "Content-Type: text/plain; name=caf\xc3\xa9.txt\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; name*=utf-8''caf%C3%A9.txt\r\n\r\nbody\r\n",
+			fixes: []FixID{FixDowngradeUTF8},
+		},
+		{
+			name:  "invalid UTF-8",
+			input: "Subject: caf\xe9\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "Subject: =?utf-8?q?caf=EF=BF=BD?=\r\n\r\nbody\r\n",
+			fixes: []FixID{FixDowngradeUTF8},
+		},
+		{
+			name:  "message/global",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/global\r\n\r\nSubject: caf\xc3\xa9\r\n\r\nbody\r\n--b--\r\n",
+			opts:  opts,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/rfc822\r\n\r\nSubject: =?utf-8?q?caf=C3=A9?=\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{FixDowngradeUTF8, FixDowngradeUTF8},
+		},
+		{
+			name:  "ASCII message",
+			input: "From: Jorg <jorg@example.org>\r\nSubject: test\r\n\r\nbody caf\xc3\xa9\r\n",
+			opts:  opts,
+			want:  "From: Jorg <jorg@example.org>\r\nSubject: test\r\n\r\nbody caf\xc3\xa9\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,30 @@
+// Package emersion fixes messages for github.com/emersion/go-message.
+//
+// The messagefix options used by this package are tuned to the checks of the go-message
+// parser: charsets are transcoded to UTF-8 so that no charset reader is needed, and
+// encodings and multipart structures that go-message rejects are repaired.
+package emersion
+
+import (
+	"io"
+
+	"github.com/delthas/go-messagefix"
+	"github.com/emersion/go-message"
+)
+
+// Options returns the messagefix options used by Read.
+func Options() []messagefix.Option {
+	return []messagefix.Option{
+		messagefix.WithProfile(messagefix.ProfileGoMessage),
+	}
+}
+
+// Read fixes the message read from r, and parses it with message.Read.
+//
+// The passed options are applied after Options, so that they can override them. As with
+// message.Read, an Entity is returned along with an error verifying message.IsUnknownCharset
+// or message.IsUnknownEncoding when the message has a charset or encoding that could not be
+// fixed; the Entity can still be read.
+func Read(r io.Reader, opts ...messagefix.Option) (*message.Entity, error) {
+	return message.Read(messagefix.NewReader(r, append(Options(), opts...)...))
+}
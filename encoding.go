@@ -0,0 +1,201 @@
+package messagefix
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// decoder returns a lineWriter decoding the part body to out, or next if the part
+// encoding needs no decoding. The part header must then be rewritten with rewriteEncoding.
+func (p *part) decoder(out *[]byte, next lineWriter) lineWriter {
+	switch p.encoding {
+	case "base64":
+		return &base64Decoder{out: out}
+	case "quoted-printable":
+		return &qpDecoder{out: out}
+	default:
+		return next
+	}
+}
+
+// encodingAliases are the standard encodings of the unknown Content-Transfer-Encoding
+// values, in lower case without punctuation or "x-" prefix, used by broken generators.
+var encodingAliases = map[string]string{
+	"7bit":            "7bit",
+	"7bits":           "7bit",
+	"none":            "7bit",
+	"ascii":           "7bit",
+	"usascii":         "7bit",
+	"plain":           "7bit",
+	"text":            "7bit",
+	"8bit":            "8bit",
+	"8bits":           "8bit",
+	"binary":          "binary",
+	"base64":          "base64",
+	"b64":             "base64",
+	"quotedprintable": "quoted-printable",
+	"qp":              "quoted-printable",
+	"uuencode":        "x-uuencode",
+	"uuencoded":       "x-uuencode",
+	"uue":             "x-uuencode",
+}
+
+// mapEncoding returns the standard encoding of a Content-Transfer-Encoding value, which is
+// kept if it is already standard. Charset names, which some generators declare as encoding,
+// are mapped to 8bit, and so are the other unknown values, whose bodies are output as is.
+func mapEncoding(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "7bit", "8bit", "binary", "base64", "quoted-printable":
+		return value
+	}
+	key := strings.TrimPrefix(value, "x-")
+	key = strings.Map(func(c rune) rune {
+		if c == '-' || c == '_' || c == ' ' {
+			return -1
+		}
+		return c
+	}, key)
+	if encoding, ok := encodingAliases[key]; ok {
+		return encoding
+	}
+	return "8bit"
+}
+
+// fixEncodings rewrites the unknown Content-Transfer-Encoding values of the header to their
+// standard equivalent. It reports whether the header was fixed.
+func fixEncodings(header []*field) bool {
+	fixed := false
+	for _, f := range header {
+		if !f.is("Content-Transfer-Encoding") {
+			continue
+		}
+		if encoding := mapEncoding(f.value()); !strings.EqualFold(encoding, f.value()) {
+			// fix: use a standard encoding
+			f.setValue(encoding)
+			fixed = true
+		}
+	}
+	return fixed
+}
+
+// rewriteEncoding marks the part header as decoded if its body is decoded.
+func (p *part) rewriteEncoding(header []*field) bool {
+	switch p.encoding {
+	case "base64", "quoted-printable":
+		lookup(header, "Content-Transfer-Encoding").setValue("binary")
+		return true
+	}
+	return false
+}
+
+type base64Decoder struct {
+	out     *[]byte
+	quantum [4]byte
+	n       int
+}
+
+func (d *base64Decoder) writeLine(line string) {
+	dst := *d.out
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if !isBase64(c) && c != '=' {
+			continue
+		}
+		d.quantum[d.n] = c
+		d.n++
+		if d.n == len(d.quantum) {
+			dst = d.flush(dst)
+		}
+	}
+	*d.out = dst
+}
+
+// flush decodes the current quantum, ignoring any padding.
+func (d *base64Decoder) flush(dst []byte) []byte {
+	q := d.quantum[:d.n]
+	d.n = 0
+	for i, c := range q {
+		if c == '=' {
+			q = q[:i]
+			break
+		}
+	}
+	if len(q) < 2 {
+		return dst
+	}
+	var buf [3]byte
+	n, _ := base64.RawStdEncoding.Decode(buf[:], q)
+	return append(dst, buf[:n]...)
+}
+
+func (d *base64Decoder) end(delimiter bool) {
+	*d.out = d.flush(*d.out)
+	if delimiter {
+		*d.out = append(*d.out, "\r\n"...)
+	}
+}
+
+func isBase64(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '/'
+}
+
+type qpDecoder struct {
+	out *[]byte
+	// crlf is set when the last decoded line ended with a hard line break.
+	crlf bool
+}
+
+func (d *qpDecoder) writeLine(line string) {
+	dst := *d.out
+	if d.crlf {
+		dst = append(dst, "\r\n"...)
+	}
+	line = trimRight(line)
+	soft := len(line) > 0 && line[len(line)-1] == '='
+	if soft {
+		line = line[:len(line)-1]
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '=' && i+2 < len(line) && isHex(line[i+1]) && isHex(line[i+2]) {
+			dst = append(dst, unhex(line[i+1])<<4|unhex(line[i+2]))
+			i += 2
+			continue
+		}
+		dst = append(dst, c)
+	}
+	d.crlf = !soft
+	*d.out = dst
+}
+
+func (d *qpDecoder) end(delimiter bool) {
+	if d.crlf || delimiter {
+		*d.out = append(*d.out, "\r\n"...)
+	}
+	d.crlf = false
+}
+
+// trimRight removes trailing linear whitespace.
+func trimRight(line string) string {
+	i := len(line)
+	for i > 0 && (line[i-1] == ' ' || line[i-1] == '\t') {
+		i--
+	}
+	return line[:i]
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,242 @@
+package messagefix
+
+import (
+	"encoding/base64"
+	"mime"
+	"strings"
+)
+
+// extractsFiles reports whether the uuencoded or yEnc-encoded files of the current part body
+// are extracted as attachments.
+func (r *Reader) extractsFiles() bool {
+	if !r.opts.uuAttachments && !r.opts.yencAttachments || len(r.entities) != 1 || r.part.signed || r.holdsHeader() {
+		return false
+	}
+	if r.part.mediaType != "" && r.part.mediaType != "text/plain" {
+		return false
+	}
+	switch r.part.encoding {
+	case "", "7bit", "8bit":
+		return true
+	}
+	return false
+}
+
+// extractState is the state of a fileExtractor.
+type extractState int
+
+const (
+	// extractHolding holds the header block back until a begin line is found.
+	extractHolding extractState = iota
+	// extractText writes a text part of the rewritten message.
+	extractText
+	// extractFile writes an attachment of the rewritten message.
+	extractFile
+	// extractAfterFile holds the empty lines following an attachment, until a text line.
+	extractAfterFile
+	// extractPassing writes the body as is, since no begin line was found.
+	extractPassing
+)
+
+// fileExtractor rewrites a message body containing uuencoded or yEnc-encoded files as a
+// multipart/mixed body, with a text part for the text between the files and a base64 part for
+// each file.
+type fileExtractor struct {
+	r     *Reader
+	next  lineWriter
+	state extractState
+	lines []string
+	size  int
+	// boundary is the boundary of the rewritten message, and text the header fields of
+	// its text parts, taken from the message header.
+	boundary string
+	text     []string
+	// buf is the decoded content of the current file that is not encoded as base64 yet, and
+	// yenc is set if the file is yEnc-encoded rather than uuencoded.
+	buf  []byte
+	yenc bool
+}
+
+// begin returns the file name of a begin line of the enabled encodings.
+func (x *fileExtractor) begin(line string) (string, bool) {
+	if x.r.opts.uuAttachments {
+		if name, ok := uuBegin(line); ok {
+			x.yenc = false
+			return name, true
+		}
+	}
+	if x.r.opts.yencAttachments {
+		if name, ok := yencBegin(line); ok {
+			x.yenc = true
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (x *fileExtractor) writeLine(line string) {
+	switch x.state {
+	case extractHolding:
+		if name, ok := x.begin(line); ok {
+			x.rewrite()
+			x.startFile(name)
+			return
+		}
+		x.lines = append(x.lines, line)
+		x.size += len(line)
+		if x.size > maxHeldBody {
+			x.release()
+		}
+	case extractText:
+		if name, ok := x.begin(line); ok {
+			x.startFile(name)
+			return
+		}
+		x.next.writeLine(line)
+	case extractFile:
+		if x.yenc {
+			x.writeYEnc(line)
+			return
+		}
+		if line == "end" {
+			x.flush(true)
+			x.state = extractAfterFile
+			return
+		}
+		var ok bool
+		if x.buf, ok = uudecode(x.buf, line); ok {
+			x.flush(false)
+			return
+		}
+		// the file is truncated: keep the rest as text
+		x.flush(true)
+		x.startText()
+		x.next.writeLine(line)
+	case extractAfterFile:
+		if strings.TrimSpace(line) == "" {
+			x.lines = append(x.lines, line)
+			return
+		}
+		if name, ok := x.begin(line); ok {
+			x.lines = nil
+			x.startFile(name)
+			return
+		}
+		x.startText()
+		for _, l := range x.lines {
+			x.next.writeLine(l)
+		}
+		x.lines = nil
+		x.next.writeLine(line)
+	default:
+		x.next.writeLine(line)
+	}
+}
+
+// writeYEnc processes a line of a yEnc-encoded file.
+func (x *fileExtractor) writeYEnc(line string) {
+	switch {
+	case strings.HasPrefix(line, "=yend"):
+		x.flush(true)
+		x.state = extractAfterFile
+	case strings.HasPrefix(line, "=ypart "):
+		// the file is a part of a larger file, whose offsets are not needed
+	default:
+		x.buf = ydecode(x.buf, line)
+		x.flush(false)
+	}
+}
+
+func (x *fileExtractor) end(delimiter bool) {
+	switch x.state {
+	case extractHolding:
+		x.release()
+	case extractFile:
+		x.flush(true)
+		fallthrough
+	case extractText, extractAfterFile:
+		x.next.writeLine("--" + x.boundary + "--")
+	}
+	x.next.end(delimiter)
+}
+
+// release outputs the held header block and body lines as is.
+func (x *fileExtractor) release() {
+	x.r.releaseHeader()
+	for _, line := range x.lines {
+		x.next.writeLine(line)
+	}
+	x.lines = nil
+	x.state = extractPassing
+}
+
+// rewrite declares the message as multipart/mixed, then outputs its header block and the
+// held body lines as its first text part.
+func (x *fileExtractor) rewrite() {
+	x.boundary = synthesizedBoundary(1)
+	header := x.r.header[:0]
+	for _, f := range x.r.header {
+		if f.is("Content-Type") || f.is("Content-Transfer-Encoding") {
+			x.text = append(x.text, f.lines...)
+		} else {
+			header = append(header, f)
+		}
+	}
+	x.r.header = header
+	if lookup(x.r.header, "MIME-Version") == nil {
+		x.r.header = append(x.r.header, newField("MIME-Version: 1.0"))
+	}
+	x.r.header = append(x.r.header, newField("Content-Type: multipart/mixed; boundary=\""+x.boundary+"\""))
+	e := x.r.entities[0]
+	e.mediaType = "multipart/mixed"
+	e.boundary = x.boundary
+	x.r.releaseHeader()
+	if strings.TrimSpace(strings.Join(x.lines, "")) != "" {
+		x.startText()
+		for _, line := range x.lines {
+			x.next.writeLine(line)
+		}
+	}
+	x.lines = nil
+}
+
+func (x *fileExtractor) startText() {
+	x.next.writeLine("--" + x.boundary)
+	for _, line := range x.text {
+		x.next.writeLine(line)
+	}
+	x.next.writeLine("")
+	x.state = extractText
+}
+
+func (x *fileExtractor) startFile(name string) {
+	if x.yenc {
+		// fix: rewrite a yEnc-encoded file as a base64 attachment
+		x.r.fixed(FixYEncAttachments)
+	} else {
+		// fix: rewrite an uuencoded file as a base64 attachment
+		x.r.fixed(FixUUEncodedAttachments)
+	}
+	x.next.writeLine("--" + x.boundary)
+	x.next.writeLine("Content-Type: " + mime.FormatMediaType("application/octet-stream", map[string]string{"name": name}))
+	x.next.writeLine("Content-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	x.next.writeLine("Content-Transfer-Encoding: base64")
+	x.next.writeLine("")
+	x.state = extractFile
+}
+
+// flush encodes the complete lines of the decoded file, or all of it if last is set.
+func (x *fileExtractor) flush(last bool) {
+	n := len(x.buf) / base64Line * base64Line
+	if last {
+		n = len(x.buf)
+	}
+	for i := 0; i < n; i += base64Line {
+		j := i + base64Line
+		if j > n {
+			j = n
+		}
+		x.next.writeLine(base64.StdEncoding.EncodeToString(x.buf[i:j]))
+	}
+	x.buf = append(x.buf[:0], x.buf[n:]...)
+}
@@ -0,0 +1,72 @@
+package messagefix
+
+// FindingKind is the kind of a Finding.
+type FindingKind int
+
+const (
+	// FindingMailLoop is a mail loop signature: the same Received trace field repeated
+	// several times, or the same Delivered-To field repeated.
+	FindingMailLoop FindingKind = iota
+	// FindingFieldTruncated is a header field folded across so many lines that it exceeded
+	// the maximum field size of 256KiB, whose remaining continuation lines were dropped.
+	FindingFieldTruncated
+	// FindingHeaderless is a message without a header block, whose first line is not a
+	// header field.
+	FindingHeaderless
+	// FindingMaxDepth is a multipart or message/rfc822 part nested deeper than the maximum
+	// depth set with WithMaxDepth, whose body was processed as opaque content.
+	FindingMaxDepth
+	// FindingBoundaryCollision is a multipart whose boundary collides with the boundary of
+	// an enclosing multipart, whose delimiters cannot be told apart.
+	FindingBoundaryCollision
+	// FindingDKIMBroken is a DKIM signature of the message broken by the fixes, with
+	// WithDKIM.
+	FindingDKIMBroken
+	// FindingPartial is a message/partial fragment of a message (RFC 2046 section 5.2.2),
+	// whose body is kept as is, to be reassembled with Reassemble.
+	FindingPartial
+)
+
+func (k FindingKind) String() string {
+	switch k {
+	case FindingMailLoop:
+		return "mail-loop"
+	case FindingFieldTruncated:
+		return "field-truncated"
+	case FindingHeaderless:
+		return "headerless"
+	case FindingMaxDepth:
+		return "max-depth"
+	case FindingBoundaryCollision:
+		return "boundary-collision"
+	case FindingDKIMBroken:
+		return "dkim-broken"
+	case FindingPartial:
+		return "partial"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding is an issue detected in a message by the analysis of the Reader, which is
+// reported whether the Reader fixed it or not.
+type Finding struct {
+	Kind FindingKind
+	// Line is the 1-based input line number where the issue was found.
+	Line int
+	// Detail is a human-readable description of the issue.
+	Detail string
+}
+
+// Findings returns the issues found in the message read so far.
+func (r *Reader) Findings() []Finding {
+	return r.findings
+}
+
+func (r *Reader) find(kind FindingKind, line int, detail string) {
+	r.findings = append(r.findings, Finding{
+		Kind:   kind,
+		Line:   line,
+		Detail: detail,
+	})
+}
@@ -0,0 +1,331 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// Fixer is a step of the fixing pipeline of a Reader.
+//
+// The pipeline is applied to each header block of the message, including the header blocks
+// of its parts and embedded messages, once the block is read: first the built-in header
+// fixes enabled by options such as WithPriority or WithFixMIMEVersion, then the fixers passed
+// with WithFixers, in order.
+//
+// The other fixes of the Reader, including its default heuristics, run outside the pipeline:
+//   - the fixes of header lines, such as FixIndentContinuations, FixOrphanContinuations and
+//     FixBlankHeaderLines, run as the lines are read, so fixers see their result;
+//   - the fixes of the structure of a part, such as FixContainerEncoding and
+//     FixMissingBoundary, and the handling of its boundary and encoding, run after the
+//     pipeline, from the header block left by the fixers;
+//   - the fixes of delimiter lines and of the end of the message, such as
+//     FixCloseMultiparts, run as the body is read; the body functions of the fixers only see
+//     the body lines of leaf parts.
+type Fixer interface {
+	// FixHeader fixes a header block, before it is output.
+	FixHeader(h *Header)
+	// FixBody returns the function fixing the body lines of the leaf part whose header block
+	// is h, or nil if its body needs no fix. The lines are passed as read, without their line
+	// terminator, before the built-in body fixes such as the decoding of WithBinary.
+	//
+	// With WithParallelism, the function may be called on another goroutine than Read.
+	FixBody(h *Header) func(line string) string
+}
+
+// HeaderFixerFunc is a Fixer that only fixes header blocks.
+type HeaderFixerFunc func(h *Header)
+
+// FixHeader calls f(h).
+func (f HeaderFixerFunc) FixHeader(h *Header) {
+	f(h)
+}
+
+// FixBody returns nil.
+func (f HeaderFixerFunc) FixBody(h *Header) func(line string) string {
+	return nil
+}
+
+// WithFixers appends fixers to the pipeline of the Reader, after its built-in header fixes. The
+// fixes applied as header lines are read run before them; see Fixer.
+func WithFixers(fixers ...Fixer) Option {
+	return func(o *options) {
+		o.fixers = append(o.fixers, fixers...)
+	}
+}
+
+// WithHeaderHook appends a fixer calling hook for each field of each header block, with the
+// field name and unfolded value, after the built-in fixers. The field is replaced by the returned
+// name and value, or removed if hook returns false; fields whose name and value are kept are
+// output as read, keeping their folding.
+//
+// This is useful to strip or rewrite fields, such as X-Spam fields or Return-Path, in the
+// same pass as the fixes.
+func WithHeaderHook(hook func(name, value string) (string, string, bool)) Option {
+	return WithFixers(HeaderFixerFunc(func(h *Header) {
+		header := h.r.header[:0]
+		for _, f := range h.r.header {
+			name, value := strings.TrimRight(f.name, " \t"), f.value()
+			newName, newValue, ok := hook(name, value)
+			if !ok {
+				continue
+			}
+			if newName != name || newValue != value {
+				nf := newField(newName + ": " + newValue)
+				nf.line = f.line
+				f = nf
+			}
+			header = append(header, f)
+		}
+		h.r.header = header
+	}))
+}
+
+// Header is a header block being fixed by a Fixer.
+//
+// A Header is only valid during the call of the Fixer method it is passed to.
+type Header struct {
+	r *Reader
+}
+
+// Path returns the path of the part of the header block, such as "1.2", or "" for the
+// message header and the header of the messages embedded in it.
+func (h *Header) Path() string {
+	return h.r.entities[len(h.r.entities)-1].path
+}
+
+// IsMessage reports whether the header block is the message header.
+func (h *Header) IsMessage() bool {
+	return len(h.r.entities) == 1
+}
+
+// Len returns the count of fields of the header block.
+func (h *Header) Len() int {
+	return len(h.r.header)
+}
+
+// Key returns the name of the field at index i.
+func (h *Header) Key(i int) string {
+	return strings.TrimRight(h.r.header[i].name, " \t")
+}
+
+// Value returns the unfolded value of the field at index i.
+func (h *Header) Value(i int) string {
+	return h.r.header[i].value()
+}
+
+// Get returns the value of the first field with the passed name, case-insensitively,
+// or "" if there is none.
+func (h *Header) Get(name string) string {
+	if f := lookup(h.r.header, name); f != nil {
+		return f.value()
+	}
+	return ""
+}
+
+// Set sets the value of the first field with the passed name, removing the other fields
+// with that name, or adds a field if there is none.
+func (h *Header) Set(name, value string) {
+	f := lookup(h.r.header, name)
+	if f == nil {
+		h.Add(name, value)
+		return
+	}
+	f.setValue(value)
+	header := h.r.header[:0]
+	for _, hf := range h.r.header {
+		if hf == f || !hf.is(name) {
+			header = append(header, hf)
+		}
+	}
+	h.r.header = header
+}
+
+// Add adds a field at the end of the header block.
+func (h *Header) Add(name, value string) {
+	h.r.header = append(h.r.header, newField(name+": "+value))
+}
+
+// Del removes all fields with the passed name, case-insensitively.
+func (h *Header) Del(name string) {
+	header := h.r.header[:0]
+	for _, f := range h.r.header {
+		if !f.is(name) {
+			header = append(header, f)
+		}
+	}
+	h.r.header = header
+}
+
+// pipeline returns the fixers of the Reader: the built-in header fixes enabled by its options,
+// then the fixers passed with WithFixers. The fixes of readHeader run before them, and those
+// of endHeader and of the body after them.
+func (r *Reader) pipeline() []Fixer {
+	fixers := []Fixer{
+		HeaderFixerFunc(func(h *Header) {
+			if h.IsMessage() {
+				r.detectMailLoop(r.opts.truncateMailLoops)
+			}
+		}),
+	}
+	if r.opts.priority != PriorityKeep {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if h.IsMessage() {
+				var fixed bool
+				if r.header, fixed = normalizePriority(r.header, r.opts.priority); fixed {
+					r.fixed(FixPriority)
+				}
+			}
+		}))
+	}
+	if r.opts.autoSubmitted != "" {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if h.IsMessage() && r.stampAutoSubmitted() {
+				r.fixed(FixAutoSubmitted)
+			}
+		}))
+	}
+	if r.opts.precedence != "" {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if h.IsMessage() && lookup(r.header, "Precedence") == nil {
+				// fix: stamp the message with a Precedence field
+				r.fixed(FixPrecedence)
+				h.Add("Precedence", r.opts.precedence)
+			}
+		}))
+	}
+	if r.opts.fixReferences {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if h.IsMessage() && fixReferences(r.header) {
+				r.fixed(FixReferences)
+			}
+		}))
+	}
+	if r.opts.stripControls {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if stripControls(r.header, r.opts.controlReplacement) {
+				r.fixed(FixControlChars)
+			}
+		}))
+	}
+	if r.opts.defaultType != "" {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if h.IsMessage() && lookup(r.header, "Content-Type") == nil {
+				// fix: add the default Content-Type field
+				r.fixed(FixDefaultContentType)
+				h.Add("Content-Type", r.opts.defaultType)
+			}
+		}))
+	}
+	if r.opts.stripComments {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if stripFieldComments(r.header) {
+				r.fixed(FixComments)
+			}
+		}))
+	}
+	if r.opts.fixMisplacedParams {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			var fixed bool
+			if r.header, fixed = fixMisplacedParams(r.header); fixed {
+				r.fixed(FixMisplacedParams)
+			}
+		}))
+	}
+	if r.opts.contentLength != ContentLengthKeep {
+		fixers = append(fixers, HeaderFixerFunc(r.fixContentLength))
+	}
+	if r.opts.mapEncodings {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if fixEncodings(r.header) {
+				r.fixed(FixUnknownEncodings)
+			}
+		}))
+	}
+	if r.opts.fixMIMEVersion {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			var fixed bool
+			if r.header, fixed = fixMIMEVersion(r.header); fixed {
+				r.fixed(FixMIMEVersion)
+			}
+		}))
+	}
+	if r.opts.quoteBoundaries {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if quoteBoundary(r.header) {
+				r.fixed(FixQuoteBoundaries)
+			}
+		}))
+	}
+	if r.opts.strict {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			var fixed bool
+			if r.opts.allows(FixFieldNames) {
+				if r.header, fixed = fixFieldNames(r.header); fixed {
+					r.fixed(FixFieldNames)
+				}
+			}
+			if r.opts.allows(FixContentType) {
+				if r.header, fixed = fixContentType(r.header, r.opts.smtputf8); fixed {
+					r.fixed(FixContentType)
+				}
+			}
+		}))
+	}
+	if r.opts.dispositionFromName {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			var fixed bool
+			if r.header, fixed = addDisposition(r.header); fixed {
+				r.fixed(FixDispositionFilename)
+			}
+		}))
+	}
+	if r.opts.normalizeCharsets {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if normalizeCharset(r.header) {
+				r.fixed(FixCharsetNames)
+			}
+		}))
+	}
+	if r.opts.canonicalKeys {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if canonicalizeKeys(r.header, r.opts.archive1) {
+				r.fixed(FixCanonicalKeys)
+			}
+		}))
+	}
+	if r.opts.downgradeUTF8 {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if downgradeHeader(r.header) {
+				r.fixed(FixDowngradeUTF8)
+			}
+		}))
+	}
+	if r.opts.foldTraceWidth > 0 {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if foldTraceFields(r.header, r.opts.foldTraceWidth) {
+				r.fixed(FixFoldTraceFields)
+			}
+		}))
+	}
+	if r.opts.foldWidth > 0 {
+		fixers = append(fixers, HeaderFixerFunc(func(h *Header) {
+			if foldHeader(r.header, r.opts.foldWidth, r.opts.foldTraceWidth > 0) {
+				r.fixed(FixFoldHeaders)
+			}
+		}))
+	}
+	return append(fixers, r.opts.fixers...)
+}
+
+// lineFixer applies the body line function of a Fixer.
+type lineFixer struct {
+	fix  func(line string) string
+	next lineWriter
+}
+
+func (f lineFixer) writeLine(line string) {
+	f.next.writeLine(f.fix(line))
+}
+
+func (f lineFixer) end(delimiter bool) {
+	f.next.end(delimiter)
+}
@@ -0,0 +1,46 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+func TestStamps(t *testing.T) {
+	stamps := []Option{WithAutoSubmitted("auto-generated"), WithPrecedence("bulk")}
+	runFixTests(t, []fixTest{
+		{
+			name:  "unmarked message",
+			input: "Subject: test\r\n\r\nbody\r\n",
+			opts:  stamps,
+			want:  "Subject: test\r\nAuto-Submitted: auto-generated\r\nPrecedence: bulk\r\n\r\nbody\r\n",
+			fixes: []FixID{FixAutoSubmitted, FixPrecedence},
+		},
+		{
+			name:  "message marked as not automatically submitted",
+			input: "Auto-Submitted: no\r\nSubject: test\r\n\r\nbody\r\n",
+			opts:  stamps,
+			want:  "Auto-Submitted: auto-generated\r\nSubject: test\r\nPrecedence: bulk\r\n\r\nbody\r\n",
+			fixes: []FixID{FixAutoSubmitted, FixPrecedence},
+		},
+		{
+			name:  "marked message",
+			input: "Auto-Submitted: auto-replied\r\nPrecedence: list\r\n\r\nbody\r\n",
+			opts:  stamps,
+			want:  "Auto-Submitted: auto-replied\r\nPrecedence: list\r\n\r\nbody\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "stamps of parts",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nbody\r\n--b--\r\n",
+			opts:  stamps,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\nAuto-Submitted: auto-generated\r\nPrecedence: bulk\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{FixAutoSubmitted, FixPrecedence},
+		},
+		{
+			name:  "restricted fixes",
+			input: "Subject: test\r\n\r\nbody\r\n",
+			opts:  append([]Option{WithFixIDs(FixPrecedence)}, stamps...),
+			want:  "Subject: test\r\nPrecedence: bulk\r\n\r\nbody\r\n",
+			fixes: []FixID{FixPrecedence},
+		},
+	})
+}
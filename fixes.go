@@ -0,0 +1,506 @@
+package messagefix
+
+import (
+	"strconv"
+)
+
+// BehaviorVersion is the behavior version of the Reader, which is incremented each time
+// fixes are added or the output of a fix changes, so that stores can tell which fixes a
+// message was processed with.
+const BehaviorVersion = 50
+
+// FixID is the stable, machine-readable identifier of a fix.
+type FixID string
+
+const (
+	FixLineEndings           FixID = "line-endings"
+	FixCloseMultiparts       FixID = "close-multiparts"
+	FixIndentContinuations   FixID = "indent-continuations"
+	FixDecodeEncoding        FixID = "decode-encoding"
+	FixQuotedPrintable       FixID = "quoted-printable"
+	FixBase64Padding         FixID = "base64-padding"
+	FixCharsetNames          FixID = "charset-names"
+	FixSynthesizeEmpty       FixID = "synthesize-empty"
+	FixTranscode             FixID = "transcode"
+	FixCanonicalKeys         FixID = "canonical-keys"
+	FixInvalidUTF8           FixID = "invalid-utf8"
+	FixMailLoops             FixID = "mail-loops"
+	FixEncodingMismatch      FixID = "encoding-mismatch"
+	FixFromLine              FixID = "from-line"
+	FixFromQuoting           FixID = "from-quoting"
+	FixPriority              FixID = "priority"
+	FixFieldSize             FixID = "field-size"
+	FixContentType           FixID = "content-type"
+	FixFieldNames            FixID = "field-names"
+	FixMissingHeader         FixID = "missing-header"
+	FixMissingBoundary       FixID = "missing-boundary"
+	FixNestedMultiparts      FixID = "nested-multiparts"
+	FixDelimiterLines        FixID = "delimiter-lines"
+	FixUnterminatedHeader    FixID = "unterminated-header"
+	FixEmptyEmbeddedMessages FixID = "empty-embedded-messages"
+	FixHeaderless            FixID = "headerless"
+	FixSplitDelimiters       FixID = "split-delimiters"
+	FixMisplacedParams       FixID = "misplaced-params"
+	FixContainerEncoding     FixID = "container-encoding"
+	FixEncodedMultiparts     FixID = "encoded-multiparts"
+	FixFoldHeaders           FixID = "fold-headers"
+	FixEncodeAttachments     FixID = "encode-attachments"
+	FixUnusedBoundaries      FixID = "unused-boundaries"
+	FixQuoteBoundaries       FixID = "quote-boundaries"
+	FixBoundaryCollisions    FixID = "boundary-collisions"
+	FixFinalEmptyLine        FixID = "final-empty-line"
+	FixOrphanContinuations   FixID = "orphan-continuations"
+	FixBlankHeaderLines      FixID = "blank-header-lines"
+	FixControlChars          FixID = "control-chars"
+	FixDefaultContentType    FixID = "default-content-type"
+	FixUUEncodedAttachments  FixID = "uuencoded-attachments"
+	FixYEncAttachments       FixID = "yenc-attachments"
+	FixTNEF                  FixID = "tnef"
+	FixDispositionFilename   FixID = "disposition-filename"
+	FixMIMEVersion           FixID = "mime-version"
+	FixUnknownEncodings      FixID = "unknown-encodings"
+	FixContentLength         FixID = "content-length"
+	FixDoubleQuotedPrintable FixID = "double-quoted-printable"
+	FixQuotedPrintableLines  FixID = "quoted-printable-lines"
+	FixBase64Lines           FixID = "base64-lines"
+	FixMissingColons         FixID = "missing-colons"
+	FixDowngradeUTF8         FixID = "downgrade-utf8"
+	FixComments              FixID = "comments"
+	FixSniffContentType      FixID = "sniff-content-type"
+	FixReferences            FixID = "references"
+	FixFoldTraceFields       FixID = "fold-trace-fields"
+	FixAutoSubmitted         FixID = "auto-submitted"
+	FixPrecedence            FixID = "precedence"
+)
+
+// Risk is how likely a fix is to alter the meaning of a message.
+type Risk int
+
+const (
+	// RiskLow fixes only change the syntax of a message.
+	RiskLow Risk = iota
+	// RiskMedium fixes rewrite content into an equivalent form, which relies on a guess
+	// in some cases.
+	RiskMedium
+	// RiskHigh fixes drop or replace content: they are the fixes whose descriptor is Lossy.
+	RiskHigh
+)
+
+func (r Risk) String() string {
+	switch r {
+	case RiskLow:
+		return "low"
+	case RiskMedium:
+		return "medium"
+	case RiskHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// FixDescriptor describes a fix of the Reader.
+type FixDescriptor struct {
+	ID FixID
+	// Description is a human-readable description of the fix.
+	Description string
+	// Default is set when the fix is applied without any option.
+	Default bool
+	// Option is the name of the option enabling the fix, if it is not applied by default.
+	Option string
+	Risk   Risk
+	// Since is the behavior version that introduced the fix.
+	Since int
+	// Lossy is set when the fix drops or replaces content, which cannot be recovered from the
+	// fixed message. Lossy fixes, and only them, are RiskHigh.
+	Lossy bool
+}
+
+var fixes = []FixDescriptor{
+	{FixLineEndings, "terminate all lines with CRLF", true, "", RiskLow, 1, false},
+	{FixCloseMultiparts, "close the multiparts still open at EOF", true, "", RiskLow, 1, false},
+	{FixIndentContinuations, "indent the continuation lines of header fields", true, "", RiskLow, 1, false},
+	{FixDecodeEncoding, "decode base64 and quoted-printable part bodies", false, "WithBinary", RiskLow, 2, false},
+	{FixQuotedPrintable, "repair invalid quoted-printable part bodies", false, "WithFixQuotedPrintable", RiskLow, 3, false},
+	{FixBase64Padding, "pad truncated base64 part bodies, or remove their last quantum", false, "WithFixBase64Padding", RiskHigh, 4, true},
+	{FixCharsetNames, "rewrite bogus charset labels to their IANA name", false, "WithNormalizeCharsets", RiskLow, 5, false},
+	{FixSynthesizeEmpty, "synthesize a minimal message on empty input", false, "WithEmptyMode", RiskMedium, 6, false},
+	{FixTranscode, "transcode text parts to UTF-8", false, "WithTranscode", RiskMedium, 7, false},
+	{FixCanonicalKeys, "rewrite header field names to their canonical form", false, "WithCanonicalKeys", RiskLow, 8, false},
+	{FixInvalidUTF8, "replace invalid UTF-8 in text parts declared as UTF-8", false, "WithReplaceInvalidUTF8", RiskHigh, 9, true},
+	{FixMailLoops, "remove trace fields repeated by a mail loop", false, "WithTruncateMailLoops", RiskHigh, 10, true},
+	{FixEncodingMismatch, "declare the actual encoding of 7bit parts containing 8-bit bytes", false, "WithEncodingMismatch", RiskLow, 11, false},
+	{FixFromLine, "remove a leading mbox From_ line", false, "WithStripFromLine", RiskLow, 12, false},
+	{FixFromQuoting, "remove the mbox quoting of >From lines", false, "WithUnescapeFrom", RiskMedium, 12, false},
+	{FixPriority, "reconcile the priority header fields", false, "WithPriority", RiskHigh, 13, true},
+	{FixFieldSize, "drop the continuation lines of header fields larger than 256KiB", true, "", RiskHigh, 14, true},
+	{FixContentType, "rewrite Content-Type fields in a standard form", false, "WithProfile", RiskHigh, 15, true},
+	{FixFieldNames, "rewrite or remove invalid header field names", false, "WithProfile", RiskHigh, 15, true},
+	{FixMissingHeader, "process parts starting with a non-header line as having an empty header block", false, "WithProfile", RiskLow, 15, false},
+	{FixMissingBoundary, "declare a boundary for multiparts without a usable one", false, "WithProfile", RiskMedium, 15, false},
+	{FixNestedMultiparts, "close the multiparts nested in a part ended by a delimiter", false, "WithProfile", RiskLow, 15, false},
+	{FixDelimiterLines, "remove any content after boundary delimiters", false, "WithProfile", RiskHigh, 15, true},
+	{FixUnterminatedHeader, "end the header blocks of parts ended by a delimiter or EOF", false, "WithProfile", RiskLow, 15, false},
+	{FixEmptyEmbeddedMessages, "add the empty line of empty embedded messages", false, "WithProfile", RiskLow, 15, false},
+	{FixHeaderless, "process input without a header block as a body", false, "WithHeaderless", RiskMedium, 16, false},
+	{FixSplitDelimiters, "rejoin delimiter lines split across two lines", false, "WithRejoinDelimiters", RiskMedium, 17, false},
+	{FixMisplacedParams, "relocate parameters of fields that take none to Content-Type", false, "WithFixMisplacedParams", RiskHigh, 18, true},
+	{FixContainerEncoding, "remove invalid encodings of multipart and message entities", false, "WithFixContainerEncoding", RiskLow, 19, false},
+	{FixEncodedMultiparts, "decode the body of multiparts and messages declared as base64", false, "WithDecodeMultiparts", RiskMedium, 20, false},
+	{FixFoldHeaders, "fold long header lines at whitespace", false, "WithFoldHeaders", RiskLow, 21, false},
+	{FixEncodeAttachments, "encode the body of attachments as base64", false, "WithEncodeAttachments", RiskLow, 21, false},
+	{FixUnusedBoundaries, "rewrite multiparts whose boundary never appears as text/plain", false, "WithFixUnusedBoundaries", RiskMedium, 23, false},
+	{FixQuoteBoundaries, "quote boundary parameters that are not tokens", false, "WithQuoteBoundaries", RiskLow, 24, false},
+	{FixBoundaryCollisions, "rename boundaries of multiparts colliding with an enclosing multipart", false, "WithRenameBoundaries", RiskMedium, 25, false},
+	{FixFinalEmptyLine, "end messages with an empty line", false, "WithFinalEmptyLine", RiskLow, 26, false},
+	{FixOrphanContinuations, "unindent, promote or drop continuation lines starting a header block", false, "WithOrphanContinuations", RiskHigh, 27, true},
+	{FixBlankHeaderLines, "end header blocks at, or remove, whitespace-only lines", false, "WithBlankHeaderLines", RiskMedium, 28, false},
+	{FixControlChars, "remove or replace control characters in header field bodies", false, "WithStripControls", RiskHigh, 29, true},
+	{FixDefaultContentType, "add a default Content-Type field to messages without one", false, "WithDefaultContentType", RiskLow, 30, false},
+	{FixUUEncodedAttachments, "rewrite messages containing uuencoded files as multipart/mixed", false, "WithUUEncodedAttachments", RiskMedium, 31, false},
+	{FixYEncAttachments, "rewrite messages containing yEnc-encoded files as multipart/mixed", false, "WithYEncAttachments", RiskMedium, 32, false},
+	{FixTNEF, "replace TNEF containers with the files they contain", false, "WithExpandTNEF", RiskHigh, 33, true},
+	{FixDispositionFilename, "declare the Content-Type name of parts as their Content-Disposition filename", false, "WithDispositionFromName", RiskLow, 34, false},
+	{FixMIMEVersion, "collapse duplicate or invalid MIME-Version fields into a single valid one", false, "WithFixMIMEVersion", RiskLow, 35, false},
+	{FixUnknownEncodings, "rewrite unknown Content-Transfer-Encoding values to a standard encoding", false, "WithMapEncodings", RiskMedium, 36, false},
+	{FixContentLength, "remove or recompute stale Content-Length fields", false, "WithContentLength", RiskLow, 37, false},
+	{FixDoubleQuotedPrintable, "decode a layer of quoted-printable parts encoded twice", false, "WithFixDoubleQuotedPrintable", RiskMedium, 38, false},
+	{FixQuotedPrintableLines, "split quoted-printable lines longer than 76 characters", false, "WithWrapQuotedPrintable", RiskLow, 39, false},
+	{FixBase64Lines, "strip whitespace from base64 lines and split the long ones", false, "WithNormalizeBase64", RiskLow, 40, false},
+	{FixMissingColons, "insert the missing colon of header lines starting with a known field name", false, "WithInsertColons", RiskMedium, 41, false},
+	{FixDowngradeUTF8, "downgrade internationalized header fields to ASCII", false, "WithDowngradeUTF8", RiskHigh, 42, true},
+	{FixComments, "remove the comments of MIME header fields", false, "WithStripComments", RiskHigh, 43, true},
+	{FixSniffContentType, "declare the media type sniffed from the body of messages without one", false, "WithSniffContentType", RiskMedium, 44, false},
+	{FixReferences, "rewrite References and In-Reply-To fields as lists of msg-ids", false, "WithFixReferences", RiskHigh, 45, true},
+	{FixFoldTraceFields, "fold long Received and Authentication-Results fields between their clauses", false, "WithFoldTraceFields", RiskLow, 46, false},
+	{FixAutoSubmitted, "stamp messages with an Auto-Submitted field", false, "WithAutoSubmitted", RiskLow, 48, false},
+	{FixPrecedence, "stamp messages with a Precedence field", false, "WithPrecedence", RiskLow, 48, false},
+}
+
+// fixIndexes are the indexes of the descriptors of fixes.
+// behaviorVersions are the fixes added by each behavior version, or whose output it changed:
+// behaviorVersions[v-1] are the fixes of version v. The Since field of each descriptor is the
+// first version listing its fix, and BehaviorVersion is the last version.
+var behaviorVersions = [][]FixID{
+	{FixLineEndings, FixCloseMultiparts, FixIndentContinuations}, // 1
+	{FixDecodeEncoding},           // 2
+	{FixQuotedPrintable},          // 3
+	{FixBase64Padding},            // 4
+	{FixCharsetNames},             // 5
+	{FixSynthesizeEmpty},          // 6
+	{FixTranscode},                // 7
+	{FixCanonicalKeys},            // 8
+	{FixInvalidUTF8},              // 9
+	{FixMailLoops},                // 10
+	{FixEncodingMismatch},         // 11
+	{FixFromLine, FixFromQuoting}, // 12
+	{FixPriority},                 // 13
+	{FixFieldSize},                // 14
+	{FixContentType, FixFieldNames, FixMissingHeader, FixMissingBoundary, FixNestedMultiparts, FixDelimiterLines, FixUnterminatedHeader, FixEmptyEmbeddedMessages}, // 15
+	{FixHeaderless},                          // 16
+	{FixSplitDelimiters},                     // 17
+	{FixMisplacedParams},                     // 18
+	{FixContainerEncoding},                   // 19
+	{FixEncodedMultiparts},                   // 20
+	{FixFoldHeaders, FixEncodeAttachments},   // 21
+	{FixEncodedMultiparts},                   // 22
+	{FixUnusedBoundaries},                    // 23
+	{FixQuoteBoundaries},                     // 24
+	{FixBoundaryCollisions},                  // 25
+	{FixFinalEmptyLine},                      // 26
+	{FixOrphanContinuations},                 // 27
+	{FixBlankHeaderLines},                    // 28
+	{FixControlChars},                        // 29
+	{FixDefaultContentType},                  // 30
+	{FixUUEncodedAttachments},                // 31
+	{FixYEncAttachments},                     // 32
+	{FixTNEF},                                // 33
+	{FixDispositionFilename},                 // 34
+	{FixMIMEVersion},                         // 35
+	{FixUnknownEncodings},                    // 36
+	{FixContentLength},                       // 37
+	{FixDoubleQuotedPrintable},               // 38
+	{FixQuotedPrintableLines},                // 39
+	{FixBase64Lines},                         // 40
+	{FixMissingColons},                       // 41
+	{FixDowngradeUTF8},                       // 42
+	{FixComments},                            // 43
+	{FixSniffContentType},                    // 44
+	{FixReferences},                          // 45
+	{FixFoldTraceFields},                     // 46
+	{FixContainerEncoding, FixCanonicalKeys}, // 47
+	{FixAutoSubmitted, FixPrecedence},        // 48
+	{FixBase64Padding},                       // 49
+	{FixDoubleQuotedPrintable},               // 50
+}
+
+var fixIndexes = func() map[FixID]int {
+	m := make(map[FixID]int, len(fixes))
+	for i, f := range fixes {
+		m[f.ID] = i
+	}
+	return m
+}()
+
+// Fixes returns the descriptors of all fixes supported by the Reader, so that applications can
+// list them, for example in a settings interface.
+func Fixes() []FixDescriptor {
+	return append([]FixDescriptor(nil), fixes...)
+}
+
+// LookupFix returns the descriptor of a fix, and whether the fix exists.
+func LookupFix(id FixID) (FixDescriptor, bool) {
+	i, ok := fixIndexes[id]
+	if !ok {
+		return FixDescriptor{}, false
+	}
+	return fixes[i], true
+}
+
+// WithFixIDs restricts the fixes of the Reader to the passed fixes. Fixes that are not applied
+// by default must still be enabled by their option; FixLineEndings is always applied, since
+// the output is made of CRLF-terminated lines.
+//
+// This is useful to re-fix stored messages with only some fixes. When several of WithFixIDs and
+// WithFixesAfter are passed, only the fixes allowed by all of them are applied.
+func WithFixIDs(ids ...FixID) Option {
+	return func(o *options) {
+		allowed := make(map[FixID]bool, len(ids))
+		for _, id := range ids {
+			allowed[id] = true
+		}
+		o.restrictFixes(allowed)
+	}
+}
+
+// WithFixesAfter restricts the fixes of the Reader to the fixes introduced after the passed
+// behavior version, or whose output changed since, as WithFixIDs does. This is useful to
+// re-fix messages stored after being fixed by a Reader of that behavior version with the
+// fixes introduced or changed since.
+func WithFixesAfter(version int) Option {
+	return func(o *options) {
+		allowed := make(map[FixID]bool)
+		// behaviorVersions[version] are the fixes of the version following version
+		for v := max(version, 0); v < len(behaviorVersions); v++ {
+			for _, id := range behaviorVersions[v] {
+				allowed[id] = true
+			}
+		}
+		o.restrictFixes(allowed)
+	}
+}
+
+func (o *options) restrictFixes(allowed map[FixID]bool) {
+	if o.allowed != nil {
+		for id := range allowed {
+			if !o.allowed[id] {
+				delete(allowed, id)
+			}
+		}
+	}
+	o.allowed = allowed
+}
+
+// allows reports whether the fix is allowed by WithFixIDs and WithFixesAfter.
+func (o *options) allows(id FixID) bool {
+	return o.allowed == nil || o.allowed[id]
+}
+
+// applyAllowed disables the options enabling fixes that are not allowed.
+func (o *options) applyAllowed() {
+	if o.allowed == nil {
+		return
+	}
+	o.binary = o.binary && o.allows(FixDecodeEncoding)
+	o.fixQuotedPrintable = o.fixQuotedPrintable && o.allows(FixQuotedPrintable)
+	o.fixBase64Padding = o.fixBase64Padding && o.allows(FixBase64Padding)
+	o.normalizeCharsets = o.normalizeCharsets && o.allows(FixCharsetNames)
+	o.transcode = o.transcode && o.allows(FixTranscode)
+	o.canonicalKeys = o.canonicalKeys && o.allows(FixCanonicalKeys)
+	o.replaceUTF8 = o.replaceUTF8 && o.allows(FixInvalidUTF8)
+	o.truncateMailLoops = o.truncateMailLoops && o.allows(FixMailLoops)
+	o.stripFromLine = o.stripFromLine && o.allows(FixFromLine)
+	o.unescapeFrom = o.unescapeFrom && o.allows(FixFromQuoting)
+	o.detectFromLine = o.detectFromLine && o.allows(FixFromLine)
+	o.rejoinDelimiters = o.rejoinDelimiters && o.allows(FixSplitDelimiters)
+	o.fixMisplacedParams = o.fixMisplacedParams && o.allows(FixMisplacedParams)
+	o.fixContainerEncoding = o.fixContainerEncoding && o.allows(FixContainerEncoding)
+	o.decodeMultiparts = o.decodeMultiparts && o.allows(FixEncodedMultiparts)
+	o.fixUnusedBoundaries = o.fixUnusedBoundaries && o.allows(FixUnusedBoundaries)
+	o.quoteBoundaries = o.quoteBoundaries && o.allows(FixQuoteBoundaries)
+	o.renameBoundaries = o.renameBoundaries && o.allows(FixBoundaryCollisions)
+	o.finalEmptyLine = o.finalEmptyLine && o.allows(FixFinalEmptyLine)
+	o.encodeAttachments = o.encodeAttachments && o.allows(FixEncodeAttachments)
+	o.stripControls = o.stripControls && o.allows(FixControlChars)
+	o.uuAttachments = o.uuAttachments && o.allows(FixUUEncodedAttachments)
+	o.yencAttachments = o.yencAttachments && o.allows(FixYEncAttachments)
+	o.expandTNEF = o.expandTNEF && o.allows(FixTNEF)
+	o.dispositionFromName = o.dispositionFromName && o.allows(FixDispositionFilename)
+	o.fixMIMEVersion = o.fixMIMEVersion && o.allows(FixMIMEVersion)
+	o.mapEncodings = o.mapEncodings && o.allows(FixUnknownEncodings)
+	o.fixDoubleQP = o.fixDoubleQP && o.allows(FixDoubleQuotedPrintable)
+	o.wrapQP = o.wrapQP && o.allows(FixQuotedPrintableLines)
+	o.normalizeBase64 = o.normalizeBase64 && o.allows(FixBase64Lines)
+	o.insertColons = o.insertColons && o.allows(FixMissingColons)
+	o.downgradeUTF8 = o.downgradeUTF8 && o.allows(FixDowngradeUTF8)
+	o.stripComments = o.stripComments && o.allows(FixComments)
+	o.sniffContentType = o.sniffContentType && o.allows(FixSniffContentType)
+	o.fixReferences = o.fixReferences && o.allows(FixReferences)
+	if !o.allows(FixFoldHeaders) {
+		o.foldWidth = 0
+	}
+	if !o.allows(FixFoldTraceFields) {
+		o.foldTraceWidth = 0
+	}
+	if o.emptyMode == EmptySynthesize && !o.allows(FixSynthesizeEmpty) {
+		o.emptyMode = EmptyPassThrough
+	}
+	if !o.allows(FixHeaderless) {
+		o.headerless = HeaderlessIgnore
+	}
+	if !o.allows(FixOrphanContinuations) {
+		o.orphans = OrphanKeep
+	}
+	if !o.allows(FixBlankHeaderLines) {
+		o.blankLines = BlankLinesKeep
+	}
+	if !o.allows(FixContentLength) {
+		o.contentLength = ContentLengthKeep
+	}
+	if !o.allows(FixDefaultContentType) {
+		o.defaultType = ""
+	}
+	if !o.allows(FixEncodingMismatch) {
+		o.encodingMismatch = EncodingMismatchIgnore
+	}
+	if !o.allows(FixPriority) {
+		o.priority = PriorityKeep
+	}
+	if !o.allows(FixAutoSubmitted) {
+		o.autoSubmitted = ""
+	}
+	if !o.allows(FixPrecedence) {
+		o.precedence = ""
+	}
+}
+
+// Fix is a fix applied by a Reader, as reported by WithOnFix.
+type Fix struct {
+	ID FixID
+	// Path is the path of the part the fix was applied to, such as "1.2", or "" for the message.
+	Path string
+	// Line is the 1-based input line number where the fix was applied, or 0 for fixes of part
+	// bodies.
+	Line int
+}
+
+// WithOnFix sets a function called for each fix applied by the Reader, as it is applied,
+// except FixLineEndings.
+//
+// This is useful to observe the fixes of large messages while they are streamed. With
+// WithParallelism, fn may be called concurrently on other goroutines than Read, for the fixes
+// of part bodies.
+func WithOnFix(fn func(Fix)) Option {
+	return func(o *options) {
+		o.onFix = fn
+	}
+}
+
+// WithOnLossyFix sets a function called for each lossy fix applied by the Reader, whose
+// descriptor has Lossy set, as it is applied and before its output is returned, in addition
+// to the function of WithOnFix.
+//
+// This is useful for interactive tools, which can ask the user whether to keep the fixed
+// message before it is committed, or stop reading it. With WithParallelism, fn may be called
+// concurrently on other goroutines than Read, for the fixes of part bodies.
+func WithOnLossyFix(fn func(Fix)) Option {
+	return func(o *options) {
+		o.onLossyFix = fn
+	}
+}
+
+// lossyFixes returns a function calling fn for lossy fixes, then calling next if it is not nil.
+func lossyFixes(fn func(Fix), next func(Fix)) func(Fix) {
+	return func(f Fix) {
+		if d, ok := LookupFix(f.ID); ok && d.Lossy {
+			fn(f)
+		}
+		if next != nil {
+			next(f)
+		}
+	}
+}
+
+// WithRejectFixes makes the Reader return a *FixError instead of applying any of the passed
+// fixes, identifying the violation, for example to find which senders produce broken messages
+// before fixing them. The fixes must still be enabled by their option, since the Reader only
+// detects the violations it can fix; FixLineEndings is never rejected.
+//
+// The Reader stops at the first rejected fix, once the input line it was applied to is
+// processed, so that the output returned before the error may include the rejected fix.
+func WithRejectFixes(ids ...FixID) Option {
+	return func(o *options) {
+		o.reject = make(map[FixID]bool, len(ids))
+		for _, id := range ids {
+			o.reject[id] = true
+		}
+	}
+}
+
+// FixError is returned by Reader when it detects a violation whose fix is rejected with
+// WithRejectFixes.
+type FixError struct {
+	Fix Fix
+}
+
+func (e *FixError) Error() string {
+	s := "messagefix: rejected fix " + string(e.Fix.ID)
+	if e.Fix.Path != "" {
+		s += " of part " + e.Fix.Path
+	}
+	if e.Fix.Line > 0 {
+		s += " at line " + strconv.Itoa(e.Fix.Line)
+	}
+	return s
+}
+
+// rejection returns the error of the first fix rejected by WithRejectFixes that was applied,
+// if any.
+func (r *Reader) rejection() error {
+	if r.opts.reject == nil {
+		return nil
+	}
+	return r.stats.rejection()
+}
+
+// fixed reports a fix applied to the current part at the current line.
+func (r *Reader) fixed(id FixID) {
+	f := Fix{ID: id, Path: r.entities[len(r.entities)-1].path, Line: r.line}
+	r.stats.fixed(f)
+	if r.onFix != nil {
+		r.onFix(f)
+	}
+}
+
+// reporter reports the fixes of a part body, possibly from a job.
+type reporter func(id FixID)
+
+// bodyReporter returns the reporter of the fixes of the current part body.
+func (r *Reader) bodyReporter() reporter {
+	fn := r.onFix
+	stats := &r.stats
+	path := r.entities[len(r.entities)-1].path
+	return func(id FixID) {
+		f := Fix{ID: id, Path: path}
+		stats.fixed(f)
+		if fn != nil {
+			fn(f)
+		}
+	}
+}
+
+func (rep reporter) fixed(id FixID) {
+	if rep != nil {
+		rep(id)
+	}
+}
@@ -0,0 +1,58 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+func TestFixesRisk(t *testing.T) {
+	for _, f := range Fixes() {
+		if f.Lossy != (f.Risk == RiskHigh) {
+			t.Errorf("fix %v: lossy %v with risk %v", f.ID, f.Lossy, f.Risk)
+		}
+	}
+}
+
+func TestFixesSince(t *testing.T) {
+	if len(behaviorVersions) != BehaviorVersion {
+		t.Errorf("%v behavior versions, want BehaviorVersion %v", len(behaviorVersions), BehaviorVersion)
+	}
+	since := make(map[FixID]int)
+	for i, ids := range behaviorVersions {
+		if len(ids) == 0 {
+			t.Errorf("behavior version %v: no fixes", i+1)
+		}
+		for _, id := range ids {
+			if _, ok := LookupFix(id); !ok {
+				t.Errorf("behavior version %v: unknown fix %v", i+1, id)
+			}
+			if _, ok := since[id]; !ok {
+				since[id] = i + 1
+			}
+		}
+	}
+	for _, f := range Fixes() {
+		if f.Since != since[f.ID] {
+			t.Errorf("fix %v: since %v, want %v", f.ID, f.Since, since[f.ID])
+		}
+	}
+}
+
+func TestFixesAfter(t *testing.T) {
+	input := "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/rfc822\r\nContent-Transfer-Encoding: base64\r\n\r\nSubject: plain\r\n\r\nbody\r\n"
+	runFixTests(t, []fixTest{
+		{
+			name:  "changed fix",
+			input: input,
+			opts:  []Option{WithFixContainerEncoding(true), WithFixesAfter(46)},
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/rfc822\r\n\r\nSubject: plain\r\n\r\nbody\r\n",
+			fixes: []FixID{FixContainerEncoding},
+		},
+		{
+			name:  "unchanged fixes",
+			input: input,
+			opts:  []Option{WithFixContainerEncoding(true), WithFixesAfter(BehaviorVersion)},
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: message/rfc822\r\nContent-Transfer-Encoding: base64\r\n\r\nSubject: plain\r\n\r\nbody\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,89 @@
+package messagefix
+
+import (
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderField is a field of a header block, with its unfolded value.
+type HeaderField struct {
+	Key, Value string
+}
+
+// Fields returns the fields of the header block, with their unfolded value, in order.
+//
+// Fixers appended last with WithFixers can use it to keep the repaired header blocks of a
+// message, to output them again later with FormatHeader.
+func (h *Header) Fields() []HeaderField {
+	fields := make([]HeaderField, len(h.r.header))
+	for i := range fields {
+		fields[i] = HeaderField{Key: h.Key(i), Value: h.Value(i)}
+	}
+	return fields
+}
+
+// Header returns the fields of the fixed message header, with their unfolded value as output,
+// keyed by their canonical name, or nil if the empty line ending the message header was not
+// output yet.
+//
+// This enables routing or indexing messages by fields such as Subject, From or Message-ID
+// without parsing the fixed message again. Encoded-words are not decoded.
+func (r *Reader) Header() textproto.MIMEHeader {
+	if r.messageHeader == nil {
+		return nil
+	}
+	h := make(textproto.MIMEHeader, len(r.messageHeader))
+	for k, v := range r.messageHeader {
+		h[k] = append([]string(nil), v...)
+	}
+	return h
+}
+
+// unstructuredFields are the fields whose value is unstructured text (RFC 5322), which can be
+// encoded as a whole with RFC 2047 encoded-words.
+var unstructuredFields = map[string]bool{
+	"Subject":             true,
+	"Comments":            true,
+	"Content-Description": true,
+}
+
+// FormatHeader returns a header block made of fields, ending with the empty line that ends
+// header blocks, in a compliant form, so that servers caching parsed header blocks can output
+// them again without keeping their original form:
+//   - field names are made valid tokens, and fields with an empty name are dropped;
+//   - line breaks and other control characters in values are replaced with spaces;
+//   - non-ASCII values of unstructured fields, such as Subject, are encoded as RFC 2047
+//     encoded-words, with invalid UTF-8 replaced with U+FFFD;
+//   - lines longer than 78 characters are folded at whitespace.
+//
+// Lines are terminated by CRLF.
+func FormatHeader(fields []HeaderField) []byte {
+	header := make([]*field, 0, len(fields))
+	for _, hf := range fields {
+		value := strings.Map(func(r rune) rune {
+			if r < ' ' && r != '\t' || r == 0x7F {
+				return ' '
+			}
+			return r
+		}, hf.Value)
+		value = strings.Trim(value, " \t")
+		if key := canonicalHeaderKey(hf.Key); unstructuredFields[key] && has8Bit(value) {
+			value = mime.QEncoding.Encode("utf-8", strings.ToValidUTF8(value, "\uFFFD"))
+		}
+		header = append(header, &field{
+			name:  hf.Key,
+			lines: []string{hf.Key + ": " + value},
+		})
+	}
+	header, _ = fixFieldNames(header)
+	var b []byte
+	for _, f := range header {
+		foldField(f, 78)
+		for _, line := range f.lines {
+			b = append(b, line...)
+			b = append(b, "\r\n"...)
+		}
+	}
+	return append(b, "\r\n"...)
+}
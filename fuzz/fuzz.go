@@ -0,0 +1,155 @@
+// Package fuzz provides fuzz targets for messagefix.
+//
+// FuzzReader, in fuzz_test.go, is a native Go fuzz target, run with:
+//
+//	go test -fuzz FuzzReader ./fuzz
+//
+// Its seed corpus is in testdata/fuzz/FuzzReader, which downstream users can extend with
+// their own messages. Fuzz follows the go-fuzz convention, for other fuzzing engines.
+package fuzz
+
+import (
+	"github.com/delthas/go-messagefix"
+)
+
+// OptionBytes is the count of bytes of flags read by Options. Fuzz reads them from the start
+// of its data.
+const OptionBytes = 10
+
+// flagReader reads unsigned values from the bits of flags, in order, as zeros past its end.
+type flagReader struct {
+	flags []byte
+	bit   int
+}
+
+func (f *flagReader) bits(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		if j := f.bit / 8; j < len(f.flags) && f.flags[j]&(1<<(f.bit%8)) != 0 {
+			v |= 1 << i
+		}
+		f.bit++
+	}
+	return v
+}
+
+func (f *flagReader) bool() bool {
+	return f.bits(1) != 0
+}
+
+// choice returns a value in [0, n), from the bits needed for n-1.
+func (f *flagReader) choice(n int) int {
+	bits := 0
+	for 1<<bits < n {
+		bits++
+	}
+	return f.bits(bits) % n
+}
+
+// Options returns the options enabled by the bits of flags, so that fuzzers explore all
+// combinations of options. Only the first OptionBytes bytes of flags are read; missing bytes
+// are read as zeros.
+//
+// The options whose values are not taken from the message, such as callbacks, contexts and
+// temporary directories, are not covered.
+func Options(flags []byte) []messagefix.Option {
+	f := &flagReader{flags: flags}
+	opts := []messagefix.Option{
+		messagefix.WithBinary(f.bool()),
+		messagefix.WithFixQuotedPrintable(f.bool()),
+		messagefix.WithFixBase64Padding(f.bool()),
+		messagefix.WithNormalizeCharsets(f.bool()),
+		messagefix.WithTranscode(f.bool()),
+		messagefix.WithCanonicalKeys(f.bool()),
+		messagefix.WithTruncateMailLoops(f.bool()),
+		messagefix.WithEncodingMismatch(messagefix.EncodingMismatch(f.choice(3))),
+		messagefix.WithStripFromLine(f.bool()),
+		messagefix.WithUnescapeFrom(f.bool()),
+		messagefix.WithPriority(messagefix.PriorityForm(f.choice(4))),
+		messagefix.WithEmptyMode(messagefix.EmptyMode(f.choice(3))),
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithReplaceInvalidUTF8("\uFFFD"))
+	}
+	// presets and profiles override the options passed before them
+	preset := messagefix.Preset(f.choice(3))
+	profile := messagefix.Profile(f.choice(7))
+	opts = append([]messagefix.Option{
+		messagefix.WithPreset(preset),
+		messagefix.WithProfile(profile),
+	}, opts...)
+
+	opts = append(opts,
+		messagefix.WithDecodeMultiparts(f.bool()),
+		messagefix.WithFixMisplacedParams(f.bool()),
+		messagefix.WithFixContainerEncoding(f.bool()),
+		messagefix.WithFixUnusedBoundaries(f.bool()),
+		messagefix.WithQuoteBoundaries(f.bool()),
+		messagefix.WithRenameBoundaries(f.bool()),
+		messagefix.WithFinalEmptyLine(f.bool()),
+		messagefix.WithRejoinDelimiters(f.bool()),
+		messagefix.WithFromLineDetection(f.bool()),
+		messagefix.WithDispositionFromName(f.bool()),
+		messagefix.WithFixMIMEVersion(f.bool()),
+		messagefix.WithMapEncodings(f.bool()),
+		messagefix.WithNormalizeBase64(f.bool()),
+		messagefix.WithWrapQuotedPrintable(f.bool()),
+		messagefix.WithFixDoubleQuotedPrintable(f.bool()),
+		messagefix.WithInsertColons(f.bool()),
+		messagefix.WithStripComments(f.bool()),
+		messagefix.WithExpandTNEF(f.bool()),
+		messagefix.WithUUEncodedAttachments(f.bool()),
+		messagefix.WithYEncAttachments(f.bool()),
+		messagefix.WithEncodeAttachments(f.bool()),
+		messagefix.WithDowngradeUTF8(f.bool()),
+		messagefix.WithSMTPUTF8(f.bool()),
+		messagefix.WithSniffContentType(f.bool()),
+		messagefix.WithFixReferences(f.bool()),
+		messagefix.WithLFOutput(f.bool()),
+		messagefix.WithPreserveLineEndings(f.bool()),
+		messagefix.WithDotStuffing(f.bool()),
+		messagefix.WithDotUnstuffing(f.bool()),
+		messagefix.WithOrphanContinuations(messagefix.OrphanMode(f.choice(4))),
+		messagefix.WithBlankHeaderLines(messagefix.BlankLineMode(f.choice(3))),
+		messagefix.WithContentLength(messagefix.ContentLengthMode(f.choice(3))),
+		messagefix.WithDKIM(messagefix.DKIMMode(f.choice(3))),
+		messagefix.WithHeaderless(messagefix.HeaderlessMode(f.choice(3))),
+	)
+	if f.bool() {
+		opts = append(opts, messagefix.WithStripControls(" "))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithFoldHeaders(78))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithFoldTraceFields(78))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithDefaultContentType("text/plain; charset=us-ascii"))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithAutoSubmitted("auto-generated"), messagefix.WithPrecedence("bulk"))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithMaxDepth(3))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithParallelism(4))
+	}
+	if f.bool() {
+		opts = append(opts, messagefix.WithBufferSize(16, 1024))
+	}
+	return opts
+}
+
+// Fuzz checks the invariants of the Reader for data, whose first OptionBytes bytes select the
+// options, and panics if they are broken.
+func Fuzz(data []byte) int {
+	if len(data) < OptionBytes {
+		return -1
+	}
+	if err := messagefix.CheckInvariants(data[OptionBytes:], Options(data[:OptionBytes])...); err != nil {
+		panic(err)
+	}
+	return 0
+}
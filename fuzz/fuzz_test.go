@@ -0,0 +1,53 @@
+package fuzz
+
+import (
+	"testing"
+
+	"github.com/delthas/go-messagefix"
+)
+
+// seeds are messages exercising the fixes of the Reader, added to the seed corpus of
+// FuzzReader with each of seedFlags.
+var seeds = []string{
+	"",
+	"From: a@example.org\r\nSubject: test\r\n\r\nbody\r\n",
+	"From a@example.org Mon Jan  1 00:00:00 2024\nSubject: mbox\n\n>From the start\n",
+	"Subject: no final line ending\r\n\r\nbody",
+	"body without a header block\r\n",
+	" continuation\r\nSubject: orphan\r\n\r\n",
+	"Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nunclosed\r\n",
+	"Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n--b\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n--b--\r\n",
+	"Content-Type: multipart/mixed\r\n\r\nno boundary\r\n",
+	"Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmhlbGxvDQotLWItLQ0K\r\n",
+	"Content-Type: message/rfc822\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nSubject: inner\r\n\r\n=E9t=\r\n",
+	"Content-Type: text/plain; charset=latin-1\r\nContent-Transfer-Encoding: 7bit\r\n\r\nd\xe9j\xe0 vu\r\n",
+	"Content-Transfer-Encoding: base64\r\n\r\naGVsbG8gd29yb\r\n",
+	"Content-Transfer-Encoding: quoted-printable\r\n\r\nsoft break=\r\ninvalid =ZZ\r\n",
+	"Subject: =?utf-8?q?caf=C3=A9?=\r\nFrom: J\xc3\xb6rg <j\xc3\xb6rg@example.org>\r\nReferences: a@b, <c@d> (comment)\r\n\r\n",
+	"Content-Type: text/plain\r\n\r\nbegin 644 file.txt\r\n#86)C\r\n`\r\nend\r\n",
+	"Received: from a by b; Mon, 1 Jan 2024 00:00:00 +0000\r\nReceived: from a by b; Mon, 1 Jan 2024 00:00:00 +0000\r\n\r\n..dot-stuffed\r\n.\r\n",
+	"\r\n\r\n\r\n",
+	"\x00\x01\x02\xff\xfe\r\n",
+}
+
+// seedFlags are option flags added to the seed corpus of FuzzReader: no options, all options,
+// and alternating options.
+var seedFlags = [][]byte{
+	make([]byte, OptionBytes),
+	{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	{0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55},
+	{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa},
+}
+
+func FuzzReader(f *testing.F) {
+	for _, flags := range seedFlags {
+		for _, seed := range seeds {
+			f.Add(flags, []byte(seed))
+		}
+	}
+	f.Fuzz(func(t *testing.T, flags []byte, input []byte) {
+		if err := messagefix.CheckInvariants(input, Options(flags)...); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -1,3 +1,8 @@
 module github.com/delthas/go-messagefix
 
-go 1.17
+go 1.21
+
+require (
+	github.com/emersion/go-message v0.18.2
+	golang.org/x/text v0.14.0
+)
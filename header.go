@@ -0,0 +1,432 @@
+package messagefix
+
+import (
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// field is a single header field, made of its first line and its continuation lines.
+type field struct {
+	name  string
+	lines []string
+	// size is the size of the lines read from the input.
+	size int
+	// line is the input line number of the field, or 0 if it was synthesized.
+	line int
+	// truncated is set when continuation lines were dropped because the field was too large.
+	truncated bool
+	// input are the lines read from the input along with their line terminators, with
+	// WithPreserveLineEndings.
+	input []inputLine
+}
+
+// maxFieldSize is the maximum size of a field, past which its continuation lines are dropped,
+// so that hostile messages folding a field across many lines cannot make the Reader buffer
+// and unfold it indefinitely.
+const maxFieldSize = 256 << 10
+
+func newField(line string) *field {
+	name := line[:strings.Index(line, ":")]
+	return &field{
+		name:  name,
+		lines: []string{line},
+		size:  len(line),
+	}
+}
+
+// is reports whether the field has the passed name, case-insensitively.
+func (f *field) is(name string) bool {
+	return strings.EqualFold(strings.TrimSpace(f.name), name)
+}
+
+// value returns the unfolded field body.
+func (f *field) value() string {
+	i := strings.Index(f.lines[0], ":")
+	if i < 0 {
+		return ""
+	}
+	n := len(f.lines[0]) - i - 1
+	for _, line := range f.lines[1:] {
+		n += len(line)
+	}
+	var sb strings.Builder
+	sb.Grow(n)
+	sb.WriteString(f.lines[0][i+1:])
+	for _, line := range f.lines[1:] {
+		sb.WriteString(line)
+	}
+	return strings.Trim(sb.String(), " \t")
+}
+
+// setValue replaces the field body, keeping its name.
+func (f *field) setValue(value string) {
+	f.lines = []string{f.name + ": " + value}
+}
+
+// headerKeyExceptions are the canonical forms of header field names that differ from
+// textproto.CanonicalMIMEHeaderKey.
+var headerKeyExceptions = map[string]string{
+	"Message-Id":                 "Message-ID",
+	"Resent-Message-Id":          "Resent-Message-ID",
+	"Original-Message-Id":        "Original-Message-ID",
+	"Content-Id":                 "Content-ID",
+	"Content-Md5":                "Content-MD5",
+	"Mime-Version":               "MIME-Version",
+	"List-Id":                    "List-ID",
+	"Dkim-Signature":             "DKIM-Signature",
+	"Arc-Seal":                   "ARC-Seal",
+	"Arc-Message-Signature":      "ARC-Message-Signature",
+	"Arc-Authentication-Results": "ARC-Authentication-Results",
+	"Bimi-Selector":              "BIMI-Selector",
+	"Bimi-Location":              "BIMI-Location",
+	"Mt-Priority":                "MT-Priority",
+	"Tls-Required":               "TLS-Required",
+	"Tls-Report-Domain":          "TLS-Report-Domain",
+	"Tls-Report-Submitter":       "TLS-Report-Submitter",
+	"Nntp-Posting-Host":          "NNTP-Posting-Host",
+	"Nntp-Posting-Date":          "NNTP-Posting-Date",
+	"X-Msmail-Priority":          "X-MSMail-Priority",
+	"X-Mimeole":                  "X-MimeOLE",
+	"X-Ms-Has-Attach":            "X-MS-Has-Attach",
+	"X-Ms-Tnef-Correlator":       "X-MS-TNEF-Correlator",
+}
+
+// archive1Keys are the names of headerKeyExceptions added in version 2 of the canonical form
+// of ProfileArchive, which keep the form of textproto.CanonicalMIMEHeaderKey in version 1.
+var archive1Keys = map[string]bool{
+	"Original-Message-Id":  true,
+	"Bimi-Selector":        true,
+	"Bimi-Location":        true,
+	"Mt-Priority":          true,
+	"Tls-Required":         true,
+	"Tls-Report-Domain":    true,
+	"Tls-Report-Submitter": true,
+	"Nntp-Posting-Host":    true,
+	"Nntp-Posting-Date":    true,
+	"X-Ms-Has-Attach":      true,
+	"X-Ms-Tnef-Correlator": true,
+}
+
+// canonicalHeaderKey returns the canonical form of a header field name.
+func canonicalHeaderKey(name string) string {
+	key := textproto.CanonicalMIMEHeaderKey(name)
+	if exception, ok := headerKeyExceptions[key]; ok {
+		return exception
+	}
+	return key
+}
+
+// canonicalizeKeys rewrites the header field names to their canonical form, as of version 1
+// of the canonical form of ProfileArchive if archive1 is set. It reports whether the header
+// was fixed.
+func canonicalizeKeys(header []*field, archive1 bool) bool {
+	fixed := false
+	for _, f := range header {
+		name := strings.TrimRight(f.name, " \t")
+		if name == "" {
+			continue
+		}
+		key := canonicalHeaderKey(name)
+		if k := textproto.CanonicalMIMEHeaderKey(name); archive1 && archive1Keys[k] {
+			key = k
+		}
+		if key != name {
+			// fix: use the canonical header field name
+			f.lines[0] = key + f.lines[0][len(name):]
+			f.name = key + f.name[len(name):]
+			fixed = true
+		}
+	}
+	return fixed
+}
+
+// isControl reports whether b is a control character other than a tab.
+func isControl(b byte) bool {
+	return (b < 0x20 && b != '\t') || b == 0x7f
+}
+
+// stripControls replaces the control characters of the header field bodies, except tabs,
+// with replacement. Continuation lines left blank are removed. It reports whether the
+// header was fixed.
+func stripControls(header []*field, replacement string) bool {
+	fixed := false
+	for _, f := range header {
+		lines := f.lines[:0]
+		for i, line := range f.lines {
+			start := 0
+			if i == 0 && !isContinuation(line) {
+				start = strings.Index(line, ":") + 1
+			}
+			j := start
+			for j < len(line) && !isControl(line[j]) {
+				j++
+			}
+			if j == len(line) {
+				lines = append(lines, line)
+				continue
+			}
+			// fix: replace control characters in field bodies
+			fixed = true
+			var sb strings.Builder
+			sb.WriteString(line[:j])
+			for ; j < len(line); j++ {
+				if isControl(line[j]) {
+					sb.WriteString(replacement)
+				} else {
+					sb.WriteByte(line[j])
+				}
+			}
+			if line = sb.String(); i == 0 || strings.TrimLeft(line, " \t") != "" {
+				lines = append(lines, line)
+			}
+		}
+		f.lines = lines
+	}
+	return fixed
+}
+
+// stripComments removes the comments of a structured field body, such as
+// "1.0 (produced by X)". It reports whether the comments are balanced.
+func stripComments(value string) (string, bool) {
+	var sb strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0) && i+1 < len(value):
+			if depth == 0 {
+				sb.WriteByte(c)
+				sb.WriteByte(value[i+1])
+			}
+			i++
+			continue
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+			continue
+		case c == ')' && !quoted:
+			if depth == 0 {
+				return sb.String(), false
+			}
+			depth--
+			if depth == 0 {
+				sb.WriteByte(' ')
+			}
+			continue
+		}
+		if depth == 0 {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), depth == 0 && !quoted
+}
+
+// commentLen returns the length of the comment starting s, including its parentheses, or -1
+// if it is not closed.
+func commentLen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// stripParamComments removes the comments of a structured field body taking parameters,
+// such as "multipart/mixed (generated by X); boundary=x", keeping quoted strings and the
+// parentheses that do not follow whitespace or a semicolon, such as in the unquoted
+// parameter value "report(1).pdf". It reports whether comments were removed; the value is
+// returned as is if a comment is not closed.
+func stripParamComments(value string) (string, bool) {
+	var sb strings.Builder
+	quoted := false
+	removed, after := false, false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(value):
+			sb.WriteString(value[i : i+2])
+			i++
+			continue
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '(' && (i == 0 || after || strings.IndexByte(" \t;", value[i-1]) >= 0):
+			n := commentLen(value[i:])
+			if n < 0 {
+				return value, false
+			}
+			removed, after = true, true
+			i += n - 1
+			continue
+		case !quoted && c == ';':
+			// drop the whitespace left by a comment before the parameter
+			s := strings.TrimRight(sb.String(), " \t")
+			sb.Reset()
+			sb.WriteString(s)
+		}
+		after = false
+		sb.WriteByte(c)
+	}
+	if !removed {
+		return value, false
+	}
+	return strings.TrimSpace(sb.String()), true
+}
+
+// setParam sets a parameter of the field, in place if it is already present.
+func setParam(f *field, key, value string) {
+	for i, line := range f.lines {
+		if start, end := findParam(line, key); start >= 0 {
+			f.lines[i] = line[:start] + value + line[end:]
+			return
+		}
+	}
+	f.lines[len(f.lines)-1] += "; " + key + "=" + value
+}
+
+// isField reports whether line starts a header field: a valid field name, possibly followed
+// by whitespace, then a colon.
+func isField(line string) bool {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return false
+	}
+	name := strings.TrimRight(line[:i], " \t")
+	return name != "" && strings.IndexFunc(name, notFieldNameChar) < 0
+}
+
+// knownFields are the names of well-known header fields, in lower case, whose missing colon
+// is inserted with WithInsertColons.
+var knownFields = map[string]bool{
+	"date": true, "from": true, "sender": true, "reply-to": true, "to": true, "cc": true,
+	"bcc": true, "message-id": true, "in-reply-to": true, "references": true, "subject": true,
+	"comments": true, "keywords": true, "resent-date": true, "resent-from": true,
+	"resent-sender": true, "resent-to": true, "resent-cc": true, "resent-bcc": true,
+	"resent-message-id": true, "return-path": true, "received": true, "mime-version": true,
+	"content-type": true, "content-transfer-encoding": true, "content-disposition": true,
+	"content-id": true, "content-description": true, "content-language": true,
+	"organization": true, "user-agent": true, "x-mailer": true, "importance": true,
+	"priority": true, "x-priority": true, "list-id": true, "list-unsubscribe": true,
+	"thread-topic": true, "thread-index": true, "errors-to": true,
+	"disposition-notification-to": true, "x-originating-ip": true,
+}
+
+// insertColon returns a header line without a colon whose first word is the name of a
+// well-known field, with a colon inserted after that name.
+func insertColon(line string) (string, bool) {
+	i := strings.IndexAny(line, " \t")
+	if i <= 0 || !knownFields[strings.ToLower(line[:i])] {
+		return "", false
+	}
+	return line[:i] + ":" + line[i:], true
+}
+
+func isContinuation(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// lookup returns the first field of the header with the passed name.
+func lookup(header []*field, name string) *field {
+	for _, f := range header {
+		if f.is(name) {
+			return f
+		}
+	}
+	return nil
+}
+
+func parseContentType(content string) (mediaType string, params map[string]string) {
+	params = make(map[string]string)
+	content, _ = stripParamComments(content)
+	for _, part := range strings.Split(content, ";") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		parts := strings.SplitN(part, "=", 2)
+		if len(parts) == 1 {
+			if mediaType == "" {
+				mediaType = strings.ToLower(parts[0])
+			}
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if key == "boundary" {
+			value = strings.Trim(value, "\"")
+		} else if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		params[key] = value
+	}
+	return
+}
+
+// part is the MIME information of the part whose header block was last read.
+type part struct {
+	mediaType string
+	params    map[string]string
+	encoding  string
+	// boundary is the multipart boundary declared by the part, if any.
+	boundary string
+	// embedded is set when the part body starts with a header block.
+	embedded bool
+	// opaque is set when the part is a container nested too deeply, whose body is not parsed.
+	opaque bool
+	// signed is set when the body is signed by a DKIM signature kept valid with DKIMSafe,
+	// and is output as is.
+	signed bool
+	// decoded is set when the body of the container is decoded by a containerDecoder.
+	decoded bool
+	// held is set while the header block is held back by the body lineWriter, which
+	// must call releaseHeader before writing any line.
+	held bool
+	// written is set once a body line was read.
+	written bool
+
+	body lineWriter
+}
+
+// newPart parses the MIME information of a header block. If strict is set, the Content-Type
+// field is parsed with mime.ParseMediaType when it is valid.
+func newPart(header []*field, strict bool) part {
+	var p part
+	if f := lookup(header, "Content-Type"); f != nil {
+		var err error
+		if strict {
+			p.mediaType, p.params, err = mime.ParseMediaType(f.value())
+		}
+		if !strict || err != nil {
+			p.mediaType, p.params = parseContentType(f.value())
+		}
+	}
+	if f := lookup(header, "Content-Transfer-Encoding"); f != nil {
+		encoding, _ := stripParamComments(f.value())
+		p.encoding = strings.ToLower(encoding)
+	}
+	switch p.mediaType {
+	case "message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers":
+		p.embedded = true
+	}
+	if strings.HasPrefix(p.mediaType, "multipart/") {
+		p.boundary = p.params["boundary"]
+	}
+	return p
+}
+
+// isContainer reports whether the part contains other entities rather than leaf content.
+func (p *part) isContainer() bool {
+	return p.embedded || strings.HasPrefix(p.mediaType, "multipart/")
+}
@@ -0,0 +1,73 @@
+package messagefix
+
+import (
+	"io"
+)
+
+// SplitHeader returns a reader of the fixed message header, up to and including the empty
+// line ending it, and a reader of the fixed message body that follows, which are consumed
+// in order. This maps onto the BODY[HEADER] and BODY[TEXT] sections of IMAP FETCH without
+// buffering the fixed message to split it again.
+//
+// The header reader returns io.EOF once the empty line was read; reading the body first
+// discards the rest of the header. A message without an empty line ending its header has an
+// empty body. The Reader must not be read directly once SplitHeader was called.
+func (r *Reader) SplitHeader() (header, body io.Reader) {
+	h := &headerReader{r: r}
+	return h, &bodyReader{h: h}
+}
+
+// headerReader reads the fixed message header line by line, until the empty line ending it.
+type headerReader struct {
+	r *Reader
+	// line is the rest of the last line read, and done is set once the whole header was read.
+	line []byte
+	done bool
+	err  error
+}
+
+func (h *headerReader) Read(p []byte) (int, error) {
+	if len(h.line) == 0 {
+		if h.done {
+			return 0, io.EOF
+		}
+		if h.err != nil {
+			return 0, h.err
+		}
+		line, err := h.r.NextLine()
+		if err != nil {
+			if err == io.EOF {
+				h.done = true
+			}
+			h.err = err
+			return 0, err
+		}
+		h.line = line
+		h.done = string(line) == "\r\n" || string(line) == "\n"
+	}
+	n := copy(p, h.line)
+	h.line = h.line[n:]
+	return n, nil
+}
+
+// bodyReader reads the fixed message body, once the header was read.
+type bodyReader struct {
+	h *headerReader
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	h := b.h
+	for !h.done || len(h.line) > 0 {
+		if len(h.line) > 0 {
+			h.line = nil
+			continue
+		}
+		if _, err := h.Read(nil); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	if h.err != nil {
+		return 0, h.err
+	}
+	return h.r.Read(p)
+}
@@ -0,0 +1,193 @@
+package messagefix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// InvariantError is an invariant of the Reader broken for some input, as reported by
+// CheckInvariants.
+type InvariantError struct {
+	// Invariant is a short description of the invariant.
+	Invariant string
+	// Detail describes how the invariant was broken.
+	Detail string
+}
+
+func (err *InvariantError) Error() string {
+	return "messagefix: broken invariant: " + err.Invariant + ": " + err.Detail
+}
+
+// maxReadsWithoutOutput is the count of successive Read calls returning no output past which
+// a Reader is deemed stuck.
+const maxReadsWithoutOutput = 1000
+
+// CheckInvariants fixes input with the passed options, and checks that the Reader upholds
+// its invariants, returning an *InvariantError otherwise:
+//   - it does not panic, and terminates with an output size bounded by the input size;
+//   - it only returns errors matching ErrUnfixable, a *LimitError or a *FixError as errors;
+//   - its output is made of CRLF-terminated lines, unless WithBinary, WithDotStuffing,
+//     WithLFOutput or WithPreserveLineEndings is passed, whose output may contain arbitrary
+//     bytes;
+//   - its output is the same whether read with Read or NextChunk, whatever the size of the
+//     buffers passed to Read, down to a single byte, and whether its input is read in large
+//     chunks or a single byte at a time;
+//   - its output size is the size returned by FixSized and Summary.
+//
+// CheckInvariants is meant for fuzzing, such as with the targets of the fuzz package, for
+// input of any size and options.
+func CheckInvariants(input []byte, opts ...Option) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &InvariantError{
+				Invariant: "no panic",
+				Detail:    fmt.Sprint(v),
+			}
+		}
+	}()
+
+	// the messages synthesized by each Reader have the same Date field
+	now := time.Now()
+	opts = append([]Option{WithClock(func() time.Time { return now })}, opts...)
+
+	r := NewReader(bytes.NewReader(input), opts...)
+	output, err := readBounded(r, len(input))
+	if isKnownError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !r.opts.binary && !r.opts.dotStuffing && !r.opts.lfOutput && !r.opts.preserveLineEnds {
+		if i := invalidLineEnd(output); i >= 0 {
+			return &InvariantError{
+				Invariant: "CRLF-terminated lines",
+				Detail:    fmt.Sprintf("invalid line terminator at offset %v", i),
+			}
+		}
+	}
+
+	chunked := NewReader(bytes.NewReader(input), opts...)
+	var chunks []byte
+	for max := 1; ; max = max%97 + 1 {
+		chunk, err := chunked.NextChunk(max)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return &InvariantError{
+				Invariant: "same output with NextChunk",
+				Detail:    "unexpected error: " + err.Error(),
+			}
+		}
+		chunks = append(chunks, chunk...)
+	}
+	if !bytes.Equal(chunks, output) {
+		return &InvariantError{
+			Invariant: "same output with NextChunk",
+			Detail:    fmt.Sprintf("output of %v bytes instead of %v", len(chunks), len(output)),
+		}
+	}
+
+	tiny := NewReader(bytes.NewReader(input), append(append([]Option(nil), opts...), WithReadSize(1))...)
+	var tinyOutput []byte
+	buf := make([]byte, 7)
+	for size := 1; ; size = size%len(buf) + 1 {
+		n, err := tiny.Read(buf[:size])
+		tinyOutput = append(tinyOutput, buf[:n]...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return &InvariantError{
+				Invariant: "same output with small reads",
+				Detail:    "unexpected error: " + err.Error(),
+			}
+		}
+	}
+	if !bytes.Equal(tinyOutput, output) {
+		return &InvariantError{
+			Invariant: "same output with small reads",
+			Detail:    fmt.Sprintf("output of %v bytes instead of %v", len(tinyOutput), len(output)),
+		}
+	}
+
+	_, size, err := FixSized(bytes.NewReader(input), opts...)
+	if err != nil || size != int64(len(output)) {
+		return &InvariantError{
+			Invariant: "size of FixSized",
+			Detail:    fmt.Sprintf("size of %v bytes (error: %v) instead of %v", size, err, len(output)),
+		}
+	}
+	if s := r.Summary(); s == nil || (!r.opts.dotStuffing && !r.opts.lfOutput && s.Size != int64(len(output))) {
+		return &InvariantError{
+			Invariant: "size of Summary",
+			Detail:    fmt.Sprintf("summary %+v for an output of %v bytes", s, len(output)),
+		}
+	}
+	return nil
+}
+
+// readBounded reads the whole output of r, checking that it terminates and that its output
+// size is bounded.
+func readBounded(r *Reader, inputSize int) ([]byte, error) {
+	// the worst case is reencoding 8-bit windows-1252 text to quoted-printable UTF-8
+	max := 16*inputSize + 4096
+	var output []byte
+	buf := make([]byte, 4096)
+	stuck := 0
+	for {
+		n, err := r.Read(buf)
+		output = append(output, buf[:n]...)
+		if err == io.EOF {
+			return output, nil
+		} else if err != nil {
+			if isKnownError(err) {
+				return nil, err
+			}
+			return nil, &InvariantError{
+				Invariant: "known errors",
+				Detail:    "unexpected error: " + err.Error(),
+			}
+		}
+		if len(output) > max {
+			return nil, &InvariantError{
+				Invariant: "bounded output",
+				Detail:    fmt.Sprintf("output of more than %v bytes for an input of %v bytes", max, inputSize),
+			}
+		}
+		if n == 0 {
+			stuck++
+			if stuck > maxReadsWithoutOutput {
+				return nil, &InvariantError{
+					Invariant: "termination",
+					Detail:    fmt.Sprintf("%v successive reads without output", stuck),
+				}
+			}
+		} else {
+			stuck = 0
+		}
+	}
+}
+
+// invalidLineEnd returns the offset of the first bare LF of b, or of its end if it does not
+// end with a CRLF, or -1. Bare CRs are line content, which the Reader passes through.
+func invalidLineEnd(b []byte) int {
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			return i
+		}
+	}
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\r\n")) {
+		return len(b)
+	}
+	return -1
+}
+
+// isKnownError reports whether err is an error that the Reader returns on some input.
+func isKnownError(err error) bool {
+	var limitErr *LimitError
+	var fixErr *FixError
+	return errors.Is(err, ErrUnfixable) || errors.As(err, &limitErr) || errors.As(err, &fixErr)
+}
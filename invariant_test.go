@@ -0,0 +1,94 @@
+package messagefix
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+var invariantTests = []struct {
+	name  string
+	input string
+	opts  []Option
+}{
+	{
+		name:  "unclosed multipart",
+		input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nunclosed\r\n",
+	},
+	{
+		name:  "unclosed nested multiparts",
+		input: "Content-Type: multipart/mixed; boundary=a\r\n\r\n--a\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n--b\r\n\r\ninner",
+	},
+	{
+		name:  "unclosed multipart without a final line ending",
+		input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbody",
+	},
+	{
+		name:  "no final line ending",
+		input: "Subject: test\r\n\r\nbody",
+	},
+	{
+		name:  "header without a final line ending",
+		input: "Subject: test",
+	},
+	{
+		name:  "final bare CR",
+		input: "Subject: test\r\n\r\nbody\r",
+	},
+	{
+		name:  "LF line endings",
+		input: "Subject: test\n\nbody\nwithout a final line ending",
+	},
+	{
+		name:  "dot-stuffing",
+		input: "Subject: test\r\n\r\n.\r\n..\r\n.line\r\nend",
+		opts:  []Option{WithDotStuffing(true)},
+	},
+	{
+		name:  "dot-unstuffing",
+		input: "Subject: test\r\n\r\n..line\r\n...\r\n.\r\n",
+		opts:  []Option{WithDotUnstuffing(true)},
+	},
+	{
+		name:  "dot-stuffing of an unclosed multipart",
+		input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\n.\r\n..",
+		opts:  []Option{WithDotStuffing(true)},
+	},
+	{
+		name:  "parallelism",
+		input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8gd29yb\r\n--b\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ninvalid =ZZ\r\n--b\r\n\r\nunclosed",
+		opts:  []Option{WithParallelism(4)},
+	},
+	{
+		name:  "parallelism with decoded multiparts",
+		input: "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iDQoNCmhlbGxvDQotLWItLQ0K\r\n",
+		opts:  []Option{WithParallelism(4), WithDecodeMultiparts(true)},
+	},
+}
+
+func TestCheckInvariants(t *testing.T) {
+	for _, tc := range invariantTests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := CheckInvariants([]byte(tc.input), tc.opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			opts := append([]Option{WithClock(func() time.Time { return now })}, tc.opts...)
+			want, err := io.ReadAll(NewReader(bytes.NewReader([]byte(tc.input)), opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewReader(iotest.OneByteReader(bytes.NewReader([]byte(tc.input))), append(opts, WithReadSize(1))...)
+			got, err := io.ReadAll(iotest.OneByteReader(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output with 1-byte reads:\n%q\nwant:\n%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package messagefix
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// EmailBodyPart is the JMAP EmailBodyPart of an entity (RFC 8621), as returned by
+// Part.EmailBodyPart.
+//
+// An EmailBodyPart can be marshaled to JSON with encoding/json. Header fields and blob IDs,
+// which depend on the server, are left out.
+type EmailBodyPart struct {
+	// PartID is the IMAP part specifier of a leaf entity, such as "2.1", or "1" for the body
+	// of a message that is not a multipart. It is empty for multiparts.
+	PartID string `json:"partId,omitempty"`
+	// Size is the size in bytes of the decoded body of a leaf entity.
+	Size int64 `json:"size"`
+	// Name is the decoded file name of the entity, from its Content-Disposition or
+	// Content-Type field.
+	Name string `json:"name,omitempty"`
+	// Type is the lowercase media type of the entity, text/plain if it has no valid one.
+	Type string `json:"type"`
+	// Charset is the charset parameter of a text entity, us-ascii if it has none.
+	Charset string `json:"charset,omitempty"`
+	// Disposition is the lowercase disposition of the entity, without its parameters.
+	Disposition string   `json:"disposition,omitempty"`
+	CID         string   `json:"cid,omitempty"`
+	Language    []string `json:"language,omitempty"`
+	Location    string   `json:"location,omitempty"`
+	// SubParts are the parts of a multipart entity. Messages embedded in message/rfc822
+	// parts are not part of the tree, since they are leaf parts in JMAP.
+	SubParts []*EmailBodyPart `json:"subParts,omitempty"`
+}
+
+// EmailBodyPart returns the JMAP EmailBodyPart tree of the entity (RFC 8621), from the fixed
+// message, as the bodyStructure property of a JMAP Email.
+//
+// As with BodyStructure, only the header blocks of the entities are read from message, as
+// well as the bodies of base64 and quoted-printable leaf parts, whose decoded size is counted.
+func (p *Part) EmailBodyPart(message io.ReaderAt) (*EmailBodyPart, error) {
+	w := imapWriter{message: message}
+	h, err := w.header(p)
+	if err != nil {
+		return nil, err
+	}
+	bp := &EmailBodyPart{Type: p.MediaType}
+	if !strings.Contains(bp.Type, "/") {
+		bp.Type = "text/plain"
+	}
+	_, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		_, params = parseContentType(h.Get("Content-Type"))
+	}
+	if strings.HasPrefix(bp.Type, "multipart/") && p.Boundary != "" {
+		for _, c := range p.Children {
+			sub, err := c.EmailBodyPart(message)
+			if err != nil {
+				return nil, err
+			}
+			bp.SubParts = append(bp.SubParts, sub)
+		}
+		return bp, nil
+	}
+
+	bp.PartID = p.Path
+	if bp.PartID == "" {
+		bp.PartID = "1"
+	}
+	if strings.HasPrefix(bp.Type, "text/") {
+		bp.Charset = strings.ToLower(params["charset"])
+		if bp.Charset == "" {
+			bp.Charset = "us-ascii"
+		}
+	}
+	var dparams map[string]string
+	if v := h.Get("Content-Disposition"); v != "" {
+		var err error
+		if bp.Disposition, dparams, err = mime.ParseMediaType(v); err != nil {
+			bp.Disposition, dparams = parseContentType(v)
+		}
+	}
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	dec := mime.WordDecoder{CharsetReader: charsetReader}
+	if decoded, err := dec.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	bp.Name = name
+	bp.CID = strings.Trim(strings.TrimSpace(h.Get("Content-ID")), "<>")
+	for _, l := range strings.Split(h.Get("Content-Language"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			bp.Language = append(bp.Language, l)
+		}
+	}
+	bp.Location = strings.TrimSpace(h.Get("Content-Location"))
+	encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding")))
+	if bp.Size, err = decodedSize(message, p, encoding); err != nil {
+		return nil, err
+	}
+	return bp, nil
+}
+
+// charsetReader decodes the text of encoded-words in the charsets supported by the Reader.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	e := lookupCharset(charset)
+	if e == nil {
+		return nil, fmt.Errorf("messagefix: unknown charset %q", charset)
+	}
+	return e.NewDecoder().Reader(input), nil
+}
+
+// decodedSize returns the size of the decoded body of p. Invalid encoded content is counted
+// up to the first error.
+func decodedSize(message io.ReaderAt, p *Part, encoding string) (int64, error) {
+	body := &readError{r: io.NewSectionReader(message, p.Offset+p.HeaderSize, p.BodySize)}
+	var r io.Reader
+	switch encoding {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(body)
+	default:
+		return p.BodySize, nil
+	}
+	// the errors of the decoders are invalid encoded content
+	n, _ := io.Copy(io.Discard, r)
+	return n, body.err
+}
+
+// readError records the first error other than io.EOF returned by r.
+type readError struct {
+	r   io.Reader
+	err error
+}
+
+func (e *readError) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
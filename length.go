@@ -0,0 +1,107 @@
+package messagefix
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// ContentLengthMode is the behavior of a Reader on Content-Length fields, common in messages
+// of mbox stores, which are stale once the message is fixed.
+type ContentLengthMode int
+
+const (
+	// ContentLengthKeep outputs Content-Length fields as is. This is the default.
+	ContentLengthKeep ContentLengthMode = iota
+	// ContentLengthRemove removes the Content-Length fields of all header blocks.
+	ContentLengthRemove
+	// ContentLengthRecompute rewrites the Content-Length field of the message header to the
+	// size of the fixed message body, removing the other Content-Length fields. The whole
+	// fixed message is buffered when the message header has a Content-Length field, since
+	// its size is only known once the message was fixed.
+	ContentLengthRecompute
+)
+
+// WithContentLength sets the behavior of the Reader on Content-Length fields.
+//
+// With ContentLengthRecompute, the size is the count of bytes of the fixed body, after the
+// empty line ending the message header, before any dot-stuffing of WithDotStuffing.
+// WithParallelism has no effect on messages whose size is recomputed.
+func WithContentLength(mode ContentLengthMode) Option {
+	return func(o *options) {
+		o.contentLength = mode
+	}
+}
+
+// fixContentLength removes the Content-Length fields of the header block, except the first
+// one of the message header with ContentLengthRecompute, which is recomputed once the whole
+// message was fixed.
+func (r *Reader) fixContentLength(h *Header) {
+	header := r.header[:0]
+	for _, f := range r.header {
+		if !f.is("Content-Length") {
+			header = append(header, f)
+			continue
+		}
+		if r.opts.contentLength == ContentLengthRecompute && h.IsMessage() && r.length == nil {
+			r.length = f
+			r.lengthValue = f.value()
+			if len(f.lines) > 1 {
+				// fix: unfold the Content-Length field, so that it can be rewritten in place
+				r.fixed(FixContentLength)
+				f.setValue(r.lengthValue)
+			}
+			header = append(header, f)
+			continue
+		}
+		// fix: remove a stale Content-Length field
+		r.fixed(FixContentLength)
+	}
+	r.header = header
+}
+
+// holdsLength reports whether the output is held back until the size of the message body is
+// known.
+func (r *Reader) holdsLength() bool {
+	return r.length != nil
+}
+
+// recomputeLength rewrites the Content-Length field of the message header, once the whole
+// message was fixed, and shifts the offsets of the parts that follow it.
+func (r *Reader) recomputeLength() {
+	if r.length == nil {
+		return
+	}
+	f := r.length
+	r.length = nil
+	if !r.lengthOutput {
+		// the field was removed by a later fixer
+		return
+	}
+	message := r.parts[0]
+	n := strconv.FormatInt(r.resolve(r.offset())-r.resolve(message.bodyStart), 10)
+	if n == r.lengthValue {
+		return
+	}
+	// fix: recompute the Content-Length field
+	r.fixed(FixContentLength)
+	r.messageHeader.Set(f.name, n)
+	at := int(r.lengthAt - r.base)
+	end := len(r.buffer)
+	if i := bytes.IndexByte(r.buffer[at:], '\n'); i >= 0 {
+		end = at + i
+	}
+	if end > at && r.buffer[end-1] == '\r' {
+		end--
+	}
+	line := strings.TrimRight(f.name, " \t") + ": " + n
+	r.buffer = append(r.buffer[:at], append([]byte(line), r.buffer[end:]...)...)
+	delta := int64(len(line) - (end - at))
+	for _, e := range r.parts {
+		for _, m := range []*mark{&e.start, &e.bodyStart, &e.end} {
+			if m.inline > r.lengthAt {
+				m.inline += delta
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+func TestContentLength(t *testing.T) {
+	recompute := []Option{WithContentLength(ContentLengthRecompute)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "removed",
+			input: "Subject: test\r\nContent-Length: 100\r\n\r\nbody\r\n",
+			opts:  []Option{WithContentLength(ContentLengthRemove)},
+			want:  "Subject: test\r\n\r\nbody\r\n",
+			fixes: []FixID{FixContentLength},
+		},
+		{
+			name:  "removed from parts",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Length: 4\r\n\r\nbody\r\n--b--\r\n",
+			opts:  []Option{WithContentLength(ContentLengthRemove)},
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbody\r\n--b--\r\n",
+			fixes: []FixID{FixContentLength},
+		},
+		{
+			name:  "recomputed",
+			input: "Subject: test\r\nContent-Length: 100\r\n\r\nbody\n",
+			opts:  recompute,
+			want:  "Subject: test\r\nContent-Length: 6\r\n\r\nbody\r\n",
+			fixes: []FixID{FixContentLength},
+		},
+		{
+			name:  "recomputed after fixes",
+			input: "Content-Length: 23\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nunclosed\r\n",
+			opts:  recompute,
+			want:  "Content-Length: 24\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nunclosed\r\n--b--\r\n",
+			fixes: []FixID{FixCloseMultiparts, FixContentLength},
+		},
+		{
+			name:  "duplicate fields",
+			input: "Content-Length: 6\r\nContent-Length: 100\r\n\r\nbody\r\n",
+			opts:  recompute,
+			want:  "Content-Length: 6\r\n\r\nbody\r\n",
+			fixes: []FixID{FixContentLength},
+		},
+		{
+			name:  "folded field",
+			input: "Content-Length:\r\n 6\r\n\r\nbody\r\n",
+			opts:  recompute,
+			want:  "Content-Length: 6\r\n\r\nbody\r\n",
+			fixes: []FixID{FixContentLength},
+		},
+		{
+			name:  "correct",
+			input: "Subject: test\r\nContent-Length: 6\r\n\r\nbody\r\n",
+			opts:  recompute,
+			want:  "Subject: test\r\nContent-Length: 6\r\n\r\nbody\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "kept",
+			input: "Subject: test\r\nContent-Length: 100\r\n\r\nbody\r\n",
+			want:  "Subject: test\r\nContent-Length: 100\r\n\r\nbody\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
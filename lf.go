@@ -0,0 +1,45 @@
+package messagefix
+
+import (
+	"io"
+)
+
+// lfHead returns the next bytes of the output with LF line endings.
+func (r *Reader) lfHead() ([]byte, error) {
+	for len(r.lf) == 0 {
+		b, err := r.crlfHead()
+		if err == io.EOF && r.lfCR {
+			// a CR ending the output is not part of a line terminator
+			r.lfCR = false
+			r.lf = append(r.lf[:0], '\r')
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.lf, r.lfCR = toLF(r.lf[:0], b, r.lfCR)
+		r.crlfAdvance(len(b))
+	}
+	return r.lf, nil
+}
+
+// toLF appends b to dst, replacing its CRLF line terminators with LF. cr is set when the byte
+// preceding b is a CR that was not appended yet; toLF returns whether the last byte of b is
+// such a CR.
+func toLF(dst, b []byte, cr bool) ([]byte, bool) {
+	for _, c := range b {
+		if cr {
+			cr = false
+			if c == '\n' {
+				dst = append(dst, '\n')
+				continue
+			}
+			dst = append(dst, '\r')
+		}
+		if c == '\r' {
+			cr = true
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return dst, cr
+}
@@ -0,0 +1,163 @@
+package messagefix
+
+import (
+	"errors"
+	"io"
+	"strconv"
+)
+
+var (
+	// ErrHeaderTooLarge matches the *LimitError returned when a header block exceeds
+	// Limits.HeaderSize or Limits.HeaderFields, with errors.Is.
+	ErrHeaderTooLarge = errors.New("messagefix: header block too large")
+	// ErrMessageTooLarge matches the *LimitError returned when the message exceeds
+	// Limits.MessageSize, with errors.Is.
+	ErrMessageTooLarge = errors.New("messagefix: message too large")
+	// ErrTooDeeplyNested matches the *LimitError returned when the entities of the message
+	// are nested deeper than Limits.Depth, with errors.Is.
+	ErrTooDeeplyNested = errors.New("messagefix: entities too deeply nested")
+)
+
+// Limits are the maximum sizes of the input accepted by a Reader, to bound the resources
+// spent on hostile input. Zero fields are not limited.
+type Limits struct {
+	// HeaderSize is the maximum size in bytes of each header block, excluding line
+	// terminators.
+	HeaderSize int
+	// HeaderFields is the maximum count of fields of each header block.
+	HeaderFields int
+	// MessageSize is the maximum size in bytes of the input message.
+	MessageSize int64
+	// Depth is the maximum nesting depth of the entities of the message, as defined by
+	// WithMaxDepth, past which the Reader returns an error instead of processing the body
+	// of containers as opaque content.
+	Depth int
+}
+
+// WithLimits sets the maximum sizes of the input, past which the Reader returns a
+// *LimitError. By default, the input is not limited, besides its line length set by
+// WithBufferSize and the size of each header field, whose continuation lines are dropped past
+// 256KiB.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithMaxDepth sets the maximum nesting depth of the entities of a message, where the parts of
+// the message and the message embedded in a message/rfc822 part are one level deeper than
+// their enclosing entity. The body of multiparts and message/rfc822 parts at the maximum depth
+// is processed as opaque content: their boundary and embedded message are not parsed, and
+// the Reader reports a FindingMaxDepth. This bounds the boundaries tracked by the Reader on
+// hostile messages declaring thousands of nested multiparts.
+//
+// A depth of 0, the default, does not limit the nesting depth.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// LimitKind is the kind of limit of a LimitError.
+type LimitKind int
+
+const (
+	// LimitHeaderSize is the limit of Limits.HeaderSize.
+	LimitHeaderSize LimitKind = iota
+	// LimitHeaderFields is the limit of Limits.HeaderFields.
+	LimitHeaderFields
+	// LimitMessageSize is the limit of Limits.MessageSize.
+	LimitMessageSize
+	// LimitDepth is the limit of Limits.Depth.
+	LimitDepth
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitHeaderSize:
+		return "header size"
+	case LimitHeaderFields:
+		return "header fields"
+	case LimitMessageSize:
+		return "message size"
+	case LimitDepth:
+		return "depth"
+	default:
+		return "unknown"
+	}
+}
+
+// LimitError is returned by Reader when the input exceeds a limit set with WithLimits. It
+// matches ErrHeaderTooLarge, ErrMessageTooLarge or ErrTooDeeplyNested, depending on its kind,
+// with errors.Is.
+type LimitError struct {
+	Kind LimitKind
+	// Max is the value of the exceeded limit.
+	Max int64
+	// Line is the 1-based input line number where the limit was exceeded, or 0 for
+	// LimitMessageSize.
+	Line int
+}
+
+func (e *LimitError) Error() string {
+	s := "messagefix: " + e.Kind.String() + " limit of " + strconv.FormatInt(e.Max, 10) + " exceeded"
+	if e.Line > 0 {
+		s += " at line " + strconv.Itoa(e.Line)
+	}
+	return s
+}
+
+// Is reports whether target is the sentinel error of the kind of limit.
+func (e *LimitError) Is(target error) bool {
+	switch e.Kind {
+	case LimitHeaderSize, LimitHeaderFields:
+		return target == ErrHeaderTooLarge
+	case LimitMessageSize:
+		return target == ErrMessageTooLarge
+	case LimitDepth:
+		return target == ErrTooDeeplyNested
+	}
+	return false
+}
+
+// checkDepth checks the nesting depth of a container at the depth of the current entity.
+func (r *Reader) checkDepth() {
+	if l := r.opts.limits; l.Depth > 0 && len(r.entities)-1 >= l.Depth {
+		r.limitErr = &LimitError{Kind: LimitDepth, Max: int64(l.Depth), Line: r.line}
+	}
+}
+
+// checkHeaderLimits checks the limits of the header block being read, after line was read.
+func (r *Reader) checkHeaderLimits(line string) {
+	l := r.opts.limits
+	r.headerSize += len(line)
+	switch {
+	case l.HeaderSize > 0 && r.headerSize > l.HeaderSize:
+		r.limitErr = &LimitError{Kind: LimitHeaderSize, Max: int64(l.HeaderSize), Line: r.line}
+	case l.HeaderFields > 0 && len(r.header) > l.HeaderFields:
+		r.limitErr = &LimitError{Kind: LimitHeaderFields, Max: int64(l.HeaderFields), Line: r.line}
+	}
+}
+
+// sizeLimiter is an io.Reader returning a *LimitError once more than max bytes are read
+// from r.
+type sizeLimiter struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	if l.n > l.max {
+		return 0, &LimitError{Kind: LimitMessageSize, Max: l.max}
+	}
+	if int64(len(p)) > l.max-l.n+1 {
+		p = p[:l.max-l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n - int(l.n-l.max), &LimitError{Kind: LimitMessageSize, Max: l.max}
+	}
+	return n, err
+}
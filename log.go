@@ -0,0 +1,30 @@
+package messagefix
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger enables logging each fix applied by the Reader to logger, at the info level,
+// with the fix ID, the part path and the input line number as attributes, as reported by
+// WithOnFix. Attributes identifying the message, such as its file name or Message-ID, can
+// be added to the logger with slog.Logger.With.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// logFixes returns a function logging fixes to logger, then calling next if it is not nil.
+func logFixes(logger *slog.Logger, next func(Fix)) func(Fix) {
+	return func(f Fix) {
+		logger.LogAttrs(context.Background(), slog.LevelInfo, "applied fix",
+			slog.String("fix", string(f.ID)),
+			slog.String("path", f.Path),
+			slog.Int("line", f.Line),
+		)
+		if next != nil {
+			next(f)
+		}
+	}
+}
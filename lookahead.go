@@ -0,0 +1,117 @@
+package messagefix
+
+// LookaheadFixer is a Fixer whose fixes of the header blocks of leaf parts depend on the first
+// lines of their body, such as fixes telling whether a body starts with header fields that
+// belong to the header block.
+//
+// When a LookaheadFixer is passed with WithFixers, the Reader holds the header block of each
+// leaf part back, along with the first lines of its body, before calling FixLookahead.
+type LookaheadFixer interface {
+	Fixer
+	// FixLookahead fixes a header block, after the FixHeader calls of the pipeline, once the
+	// first lines of its body were read: up to 64 lines and 1MiB, fewer if the part ends
+	// before. The lines are passed as read, without their line terminator, and are replaced
+	// by the returned lines.
+	//
+	// The body of the part is then processed according to the fixed header block, such as
+	// with the body fixes of its encoding. A part rewritten as a multipart or as a message
+	// is still processed as a leaf part.
+	FixLookahead(h *Header, lines []string) []string
+}
+
+// LookaheadFixerFunc is a LookaheadFixer that only fixes header blocks from the first lines
+// of their body.
+type LookaheadFixerFunc func(h *Header, lines []string) []string
+
+// FixHeader does nothing.
+func (f LookaheadFixerFunc) FixHeader(h *Header) {}
+
+// FixBody returns nil.
+func (f LookaheadFixerFunc) FixBody(h *Header) func(line string) string {
+	return nil
+}
+
+// FixLookahead calls f(h, lines).
+func (f LookaheadFixerFunc) FixLookahead(h *Header, lines []string) []string {
+	return f(h, lines)
+}
+
+// maxLookahead is the maximum count of body lines held back for a LookaheadFixer.
+const maxLookahead = 64
+
+// looksAhead reports whether the header block of the current part is held back along with
+// the first lines of its body, for the LookaheadFixer of the pipeline or for sniffing its
+// media type.
+func (r *Reader) looksAhead() bool {
+	if r.part.isContainer() || r.part.signed || r.part.mediaType == "message/partial" {
+		return false
+	}
+	if r.sniffs() {
+		return true
+	}
+	for _, f := range r.opts.fixers {
+		if _, ok := f.(LookaheadFixer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookahead holds the header block and the first lines of a part body back, until its media
+// type was sniffed and the LookaheadFixer of the pipeline fixed the header block, then
+// processes the part body with the lineWriter chain of the fixed header block.
+type lookahead struct {
+	r     *Reader
+	lines []string
+	size  int
+}
+
+func (l *lookahead) writeLine(line string) {
+	l.lines = append(l.lines, line)
+	l.size += len(line)
+	if len(l.lines) >= maxLookahead || l.size > maxHeldBody {
+		l.release()
+	}
+}
+
+func (l *lookahead) end(delimiter bool) {
+	l.release()
+	l.r.part.body.end(delimiter)
+}
+
+// release fixes the header block from the held lines, then processes them with the
+// lineWriter chain of the part, which replaces l.
+func (l *lookahead) release() {
+	r := l.r
+	h := &Header{r: r}
+	lines := l.lines
+	l.lines = nil
+	binary := r.sniffs() && r.sniff(lines)
+	for _, f := range r.opts.fixers {
+		if f, ok := f.(LookaheadFixer); ok {
+			lines = f.FixLookahead(h, lines)
+		}
+	}
+	written := r.part.written
+	sniffed := r.part
+	r.part = newPart(r.header, r.opts.strict)
+	r.part.written = written
+	if r.part.isContainer() {
+		// the body was already processed as a leaf part
+		r.part.boundary = ""
+		r.part.embedded = false
+		r.part.opaque = true
+	}
+	r.part.body = r.body(&r.buffer)
+	if binary {
+		// the body is encoded as base64 before the body fixes of its declared encoding
+		r.part.body = newBase64Encoder(&sniffed, r.part.body)
+	}
+	r.entities[len(r.entities)-1].mediaType = r.part.mediaType
+	if !r.part.held {
+		r.releaseHeader()
+	}
+	for _, line := range lines {
+		r.part.body.writeLine(line)
+	}
+}
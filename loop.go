@@ -0,0 +1,74 @@
+package messagefix
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mailLoopHops is the count of identical Received fields above which a mail loop is assumed.
+const mailLoopHops = 3
+
+// hopKey returns the identity of a Received hop, without its date, which differs for each
+// pass through a loop.
+func hopKey(value string) string {
+	if i := strings.LastIndexByte(value, ';'); i >= 0 {
+		value = value[:i]
+	}
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// detectMailLoop reports mail loops in the trace fields of the top-level header block,
+// and removes the repeated trace fields if truncate is set.
+func (r *Reader) detectMailLoop(truncate bool) {
+	hops := make(map[string]int)
+	deliveries := make(map[string]int)
+	loop := false
+	for _, f := range r.header {
+		switch {
+		case f.is("Received"):
+			key := hopKey(f.value())
+			hops[key]++
+			if hops[key] == mailLoopHops {
+				loop = true
+				r.find(FindingMailLoop, f.line, "Received hop repeated "+strconv.Itoa(mailLoopHops)+" times: "+key)
+			}
+		case f.is("Delivered-To"):
+			key := strings.ToLower(f.value())
+			deliveries[key]++
+			if deliveries[key] == 2 {
+				loop = true
+				r.find(FindingMailLoop, f.line, "Delivered-To repeated: "+key)
+			}
+		}
+	}
+	if !loop || !truncate {
+		return
+	}
+	// trace fields are prepended at each hop: keep the oldest of each repeated field
+	seen := make(map[string]bool)
+	keep := make([]bool, len(r.header))
+	for i := len(r.header) - 1; i >= 0; i-- {
+		f := r.header[i]
+		var key string
+		switch {
+		case f.is("Received"):
+			key = "received:" + hopKey(f.value())
+		case f.is("Delivered-To"):
+			key = "delivered-to:" + strings.ToLower(f.value())
+		default:
+			keep[i] = true
+			continue
+		}
+		keep[i] = !seen[key]
+		seen[key] = true
+	}
+	// fix: remove trace fields repeated by a mail loop
+	r.fixed(FixMailLoops)
+	header := r.header[:0]
+	for i, f := range r.header {
+		if keep[i] {
+			header = append(header, f)
+		}
+	}
+	r.header = header
+}
@@ -0,0 +1,141 @@
+package messagefix
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaildirReport is the result of FixMaildir.
+type MaildirReport struct {
+	// Messages are the messages of the Maildir, in the order they were processed.
+	Messages []MaildirMessage
+}
+
+// MaildirMessage is the result of fixing a single message of a Maildir.
+type MaildirMessage struct {
+	// Path is the path of the message file, relative to the Maildir.
+	Path string
+	// Changed is set if fixing the message changed its content.
+	Changed bool
+	// Report is the report of the Reader that fixed the message.
+	Report Report
+	// Err is set if the message could not be fixed, in which case it was left untouched, or,
+	// along with Changed, if the replacement of the message could not be synced to disk.
+	Err error
+}
+
+// FixMaildir fixes all messages of a Maildir in place, in its cur and new directories.
+//
+// Each message is fixed to a temporary file in the tmp directory of the Maildir, which is
+// synced to disk then replaces the original file atomically, along with a sync of its
+// directory, so that a crash leaves either the original or the fixed message; messages that
+// are unchanged are left as is.
+// The permissions and modification time of the files are kept. The message sizes that
+// some servers store in the file names (",S=" fields) are not updated.
+//
+// An error is returned only if the Maildir itself cannot be read; errors for single
+// messages are reported in MaildirReport.
+func FixMaildir(dir string, opts ...Option) (MaildirReport, error) {
+	var report MaildirReport
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return report, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(sub, entry.Name())
+			m := MaildirMessage{
+				Path: path,
+			}
+			m.Changed, m.Report, m.Err = fixFile(filepath.Join(dir, path), filepath.Join(dir, "tmp"), opts)
+			report.Messages = append(report.Messages, m)
+		}
+	}
+	return report, nil
+}
+
+// fixFile fixes a message file in place, using a temporary file in tmpDir.
+func fixFile(path string, tmpDir string, opts []Option) (changed bool, report Report, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, report, err
+	}
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return false, report, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "messagefix-*")
+	if err != nil {
+		return false, report, err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	r := NewReader(bytes.NewReader(original), opts...)
+	w := &diffWriter{w: tmp, original: original}
+	if _, err := io.Copy(w, r); err != nil {
+		return false, r.Report(), err
+	}
+	report = r.Report()
+	if !w.changed && w.n == len(original) {
+		return false, report, nil
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return false, report, err
+	}
+	// the content must be on disk before it replaces the original file
+	if err := tmp.Sync(); err != nil {
+		return false, report, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, report, err
+	}
+	name := tmp.Name()
+	tmp = nil
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(name)
+		return false, report, err
+	}
+	if err := os.Rename(name, path); err != nil {
+		os.Remove(name)
+		return false, report, err
+	}
+	return true, report, syncDir(filepath.Dir(path))
+}
+
+// syncDir commits the entries of the directory at path to disk, such as a rename.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// diffWriter is an io.Writer recording whether the data written differs from original.
+type diffWriter struct {
+	w        io.Writer
+	original []byte
+	n        int
+	changed  bool
+}
+
+func (d *diffWriter) Write(p []byte) (int, error) {
+	if !d.changed {
+		if d.n+len(p) > len(d.original) || !bytes.Equal(p, d.original[d.n:d.n+len(p)]) {
+			d.changed = true
+		}
+	}
+	d.n += len(p)
+	return d.w.Write(p)
+}
@@ -0,0 +1,52 @@
+package messagefix
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixMaildir(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"cur/broken:2,S": "Subject: test\n\nbody\n",
+		"new/valid":      "Subject: test\r\n\r\nbody\r\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := FixMaildir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := map[string]bool{}
+	for _, m := range report.Messages {
+		if m.Err != nil {
+			t.Errorf("message %v: %v", m.Path, m.Err)
+		}
+		changed[filepath.ToSlash(m.Path)] = m.Changed
+	}
+	if !changed["cur/broken:2,S"] || changed["new/valid"] || len(changed) != 2 {
+		t.Errorf("changed messages: %v", changed)
+	}
+	for name := range files {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "Subject: test\r\n\r\nbody\r\n"; got != want {
+			t.Errorf("message %v: %q, want %q", name, got, want)
+		}
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, "tmp")); err != nil || len(entries) != 0 {
+		t.Errorf("tmp directory: %v entries (error: %v)", len(entries), err)
+	}
+}
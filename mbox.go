@@ -0,0 +1,135 @@
+package messagefix
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// MboxReader splits an mbox stream into messages, and returns a Reader fixing each of them.
+//
+// Messages are separated by From_ lines, that is lines starting with "From " at the start
+// of the stream or following an empty line. The From_ lines and the empty line preceding
+// each of them are removed from the messages. The mbox quoting of lines starting with From
+// is not removed unless WithUnescapeFrom is passed.
+//
+// MboxReader does not close its input io.Reader.
+type MboxReader struct {
+	br   *bufio.Reader
+	opts []Option
+	cur  *mboxMessage
+	// line is a line that was read but not processed yet.
+	line string
+	eof  bool
+}
+
+// NewMboxReader returns an MboxReader reading the passed mbox stream. The options are
+// used for the Reader of each message.
+func NewMboxReader(r io.Reader, opts ...Option) *MboxReader {
+	return &MboxReader{
+		br:   bufio.NewReader(r),
+		opts: opts,
+	}
+}
+
+// Next returns a Reader fixing the next message of the mbox stream.
+//
+// Any remaining content of the message returned by the previous call to Next is skipped.
+// Next returns io.EOF once there are no more messages.
+func (m *MboxReader) Next() (*Reader, error) {
+	if m.cur != nil {
+		if _, err := io.Copy(io.Discard, m.cur); err != nil {
+			return nil, err
+		}
+		m.cur = nil
+	}
+	if m.line == "" {
+		if err := m.readLine(); err != nil {
+			return nil, err
+		}
+		if m.line == "" {
+			return nil, io.EOF
+		}
+	}
+	if isFromLine(m.line) {
+		m.line = ""
+	}
+	m.cur = &mboxMessage{m: m}
+	return NewReader(m.cur, m.opts...), nil
+}
+
+// readLine reads the next line, including its line terminator, to m.line.
+func (m *MboxReader) readLine() error {
+	if m.eof {
+		m.line = ""
+		return nil
+	}
+	line, err := m.br.ReadString('\n')
+	if err == io.EOF {
+		m.eof = true
+	} else if err != nil {
+		return err
+	}
+	m.line = line
+	return nil
+}
+
+func isFromLine(line string) bool {
+	return strings.HasPrefix(line, "From ")
+}
+
+func isEmptyLine(line string) bool {
+	return line == "\n" || line == "\r\n"
+}
+
+// mboxMessage is the io.Reader of a single message of an mbox stream.
+type mboxMessage struct {
+	m    *MboxReader
+	buf  string
+	done bool
+	// blank is an empty line held back, since it is removed if it precedes a From_ line.
+	blank string
+}
+
+func (mm *mboxMessage) Read(p []byte) (int, error) {
+	for mm.buf == "" {
+		if mm.done {
+			return 0, io.EOF
+		}
+		if err := mm.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, mm.buf)
+	mm.buf = mm.buf[n:]
+	return n, nil
+}
+
+// next reads the next line of the message to mm.buf.
+func (mm *mboxMessage) next() error {
+	m := mm.m
+	if m.line == "" {
+		if err := m.readLine(); err != nil {
+			return err
+		}
+		if m.line == "" {
+			mm.done = true
+			return nil
+		}
+	}
+	line := m.line
+	if mm.blank != "" && isFromLine(line) {
+		mm.blank = ""
+		mm.done = true
+		return nil
+	}
+	m.line = ""
+	mm.buf = mm.blank
+	mm.blank = ""
+	if isEmptyLine(line) {
+		mm.blank = line
+	} else {
+		mm.buf += line
+	}
+	return nil
+}
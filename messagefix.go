@@ -5,16 +5,56 @@ package messagefix
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
+	"net/textproto"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// ErrUnfixable is matched by the errors returned by Reader when it gives up on a message that
+// it cannot fix: ErrEmptyMessage, ErrLineTooLong, and the error returned with ProfileStdlib
+// and the profiles extending it when the fixed header block of the message still cannot be
+// parsed with net/mail.ReadMessage, for example because the fixes it needs are disallowed,
+// or because its fields contain control characters and WithStripControls is not passed.
+var ErrUnfixable = errors.New("messagefix: message cannot be fixed")
+
+// unfixableError is an error matching ErrUnfixable.
+type unfixableError struct {
+	msg string
+	err error
+}
+
+func (e *unfixableError) Error() string {
+	return e.msg
+}
+
+func (e *unfixableError) Is(target error) bool {
+	return target == ErrUnfixable
+}
+
+func (e *unfixableError) Unwrap() error {
+	return e.err
+}
+
+// ErrEmptyMessage is returned by Reader on empty or whitespace-only input, when enabled
+// with WithEmptyMode(EmptyError). It matches ErrUnfixable.
+var ErrEmptyMessage error = &unfixableError{msg: "messagefix: empty message"}
+
+// ErrLineTooLong is returned by Reader when an input line is longer than the maximum size of
+// its buffer, set with WithBufferSize. It wraps bufio.ErrTooLong, and matches ErrUnfixable.
+var ErrLineTooLong error = &unfixableError{msg: "messagefix: line too long: " + bufio.ErrTooLong.Error(), err: bufio.ErrTooLong}
+
+// ErrClosed is returned by Reader once it is closed.
+var ErrClosed = errors.New("messagefix: read after Close")
+
 type state int
 
 const (
 	stateHeader state = iota
 	stateBody
-	stateContentType
 )
 
 // Reader is an io.Reader that transforms an RFC822 message BODY[] (ie, an EML file content)
@@ -23,143 +63,1015 @@ const (
 // Reader has several best-effort heuristics to fix broken RFC822 messages slightly so that they
 // adhere to the specification. These heuristics are not best-effort and not guaranteed.
 //
-// Reader may slightly buffer its input io.Reader.
-// Reader does not close its input io.Reader.
+// Reader buffers each header block until its end, and may slightly buffer its input io.Reader.
+// Reader does not close its input io.Reader, unless enabled with WithCloseInput.
 type Reader struct {
-	sc     *bufio.Scanner
+	// src is the input io.Reader.
+	src io.Reader
+	sc  *bufio.Scanner
+	// scanBuf is the initial buffer of sc, which is kept across Reset calls.
+	scanBuf []byte
+	// baseOpts are the options passed to NewReader, and opts the options of the current
+	// message, which include the options returned by the policy of WithPolicy.
+	baseOpts options
+	opts     options
+	onFix    func(Fix)
+	fixers   []Fixer
+	// buffer is the output produced since the last segment was queued.
 	buffer []byte
+	// spare is the start of the buffer, which is reused once it is consumed.
+	spare []byte
+	err   error
+	// base is the count of bytes of output produced by the Reader itself, rather than by
+	// jobs, that are no longer in buffer.
+	base int64
+	// queue is the output produced before buffer that was not returned yet, if any.
+	queue []*segment
+	// rest is output that was returned to the Reader by NextChunk.
+	rest  []byte
+	chunk []byte
+	// stuffed is the dot-stuffed output not returned yet.
+	stuffed []byte
+	stuffer stuffer
+	// dotEnd is set once the line ending dot-stuffed input was read.
+	dotEnd bool
+	// current is the input line being processed and end its line terminator, with
+	// WithPreserveLineEndings. unterminated is the rest of the CRLF of the last output line,
+	// when it is the last input line and has no line terminator.
+	current      string
+	end          string
+	unterminated string
+	// chunked is set when the last token of the input scanner is a chunk of lines passed
+	// through, rather than a single line.
+	chunked bool
+	// lf is the output with LF line endings not returned yet, and lfCR is set when the last
+	// output byte converted is a CR not added to it yet.
+	lf   []byte
+	lfCR bool
+	// jobs are all the jobs started, of which active are those that may still be running.
+	jobs   []*job
+	active []*job
 
-	boundaries []string
+	// delimiters are the delimiter lines of the open boundaries, that is the boundaries
+	// prefixed with "--".
+	delimiters []string
+	// containers are the depths of the entities declaring each boundary.
+	containers []int
+	// split is an input line held back since it may start a delimiter line split across
+	// two lines.
+	split string
+	// consumed is the tail of the output consumed so far.
+	consumed []byte
+	// dkim compares the input and output messages with their DKIM signatures, with WithDKIM,
+	// until the whole output was read.
+	dkim *dkimChecker
+	// signer signs the output with WithSigner; signErr is the first error of its Write
+	// calls, and signature the signature once the whole output was read.
+	signer    Signer
+	signErr   error
+	signature []byte
+	// unsigned is the output buffered until its signature is prepended.
+	unsigned []byte
+	// length is the Content-Length field of the message header recomputed with
+	// ContentLengthRecompute, whose value was lengthValue, until the whole message was fixed.
+	// lengthAt is the output offset of the field, once lengthOutput is set.
+	length       *field
+	lengthValue  string
+	lengthAt     int64
+	lengthOutput bool
+	// decoders are the decoders of the bodies of containers declared as base64 being
+	// decoded, outermost first.
+	decoders []*containerDecoder
 
 	state state
 
-	bodyIsHeader bool
-	contentType  string
+	header []*field
+	part   part
+	// headerSize is the size of the lines of the header block read so far, and limitErr
+	// is set once the input exceeds a limit of WithLimits, or once the Reader gives up.
+	headerSize int
+	limitErr   error
+
+	// line is the count of input lines read, and ended is set once the end of the input
+	// was reached.
+	line     int
+	ended    bool
+	findings []Finding
+	// fromLine is set when a leading From_ line was stripped.
+	fromLine bool
+
+	// empty is set while only whitespace has been read, whose lines are kept in leading.
+	empty   bool
+	leading []string
+
+	entities []*entity
+	parts    []*entity
+	summary  *Summary
+	stats    stats
+	// messageHeader is the fixed message header, once it was output.
+	messageHeader textproto.MIMEHeader
 }
 
 // NewReader returns a Reader that transforms the passed stream.
 //
 // Reader does all the buffering it needs, so there is no need to specifically pass a bufio.Reader.
-func NewReader(r io.Reader) *Reader {
-	return &Reader{
-		sc: bufio.NewScanner(r),
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	fr := &Reader{}
+	for _, opt := range opts {
+		opt(&fr.baseOpts)
+	}
+	fr.opts = fr.baseOpts
+	fr.configure()
+	fr.init(r)
+	return fr
+}
+
+// configure sets up the Reader for its options.
+func (r *Reader) configure() {
+	r.opts.applyAllowed()
+	if r.opts.downgradeUTF8 {
+		// downgraded messages keep no raw UTF-8 in their header blocks
+		r.opts.smtputf8 = false
+	}
+	r.onFix = r.opts.onFix
+	if r.opts.logger != nil {
+		r.onFix = logFixes(r.opts.logger, r.onFix)
+	}
+	if r.opts.onLossyFix != nil {
+		r.onFix = lossyFixes(r.opts.onLossyFix, r.onFix)
+	}
+	r.fixers = r.pipeline()
+	r.stats.reject = r.opts.reject
+}
+
+// Reset discards the state of the Reader, including any output not returned yet, and makes
+// it transform the message read from r, with the same options.
+//
+// This enables reusing a Reader for many messages, for example with a sync.Pool, without
+// allocating its buffers again.
+//
+// Reset does not close the previous input io.Reader, even with WithCloseInput.
+func (r *Reader) Reset(src io.Reader) {
+	r.discardJobs()
+	opts, onFix, fixers := r.opts, r.onFix, r.fixers
+	if r.baseOpts.policy != nil {
+		// the options of the message depend on its header
+		opts, onFix, fixers = r.baseOpts, nil, nil
+	}
+	*r = Reader{
+		scanBuf:  r.scanBuf,
+		baseOpts: r.baseOpts,
+		opts:     opts,
+		onFix:    onFix,
+		fixers:   fixers,
+		spare:    r.spare,
+		chunk:    r.chunk[:0],
+		stuffed:  r.stuffed[:0],
+		lf:       r.lf[:0],
+		header:   r.header[:0],
+		entities: r.entities[:0],
+	}
+	if r.fixers == nil {
+		r.configure()
+	}
+	r.init(src)
+}
+
+// init sets up the Reader to read from src.
+func (r *Reader) init(src io.Reader) {
+	r.src = src
+	r.empty = true
+	if r.opts.dkim != DKIMIgnore {
+		r.dkim = &dkimChecker{}
+	}
+	if r.opts.newSigner != nil {
+		r.signer, r.err = r.opts.newSigner()
+	}
+	r.stats.reject = r.opts.reject
+	src = countingReader{r: src, stats: &r.stats}
+	if r.opts.limits.MessageSize > 0 {
+		src = &sizeLimiter{r: src, max: r.opts.limits.MessageSize}
+	}
+	if r.opts.readSize > 0 {
+		src = &limitedReader{r: src, n: r.opts.readSize}
+	}
+	r.sc = bufio.NewScanner(src)
+	initial, max := r.opts.bufferSize, r.opts.maxBufferSize
+	if initial <= 0 {
+		initial = 4096
+	}
+	if max <= 0 {
+		max = bufio.MaxScanTokenSize
 	}
+	if cap(r.scanBuf) != initial {
+		r.scanBuf = make([]byte, 0, initial)
+	}
+	r.sc.Buffer(r.scanBuf, max)
+	r.sc.Split(r.scanLines)
+	r.openEntity("")
+}
+
+// limitedReader is an io.Reader limiting the size of each Read call on r to n.
+type limitedReader struct {
+	r io.Reader
+	n int
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	return l.r.Read(p)
 }
 
 // Reader follows the general convention of the io.Reader Read method.
 //
+// The output of the Reader does not depend on the size of the buffers passed to Read, nor on
+// how its input io.Reader returns its data: buffers as small as a single byte receive the same
+// output, split across calls. Read returns at least one byte unless it returns an error,
+// including io.EOF at the end of the message.
+//
 // See Reader for details.
 func (r *Reader) Read(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
-	if r.sc == nil {
-		return 0, io.EOF
+	b, err := r.head()
+	if err != nil {
+		return 0, err
 	}
-	if len(r.buffer) == 0 {
-		line, err := r.read()
-		if err != nil {
-			r.sc = nil
-			return 0, err
-		}
-		r.buffer = []byte(line + "\r\n")
-	}
-	n = copy(p, r.buffer)
-	r.buffer = r.buffer[n:]
+	n = copy(p, b)
+	r.advance(n)
+	r.stats.written(n)
 	return n, nil
 }
 
-func parseContentType(content string) (header bool, boundary string) {
-	for _, part := range strings.Split(content, ";") {
-		part = strings.TrimSpace(part)
-		if len(part) == 0 {
+// head returns the next bytes of output, reading more input as needed.
+func (r *Reader) head() ([]byte, error) {
+	if len(r.rest) > 0 {
+		return r.rest, nil
+	}
+	if r.opts.lfOutput {
+		return r.lfHead()
+	}
+	return r.crlfHead()
+}
+
+// crlfHead returns the next bytes of output with CRLF line endings.
+func (r *Reader) crlfHead() ([]byte, error) {
+	if r.opts.dotStuffing {
+		return r.stuffedHead()
+	}
+	return r.signedHead()
+}
+
+// advance consumes n bytes of the output returned by head.
+func (r *Reader) advance(n int) {
+	switch {
+	case len(r.rest) > 0:
+		r.rest = r.rest[n:]
+	case r.opts.lfOutput:
+		r.lf = r.lf[n:]
+	default:
+		r.crlfAdvance(n)
+	}
+}
+
+// crlfAdvance consumes n bytes of the output returned by crlfHead.
+func (r *Reader) crlfAdvance(n int) {
+	switch {
+	case r.opts.dotStuffing:
+		r.stuffed = r.stuffed[n:]
+	default:
+		r.skip(n)
+	}
+}
+
+// produce returns the next bytes of the fixed message, reading more input as needed.
+func (r *Reader) produce() ([]byte, error) {
+	for {
+		if len(r.queue) > 0 {
+			b, err := r.queueHead()
+			if len(b) > 0 || err != nil {
+				return b, err
+			}
 			continue
 		}
-		parts := strings.SplitN(part, "=", 2)
-		if len(parts) == 1 {
-			switch parts[0] {
-			case "message/rfc822", "text/rfc822-headers":
-				header = true
-			default:
-				contentParts := strings.SplitN(parts[0], "/", 2)
-				switch contentParts[0] {
-				case "multipart":
-					header = true
+		if len(r.buffer) > 0 && !r.holdsLength() {
+			return r.buffer, nil
+		}
+		if r.err != nil {
+			if r.err == io.EOF && r.dkim != nil {
+				r.checkSignatures()
+			}
+			if r.err == io.EOF && r.signer != nil && r.signature == nil {
+				if err := r.sign(); err != nil {
+					r.err = err
 				}
 			}
-			continue
+			return nil, r.err
 		}
-		if parts[0] == "boundary" {
-			boundary = strings.Trim(parts[1], "\"")
+		if r.holdsLength() {
+			// the whole output is held until the Content-Length field is recomputed
+			r.err = r.read()
 			continue
 		}
+		// reuse the buffer from its start, since consume advances it
+		r.buffer = r.spare[:0]
+		r.err = r.read()
+		r.spare = r.buffer
+	}
+}
+
+// consume consumes n bytes of the output returned by produce.
+func (r *Reader) consume(n int) {
+	var b []byte
+	switch {
+	case len(r.queue) > 0:
+		b = r.queue[0].data[:n]
+		r.queue[0].data = r.queue[0].data[n:]
+	default:
+		b = r.buffer[:n]
+		r.buffer = r.buffer[n:]
+		r.base += int64(n)
+	}
+	r.consumed = appendTail(r.consumed, b)
+	if r.dkim != nil {
+		r.dkim.output(b)
+	}
+	if r.signer != nil && r.signErr == nil {
+		_, r.signErr = r.signer.Write(b)
+	}
+}
+
+// maxTail is the count of the last bytes of the output tracked by the Reader, to tell whether
+// the output ends with an empty line.
+const maxTail = 4
+
+// appendTail returns the last bytes of tail followed by b, at most maxTail of them, reusing
+// tail.
+func appendTail(tail, b []byte) []byte {
+	if len(b) >= maxTail {
+		return append(tail[:0], b[len(b)-maxTail:]...)
+	}
+	tail = append(tail, b...)
+	if n := len(tail); n > maxTail {
+		copy(tail, tail[n-maxTail:])
+		tail = tail[:maxTail]
+	}
+	return tail
+}
+
+// tail returns the last bytes of the output produced so far, at most maxTail of them. All
+// jobs must be done.
+func (r *Reader) tail() []byte {
+	t := appendTail(nil, r.buffer)
+	for i := len(r.queue) - 1; i >= 0 && len(t) < maxTail; i-- {
+		s := r.queue[i]
+		data := s.data
+		if s.job != nil && !s.loaded {
+			data = s.job.tail
+		}
+		t = appendTail(appendTail(nil, data), t)
+	}
+	if len(t) < maxTail {
+		t = appendTail(append([]byte(nil), r.consumed...), t)
+	}
+	return t
+}
+
+// terminate ends the output with a CRLF line terminator, and with an empty line if enabled.
+func (r *Reader) terminate() {
+	if r.opts.preserveLineEnds {
+		r.terminatePreserved()
+		return
+	}
+	t := r.tail()
+	if len(t) > 0 && !bytes.HasSuffix(t, []byte("\r\n")) {
+		// the last line was decoded without a line terminator
+		r.buffer = append(r.buffer, "\r\n"...)
+		t = appendTail(t, []byte("\r\n"))
+	}
+	if !r.opts.finalEmptyLine || bytes.HasSuffix(t, []byte("\r\n\r\n")) || string(t) == "\r\n" {
+		return
 	}
-	return
+	// fix: end the message with an empty line
+	r.fixed(FixFinalEmptyLine)
+	r.emit("")
 }
 
-func (r *Reader) read() (string, error) {
-	if !r.sc.Scan() {
+// emit appends a line to the output, with a CRLF terminator, or its input line terminator
+// with WithPreserveLineEndings.
+func (r *Reader) emit(line string) {
+	r.startLine()
+	r.buffer = append(r.buffer, line...)
+	r.endLine(r.terminator(line))
+}
+
+// read consumes a single input line, appending any resulting output to the buffer.
+func (r *Reader) read() error {
+	if err := r.rejection(); err != nil {
+		r.stopJob()
+		return err
+	}
+	if r.opts.ctx != nil {
+		if err := r.opts.ctx.Err(); err != nil {
+			r.stopJob()
+			return err
+		}
+	}
+	if !r.scan() {
+		// the lines output from now on are not input lines
+		r.end = "\r\n"
 		if err := r.sc.Err(); err != nil {
-			return "", err
+			r.stopJob()
+			if err == bufio.ErrTooLong {
+				err = ErrLineTooLong
+			}
+			return err
 		}
-		// fix: close any remaining open multiparts
-		if len(r.boundaries) > 0 {
-			if r.state == stateHeader {
-				r.state = stateBody
-				return "", nil
+		r.ended = true
+		if r.dkim != nil {
+			r.dkim.parse()
+		}
+		if r.empty && r.opts.emptyMode != EmptyPassThrough {
+			if r.opts.emptyMode == EmptyError {
+				return ErrEmptyMessage
 			}
-			line := "--" + r.boundaries[len(r.boundaries)-1] + "--"
-			r.boundaries = r.boundaries[:len(r.boundaries)-1]
-			return line, nil
+			r.fixed(FixSynthesizeEmpty)
+			r.synthesize()
+		}
+		if r.split != "" {
+			r.readLine(r.split)
+			r.split = ""
+		}
+		if len(r.decoders) > 0 {
+			r.endDecoding(0)
 		}
-		return "", io.EOF
+		r.finish()
+		if err := r.rejection(); err != nil {
+			return err
+		}
+		if r.limitErr != nil {
+			return r.limitErr
+		}
+		return io.EOF
 	}
-	line := r.sc.Text()
-	for i, boundary := range r.boundaries {
-		if line == ("--" + boundary + "--") {
-			r.boundaries = r.boundaries[:i]
-			r.state = stateHeader
-			return line, nil
+	b := r.sc.Bytes()
+	if r.chunked {
+		r.passChunk(b)
+		return nil
+	}
+	r.line++
+	if r.opts.dotUnstuffing && len(b) > 0 && b[0] == '.' {
+		b = b[1:]
+	}
+	if r.dkim != nil && (r.line > 1 || !bytes.HasPrefix(b, []byte("From "))) {
+		r.dkim.input(b, r.line)
+	}
+	if r.passesThrough(b) {
+		// copy the line to the output without allocating it
+		r.empty = r.empty && len(bytes.Trim(b, " \t")) == 0
+		r.part.written = true
+		r.buffer = append(r.buffer, b...)
+		if r.opts.preserveLineEnds {
+			r.endLine(r.end)
+		} else {
+			r.buffer = append(r.buffer, "\r\n"...)
+		}
+		return nil
+	}
+	line := string(b)
+	r.current = line
+	if r.line == 1 && (r.opts.stripFromLine || r.opts.detectFromLine) && strings.HasPrefix(line, "From ") {
+		// fix: strip the mbox From_ line
+		r.fixed(FixFromLine)
+		r.fromLine = true
+		return nil
+	}
+	if r.empty && r.opts.emptyMode != EmptyPassThrough {
+		if strings.Trim(line, " \t") == "" {
+			r.leading = append(r.leading, line)
+			return nil
 		}
-		if line == ("--" + boundary) {
-			r.boundaries = r.boundaries[:i+1]
+		for _, l := range r.leading {
+			r.readLine(l)
+		}
+		r.leading = nil
+	}
+	r.empty = r.empty && strings.Trim(line, " \t") == ""
+	r.readInput(line)
+	if r.limitErr != nil {
+		r.stopJob()
+		return r.limitErr
+	}
+	return nil
+}
+
+// passesThrough reports whether an input line is output as is, without being processed line
+// by line: it is the case of the body lines of parts without body fixes, that cannot be
+// delimiter lines.
+func (r *Reader) passesThrough(b []byte) bool {
+	if r.empty && r.opts.emptyMode != EmptyPassThrough {
+		return false
+	}
+	if len(b) >= 2 && b[0] == '-' && b[1] == '-' {
+		return false
+	}
+	return r.passesBody()
+}
+
+// passesBody reports whether the lines of the current part body that cannot be delimiter
+// lines are output as is.
+func (r *Reader) passesBody() bool {
+	if r.state != stateBody || len(r.decoders) > 0 || r.split != "" {
+		return false
+	}
+	switch w := r.part.body.(type) {
+	case nil:
+		return true
+	case output:
+		return w.out == &r.buffer
+	default:
+		return false
+	}
+}
+
+// synthesizedFrom is the originator of messages synthesized by the Reader.
+const synthesizedFrom = "unknown@unknown.invalid"
+
+// synthesize processes a minimal valid message, made of the required header fields and
+// an empty body, in place of an empty input.
+func (r *Reader) synthesize() {
+	r.readLine("Date: " + r.opts.now().Format(time.RFC1123Z))
+	r.readLine("From: " + synthesizedFrom)
+	r.readLine("")
+}
+
+// readLine processes a single input line, without its line terminator.
+func (r *Reader) readLine(line string) {
+	if r.opts.renameBoundaries {
+		line = r.renameDelimiter(line)
+	}
+	if i, closing, ok := r.matchDelimiter(line); ok {
+		if i == len(r.delimiters)-1 {
+			switch c := r.part.body.(type) {
+			case *containerChecker:
+				c.parted = true
+			case *boundaryChecker:
+				c.parted = true
+			}
+		}
+		r.endPart(true)
+		if r.opts.strict && r.opts.allows(FixNestedMultiparts) && len(r.delimiters) > i+1 {
+			// fix: close the multiparts nested in the ended part
+			r.fixed(FixNestedMultiparts)
+			r.closeDelimiters(i + 1)
+		}
+		r.closeEntities(r.containers[i]+1, true)
+		if r.opts.strict && r.opts.allows(FixDelimiterLines) {
+			delimiter := r.delimiters[i]
+			if closing {
+				delimiter += "--"
+			}
+			if line != delimiter {
+				// fix: remove any content after the delimiter
+				r.fixed(FixDelimiterLines)
+				line = delimiter
+			}
+		}
+		r.emit(line)
+		if closing {
+			r.delimiters = r.delimiters[:i]
+			r.containers = r.containers[:i]
+			// the epilogue is opaque
+			r.state = stateBody
+		} else {
+			r.delimiters = r.delimiters[:i+1]
+			r.containers = r.containers[:i+1]
+			r.openChild(r.containers[i])
 			r.state = stateHeader
-			return line, nil
 		}
+		r.part = part{}
+		return
+	}
+	switch r.state {
+	case stateHeader:
+		r.readHeader(line)
+	case stateBody:
+		r.readBody(line)
+	}
+}
+
+// matchDelimiter returns the index of the open boundary whose delimiter line is line, and
+// whether it is a close delimiter.
+func (r *Reader) matchDelimiter(line string) (i int, closing bool, ok bool) {
+	if len(line) < 2 || line[0] != '-' || line[1] != '-' {
+		return 0, false, false
+	}
+	for i, delimiter := range r.delimiters {
+		if closing, ok := r.matchesDelimiter(line, delimiter); ok {
+			return i, closing, true
+		}
+	}
+	return 0, false, false
+}
+
+// matchesDelimiter returns whether line is an open or close delimiter line of delimiter.
+func (r *Reader) matchesDelimiter(line, delimiter string) (closing bool, ok bool) {
+	if len(line) < len(delimiter) || line[:len(delimiter)] != delimiter {
+		return false, false
+	}
+	if r.opts.strict {
+		return matchLenientDelimiter(line, delimiter)
 	}
-	if r.state == stateContentType {
-		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
-			r.contentType += strings.Trim(line, " \t")
-			return line, nil
+	switch line[len(delimiter):] {
+	case "--":
+		return true, true
+	case "":
+		return false, true
+	}
+	return false, false
+}
+
+func (r *Reader) readHeader(line string) {
+	if line == "" {
+		r.endHeader()
+		return
+	}
+	if r.opts.blankLines != BlankLinesKeep && strings.TrimLeft(line, " \t") == "" {
+		// fix: end the header block at, or remove, a whitespace-only line
+		r.fixed(FixBlankHeaderLines)
+		if r.opts.blankLines == BlankLinesSeparate {
+			r.endHeader()
 		}
-		var boundary string
-		r.bodyIsHeader, boundary = parseContentType(r.contentType)
-		if boundary != "" {
-			r.boundaries = append(r.boundaries, boundary)
+		return
+	}
+	defer r.checkHeaderLimits(line)
+	if r.opts.orphans != OrphanKeep && len(r.header) == 0 && isContinuation(line) && strings.TrimSpace(line) != "" {
+		// fix: unindent, promote or drop a continuation line starting a header block
+		r.fixed(FixOrphanContinuations)
+		line = strings.TrimLeft(line, " \t")
+		switch {
+		case r.opts.orphans == OrphanDrop:
+			return
+		case r.opts.orphans == OrphanPromote || !isField(line):
+			line = "X-Broken-Header: " + line
 		}
-		r.state = stateHeader
-		r.contentType = ""
+		f := newField(line)
+		f.line = r.line
+		r.recordEnd(f, line)
+		r.header = append(r.header, f)
+		return
 	}
-	if r.state == stateHeader {
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) == 2 && strings.ToLower(parts[0]) == "content-type" {
-			r.contentType = strings.Trim(parts[1], " \t")
-			r.state = stateContentType
-			return line, nil
-		}
-		if line == "" {
-			if !r.bodyIsHeader {
-				r.state = stateBody
+	if r.opts.insertColons && !isContinuation(line) && !strings.Contains(line, ":") {
+		if fixed, ok := insertColon(line); ok {
+			// fix: insert the missing colon of a field
+			r.fixed(FixMissingColons)
+			line = fixed
+		}
+	}
+	if len(r.entities) == 1 && len(r.header) == 0 && !isField(line) && !strings.HasPrefix(line, "From ") {
+		r.find(FindingHeaderless, r.line, "message without a header block")
+		if r.opts.headerless != HeaderlessIgnore {
+			// fix: process the message as a body without a header block
+			r.fixed(FixHeaderless)
+			if r.opts.headerless == HeaderlessSynthesize {
+				r.synthesize()
+			} else {
+				r.endHeader()
 			}
-			r.bodyIsHeader = false
-			return line, nil
+			r.readBody(line)
+			return
 		}
-		if !strings.Contains(line, ":") && !(strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+	}
+	if r.opts.strict && r.opts.allows(FixMissingHeader) && len(r.header) == 0 && (isContinuation(line) || !strings.Contains(line, ":")) {
+		// fix: process a part starting with a non-header line as having an empty header block
+		r.fixed(FixMissingHeader)
+		r.endHeader()
+		r.readBody(line)
+		return
+	}
+	if isContinuation(line) {
+		r.appendContinuation(line)
+		return
+	}
+	if !strings.Contains(line, ":") {
+		if r.opts.allows(FixIndentContinuations) {
 			// fix: indent continuation headers with a space
+			r.fixed(FixIndentContinuations)
 			line = " " + line
-			return line, nil
 		}
-		return line, nil
+		r.appendContinuation(line)
+		return
+	}
+	f := newField(line)
+	f.line = r.line
+	r.recordEnd(f, line)
+	r.header = append(r.header, f)
+}
+
+func (r *Reader) appendContinuation(line string) {
+	if len(r.header) == 0 {
+		f := &field{lines: []string{line}, size: len(line), line: r.line}
+		r.recordEnd(f, line)
+		r.header = append(r.header, f)
+		return
+	}
+	f := r.header[len(r.header)-1]
+	if f.size+len(line) > maxFieldSize && r.opts.allows(FixFieldSize) {
+		// fix: truncate fields folded across too many lines
+		if !f.truncated {
+			r.fixed(FixFieldSize)
+			f.truncated = true
+			r.find(FindingFieldTruncated, r.line, strings.TrimSpace(f.name)+" field truncated to "+strconv.Itoa(f.size)+" bytes")
+		}
+		return
+	}
+	f.lines = append(f.lines, line)
+	f.size += len(line)
+	r.recordEnd(f, line)
+}
+
+// endHeader is called on the empty line ending a header block.
+func (r *Reader) endHeader() {
+	r.fixHeader()
+	r.part = newPart(r.header, r.opts.strict)
+	if r.opts.dkim == DKIMSafe && r.dkim != nil && len(r.entities) == 1 && len(r.dkim.signatures) > 0 {
+		// keep the body signed by the DKIM signatures as is
+		r.part.boundary = ""
+		r.part.embedded = false
+		r.part.opaque = true
+		r.part.signed = true
+	}
+	var encodedMessage bool
+	if r.part.embedded && (r.part.encoding == "quoted-printable" || r.part.encoding == "base64" && !r.opts.decodeMultiparts) {
+		// an encoded embedded message cannot be parsed without being decoded
+		r.part.embedded = false
+		encodedMessage = r.opts.fixContainerEncoding && !r.opts.archive1 && (r.opts.maxDepth <= 0 || len(r.entities)-1 < r.opts.maxDepth)
+	}
+	if r.part.mediaType == "message/partial" {
+		if p, ok := parsePartial(lookup(r.header, "Content-Type").value()); ok {
+			detail := "fragment " + strconv.Itoa(p.Number)
+			if p.Total > 0 {
+				detail += " of " + strconv.Itoa(p.Total)
+			}
+			r.find(FindingPartial, r.line, detail+" of message "+strconv.Quote(p.ID))
+		}
+	}
+	if r.part.isContainer() {
+		r.checkDepth()
+	}
+	if r.opts.maxDepth > 0 && len(r.entities)-1 >= r.opts.maxDepth && r.part.isContainer() {
+		r.find(FindingMaxDepth, r.line, "container at the maximum depth of "+strconv.Itoa(r.opts.maxDepth)+" processed as opaque")
+		r.part.boundary = ""
+		r.part.embedded = false
+		r.part.opaque = true
+	}
+	if r.part.boundary != "" && !r.part.opaque && r.collides(r.part.boundary) {
+		r.find(FindingBoundaryCollision, r.line, "boundary "+strconv.Quote(r.part.boundary)+" collides with the boundary of an enclosing multipart")
+		if r.opts.renameBoundaries {
+			r.renameBoundary()
+		}
+	}
+	var synthesized string
+	if r.opts.strict && !r.part.opaque && r.opts.allows(FixMissingBoundary) && strings.HasPrefix(r.part.mediaType, "multipart/") && (r.part.boundary == "" || r.collides(r.part.boundary)) {
+		// fix: declare a boundary for a multipart without a usable one, wrapping its body in a part
+		r.fixed(FixMissingBoundary)
+		synthesized = synthesizedBoundary(len(r.entities))
+		r.part.params["boundary"] = synthesized
+		lookup(r.header, "Content-Type").setValue(formatMediaType(r.part.mediaType, r.part.params, r.opts.smtputf8))
+		r.part = newPart(r.header, true)
+	}
+	if r.opts.decodeMultiparts && synthesized == "" && (r.part.boundary != "" || r.part.embedded) && r.part.encoding == "base64" {
+		r.startDecoding()
+		r.part.decoded = true
+		r.part.held = true
+	}
+	var j *job
+	if encodedMessage && !r.part.opaque {
+		r.part.body = &embeddedChecker{r: r}
+		r.part.held = true
+	} else if r.looksAhead() {
+		r.part.body = &lookahead{r: r}
+		r.part.held = true
+	} else if r.opts.parallelism > 1 && !r.opts.preserveLineEnds && !r.part.isContainer() && !r.holdsHeader() && !r.extractsFiles() && !r.expandsTNEF() && !r.holdsLength() {
+		j = r.newJob()
+		r.part.body = r.body(&j.out)
+		if _, ok := r.part.body.(output); ok {
+			// the part needs no processing
+			j = nil
+			r.part.body = output{out: &r.buffer}
+		}
+	} else {
+		r.part.body = r.body(&r.buffer)
+	}
+	e := r.entities[len(r.entities)-1]
+	e.mediaType = r.part.mediaType
+	e.boundary = r.part.boundary
+	if !r.part.held {
+		r.releaseHeader()
+	}
+	if j != nil {
+		r.startJob(j)
+	}
+	if r.part.boundary != "" {
+		r.delimiters = append(r.delimiters, "--"+r.part.boundary)
+		r.containers = append(r.containers, len(r.entities)-1)
+	}
+	if r.part.embedded {
+		r.openEntity(e.path)
+		r.entities[len(r.entities)-1].embedded = true
+		r.state = stateHeader
+	} else {
+		r.state = stateBody
+	}
+	if synthesized != "" {
+		r.readLine("--" + synthesized)
+		r.readLine("")
+	}
+}
+
+// releaseHeader outputs the header block of the current part, and its ending empty line.
+func (r *Reader) releaseHeader() {
+	if len(r.entities) == 1 {
+		r.messageHeader = make(textproto.MIMEHeader, len(r.header))
+		for _, f := range r.header {
+			r.messageHeader.Add(strings.TrimRight(f.name, " \t"), f.value())
+		}
+	}
+	r.flushHeader()
+	r.emit("")
+	e := r.entities[len(r.entities)-1]
+	e.bodyStart = r.offset()
+	e.hasBody = true
+	r.part.held = false
+}
+
+// fixHeader applies the fixers of the pipeline to the header block.
+func (r *Reader) fixHeader() {
+	h := &Header{r: r}
+	if r.opts.policy != nil && h.IsMessage() {
+		r.applyPolicy(h)
+	}
+	var hidden []*field
+	var indexes []int
+	if r.opts.dkim == DKIMSafe && r.dkim != nil && h.IsMessage() {
+		r.dkim.parse()
+		hidden, indexes = r.hideSigned()
+	}
+	for _, f := range r.fixers {
+		f.FixHeader(h)
+	}
+	if hidden != nil {
+		r.restoreSigned(hidden, indexes)
+	}
+}
+
+// stampAutoSubmitted sets the Auto-Submitted field of the header, unless the message
+// is already marked as automatically submitted, and returns whether it was set.
+func (r *Reader) stampAutoSubmitted() bool {
+	f := lookup(r.header, "Auto-Submitted")
+	if f == nil {
+		r.header = append(r.header, newField("Auto-Submitted: "+r.opts.autoSubmitted))
+		return true
+	}
+	if strings.EqualFold(f.value(), "no") {
+		f.setValue(r.opts.autoSubmitted)
+		return true
+	}
+	return false
+}
+
+// flushHeader outputs the header block read so far.
+func (r *Reader) flushHeader() {
+	r.checkHeader()
+	for _, f := range r.header {
+		if f == r.length {
+			r.lengthAt = r.base + int64(len(r.buffer))
+			r.lengthOutput = true
+		}
+		for i, line := range f.lines {
+			end := "\r\n"
+			if i < len(f.input) && f.input[i].line == line {
+				end = f.input[i].end
+			}
+			r.startLine()
+			r.buffer = append(r.buffer, line...)
+			r.endLine(end)
+		}
+	}
+	r.header = r.header[:0]
+	r.headerSize = 0
+}
+
+// abortHeader outputs a header block that was not ended by an empty line.
+func (r *Reader) abortHeader() {
+	if len(r.header) > 0 {
+		r.entities[len(r.entities)-1].mediaType = newPart(r.header, false).mediaType
+	}
+	r.flushHeader()
+}
+
+// checkHeader makes the Reader give up with an error matching ErrUnfixable if the fixed
+// header block of the message cannot be parsed with net/mail.ReadMessage, in strict mode
+// or when called from FixOrQuarantine.
+func (r *Reader) checkHeader() {
+	if !r.opts.strict && !r.opts.checkHeader || len(r.entities) != 1 || r.limitErr != nil {
+		return
+	}
+	var b strings.Builder
+	for _, f := range r.header {
+		for _, line := range f.lines {
+			b.WriteString(line)
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := textproto.NewReader(bufio.NewReader(strings.NewReader(b.String()))).ReadMIMEHeader(); err != nil {
+		r.limitErr = &unfixableError{msg: "messagefix: message cannot be fixed: " + err.Error(), err: err}
+	}
+}
+
+func (r *Reader) readBody(line string) {
+	r.part.written = true
+	if r.part.body != nil {
+		r.part.body.writeLine(line)
+		return
+	}
+	r.emit(line)
+}
+
+// endPart is called when the current part ends, either on a delimiter line or at EOF.
+func (r *Reader) endPart(delimiter bool) {
+	if r.state == stateHeader {
+		if !r.opts.strict || !r.opts.allows(FixUnterminatedHeader) {
+			r.abortHeader()
+			return
+		}
+		// fix: end the header blocks of the part
+		r.fixed(FixUnterminatedHeader)
+		for r.state == stateHeader {
+			r.endHeader()
+			if n := len(r.decoders); n > 0 && !r.decoders[n-1].decoding {
+				// the body of the container is empty
+				r.endDecoding(n - 1)
+			}
+		}
+	}
+	if r.opts.strict && r.opts.allows(FixEmptyEmbeddedMessages) && delimiter && !r.part.written && r.entities[len(r.entities)-1].embedded {
+		// fix: add an empty body line, since the CRLF preceding the delimiter belongs to it,
+		// so that an empty embedded message still has its empty line
+		r.fixed(FixEmptyEmbeddedMessages)
+		r.readBody("")
+	}
+	if r.part.body != nil {
+		r.part.body.end(delimiter)
+		r.part.body = nil
+	}
+}
+
+// closeDelimiters closes the multiparts of the open boundaries from the n-th one, innermost
+// first, by outputting their close delimiter.
+func (r *Reader) closeDelimiters(n int) {
+	for i := len(r.delimiters) - 1; i >= n; i-- {
+		r.closeEntities(r.containers[i]+1, true)
+		r.emit(r.delimiters[i] + "--")
+	}
+	r.delimiters = r.delimiters[:n]
+	r.containers = r.containers[:n]
+}
+
+// finish is called at EOF.
+func (r *Reader) finish() {
+	open := len(r.delimiters) > 0
+	endedInHeader := r.state == stateHeader
+	if r.state == stateHeader && (!r.opts.strict || !r.opts.allows(FixUnterminatedHeader)) {
+		r.abortHeader()
+		if open {
+			r.emit("")
+			r.state = stateBody
+		}
+	}
+	r.endPart(len(r.delimiters) > 0)
+	var delimiters []string
+	if r.opts.allows(FixCloseMultiparts) {
+		delimiters = r.delimiters
+		// fix: close any remaining open multiparts
+		for _, c := range r.containers {
+			f := Fix{ID: FixCloseMultiparts, Path: r.entities[c].path, Line: r.line}
+			r.stats.fixed(f)
+			if r.onFix != nil {
+				r.onFix(f)
+			}
+		}
+		r.closeDelimiters(0)
 	}
-	return line, nil
+	r.waitJobs()
+	r.terminate()
+	r.recomputeLength()
+	r.closeEntities(0, false)
+	r.summarize(endedInHeader, delimiters)
+	r.delimiters = nil
+	r.containers = nil
 }
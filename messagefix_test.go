@@ -0,0 +1,41 @@
+package messagefix
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixTest is a message fixed with options, and its expected output.
+type fixTest struct {
+	name  string
+	input string
+	opts  []Option
+	want  string
+	// fixes are the fixes expected to be reported, in order, if not nil.
+	fixes []FixID
+}
+
+// runFixTests checks the output of a Reader for each test.
+func runFixTests(t *testing.T, tests []fixTest) {
+	t.Helper()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var fixes []FixID
+			opts := append(append([]Option(nil), tc.opts...), WithOnFix(func(f Fix) {
+				fixes = append(fixes, f.ID)
+			}))
+			b, err := io.ReadAll(NewReader(strings.NewReader(tc.input), opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tc.want {
+				t.Errorf("output:\n%q\nwant:\n%q", got, tc.want)
+			}
+			if tc.fixes != nil && !reflect.DeepEqual(fixes, tc.fixes) && (len(fixes) > 0 || len(tc.fixes) > 0) {
+				t.Errorf("fixes: %v, want %v", fixes, tc.fixes)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package messagefix
+
+import (
+	"bytes"
+	"sort"
+)
+
+// OffsetMap maps byte offsets between an original message and its fixed form, for example to
+// translate the byte ranges of IMAP partial fetches of the fixed message, or the positions of
+// errors in it, to the original message.
+//
+// The lines of both messages are aligned as for Diff. Offsets in lines that are the same in
+// both messages map to the same position in the other message; offsets in lines or line
+// terminators that were changed map to the same distance from the start of the changed
+// content in the other message, within its bounds.
+type OffsetMap struct {
+	segments []offsetSegment
+	// originalSize and fixedSize are the sizes of the messages.
+	originalSize, fixedSize int64
+}
+
+// offsetSegment is a pair of aligned ranges of the original and fixed messages.
+type offsetSegment struct {
+	original, fixed       int64
+	originalLen, fixedLen int64
+	// equal is set when the ranges have the same content.
+	equal bool
+}
+
+// NewOffsetMap returns the offset map between an original message and its fixed form.
+func NewOffsetMap(original, fixed []byte) *OffsetMap {
+	a, aTerms := splitLines(original)
+	b, bTerms := splitLines(fixed)
+	at, bt := lineTerms(aTerms), lineTerms(bTerms)
+	m := &OffsetMap{
+		originalSize: int64(len(original)),
+		fixedSize:    int64(len(fixed)),
+	}
+	var ao, bo int64
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && bytes.Equal(a[i], b[j]) {
+			n := int64(len(a[i]))
+			m.add(ao, bo, n, n, true)
+			ao, bo = ao+n, bo+n
+			an, bn := termLen(at[i]), termLen(bt[j])
+			m.add(ao, bo, an, bn, at[i] == bt[j])
+			ao, bo = ao+an, bo+bn
+			i++
+			j++
+			continue
+		}
+		x, y := resync(a[i:], b[j:])
+		if x == y {
+			// align the changed lines one by one
+			for k := 0; k < x; k++ {
+				an, bn := int64(len(a[i]))+termLen(at[i]), int64(len(b[j]))+termLen(bt[j])
+				m.add(ao, bo, an, bn, false)
+				ao, bo = ao+an, bo+bn
+				i++
+				j++
+			}
+			continue
+		}
+		var an, bn int64
+		for k := i; k < i+x; k++ {
+			an += int64(len(a[k])) + termLen(at[k])
+		}
+		for k := j; k < j+y; k++ {
+			bn += int64(len(b[k])) + termLen(bt[k])
+		}
+		m.add(ao, bo, an, bn, false)
+		ao, bo = ao+an, bo+bn
+		i += x
+		j += y
+	}
+	return m
+}
+
+// add appends a segment, merging it with the last segment if both are equal.
+func (m *OffsetMap) add(original, fixed, originalLen, fixedLen int64, equal bool) {
+	if originalLen == 0 && fixedLen == 0 {
+		return
+	}
+	if n := len(m.segments); n > 0 && equal && m.segments[n-1].equal {
+		last := &m.segments[n-1]
+		last.originalLen += originalLen
+		last.fixedLen += fixedLen
+		return
+	}
+	m.segments = append(m.segments, offsetSegment{
+		original:    original,
+		fixed:       fixed,
+		originalLen: originalLen,
+		fixedLen:    fixedLen,
+		equal:       equal,
+	})
+}
+
+// Original returns the offset in the original message of an offset in the fixed message.
+func (m *OffsetMap) Original(fixed int64) int64 {
+	k := sort.Search(len(m.segments), func(k int) bool {
+		s := &m.segments[k]
+		return s.fixed+s.fixedLen > fixed
+	})
+	if k == len(m.segments) {
+		return m.originalSize
+	}
+	s := &m.segments[k]
+	return s.original + within(fixed-s.fixed, s.originalLen)
+}
+
+// Fixed returns the offset in the fixed message of an offset in the original message.
+func (m *OffsetMap) Fixed(original int64) int64 {
+	k := sort.Search(len(m.segments), func(k int) bool {
+		s := &m.segments[k]
+		return s.original+s.originalLen > original
+	})
+	if k == len(m.segments) {
+		return m.fixedSize
+	}
+	s := &m.segments[k]
+	return s.fixed + within(original-s.original, s.fixedLen)
+}
+
+// within returns the distance d bounded to a range of n bytes.
+func within(d, n int64) int64 {
+	switch {
+	case d < 0 || n == 0:
+		return 0
+	case d >= n:
+		return n - 1
+	}
+	return d
+}
+
+// lineTerms returns the terminator of each line from their runs.
+func lineTerms(terms []run) []byte {
+	var lines []byte
+	for _, t := range terms {
+		for i := 0; i < t.n; i++ {
+			lines = append(lines, t.term)
+		}
+	}
+	return lines
+}
+
+// termLen returns the size of a line terminator.
+func termLen(term byte) int64 {
+	switch term {
+	case termLF:
+		return 1
+	case termCRLF:
+		return 2
+	}
+	return 0
+}
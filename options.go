@@ -0,0 +1,702 @@
+package messagefix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Option configures a Reader.
+type Option func(*options)
+
+type options struct {
+	binary           bool
+	emptyMode        EmptyMode
+	headerless       HeaderlessMode
+	orphans          OrphanMode
+	blankLines       BlankLineMode
+	encodingMismatch EncodingMismatch
+	priority         PriorityForm
+	autoSubmitted    string
+	precedence       string
+	fixReferences    bool
+	sniffContentType bool
+	defaultType      string
+	dkim             DKIMMode
+	contentLength    ContentLengthMode
+
+	fixQuotedPrintable   bool
+	fixBase64Padding     bool
+	fixMisplacedParams   bool
+	stripComments        bool
+	fixContainerEncoding bool
+	decodeMultiparts     bool
+	fixUnusedBoundaries  bool
+	quoteBoundaries      bool
+	renameBoundaries     bool
+	finalEmptyLine       bool
+	encodeAttachments    bool
+	uuAttachments        bool
+	yencAttachments      bool
+	expandTNEF           bool
+	dispositionFromName  bool
+	fixMIMEVersion       bool
+	mapEncodings         bool
+	fixDoubleQP          bool
+	wrapQP               bool
+	normalizeBase64      bool
+	insertColons         bool
+	foldWidth            int
+	foldTraceWidth       int
+	normalizeCharsets    bool
+	transcode            bool
+	canonicalKeys        bool
+	truncateMailLoops    bool
+	stripFromLine        bool
+	unescapeFrom         bool
+	detectFromLine       bool
+	rejoinDelimiters     bool
+	replaceUTF8          bool
+	utf8Replacement      string
+	stripControls        bool
+	controlReplacement   string
+	dotUnstuffing        bool
+	dotStuffing          bool
+	lfOutput             bool
+	preserveLineEnds     bool
+	smtputf8             bool
+	downgradeUTF8        bool
+	// strict is set by the profiles of consumers that parse the MIME structure strictly.
+	strict bool
+	// archive1 is set by ProfileArchive1.
+	archive1 bool
+	// checkHeader is set by FixOrQuarantine, to give up on messages whose fixed header block
+	// cannot be parsed.
+	checkHeader bool
+	fixers      []Fixer
+	policy      func(h *Header) []Option
+	// allowed is the set of fixes allowed by WithFixIDs and WithFixesAfter, or nil for all fixes.
+	allowed    map[FixID]bool
+	onFix      func(Fix)
+	onLossyFix func(Fix)
+	reject     map[FixID]bool
+	logger     *slog.Logger
+
+	newSigner        func() (Signer, error)
+	prependSignature bool
+
+	closeInput    bool
+	bufferSize    int
+	maxBufferSize int
+	readSize      int
+	ctx           context.Context
+	clock         func() time.Time
+	random        io.Reader
+	limits        Limits
+	maxDepth      int
+
+	parallelism    int
+	spillToDisk    bool
+	spillDir       string
+	spillThreshold int64
+}
+
+// WithBinary enables decoding the Content-Transfer-Encoding of all leaf parts.
+//
+// Base64 and quoted-printable part bodies are decoded, and their Content-Transfer-Encoding
+// header is rewritten to binary. The MIME structure of the message is kept, so that the
+// output is suitable for the IMAP BINARY extension (RFC 3516).
+//
+// A decoded part could in theory contain one of its enclosing boundary delimiters, which would
+// break the message structure; this is deemed unlikely enough that it is not checked.
+func WithBinary(enabled bool) Option {
+	return func(o *options) {
+		o.binary = enabled
+	}
+}
+
+// WithFixQuotedPrintable enables repairing invalid quoted-printable part bodies.
+//
+// Stray equal signs and unescaped control or 8-bit bytes are escaped, hexadecimal digits are
+// uppercased, and whitespace after or soft line breaks at the very end of a part are removed,
+// so that strict quoted-printable decoders accept the part.
+func WithFixQuotedPrintable(enabled bool) Option {
+	return func(o *options) {
+		o.fixQuotedPrintable = enabled
+	}
+}
+
+// WithFixBase64Padding enables repairing the last quantum of base64 part bodies.
+//
+// Truncated messages often end in the middle of a base64 part, which makes strict base64
+// decoders reject the part. The last quantum of each base64 part is padded, or removed if it
+// cannot be decoded at all.
+func WithFixBase64Padding(enabled bool) Option {
+	return func(o *options) {
+		o.fixBase64Padding = enabled
+	}
+}
+
+// WithNormalizeBase64 enables stripping the spaces and tabs of the lines of base64 part
+// bodies, and splitting the lines longer than 76 characters, which strict decoders and line
+// length validators reject. Other lines are kept as read.
+func WithNormalizeBase64(enabled bool) Option {
+	return func(o *options) {
+		o.normalizeBase64 = enabled
+	}
+}
+
+// WithFixMisplacedParams enables stripping the parameters of the MIME header fields that take
+// none, such as "Content-Transfer-Encoding: quoted-printable; charset=utf-8", in which case the
+// charset, format, delsp and name parameters are relocated to the Content-Type field, unless it
+// already has them.
+func WithFixMisplacedParams(enabled bool) Option {
+	return func(o *options) {
+		o.fixMisplacedParams = enabled
+	}
+}
+
+// WithStripComments enables removing the comments of the Content-Type, Content-Disposition,
+// Content-Transfer-Encoding and MIME-Version fields, such as in
+// "multipart/mixed (generated by X); boundary=x", which some parsers take as part of the
+// values. Parentheses that do not follow whitespace or a semicolon, such as in the unquoted
+// parameter value "report(1).pdf", are kept. The comments are ignored by the Reader either way.
+func WithStripComments(enabled bool) Option {
+	return func(o *options) {
+		o.stripComments = enabled
+	}
+}
+
+// WithMapEncodings enables rewriting the unknown Content-Transfer-Encoding values, which strict
+// parsers reject, to a standard encoding: variants of the standard encodings, such as "7-bit"
+// or "x-base64", to that encoding, "none" to 7bit, and the other values, such as charset
+// names, to 8bit. The bodies of leaf parts declared as uuencoded, such as with "x-uuencode",
+// are decoded and encoded as base64.
+func WithMapEncodings(enabled bool) Option {
+	return func(o *options) {
+		o.mapEncodings = enabled
+	}
+}
+
+// WithInsertColons enables inserting the missing colon of header lines without one whose
+// first word is the name of a well-known field, such as "Subject hello" or "X-Mailer Foo 1.0",
+// rather than indenting them as continuation lines. Other header lines without a colon are
+// still indented.
+func WithInsertColons(enabled bool) Option {
+	return func(o *options) {
+		o.insertColons = enabled
+	}
+}
+
+// WithFixMIMEVersion enables replacing the MIME-Version fields of a header block with a single
+// "MIME-Version: 1.0" field when there are several of them, or when the version is not 1.0 or
+// has unbalanced comments, such as "1.0 (produced by X". A single valid field, possibly with
+// comments, is kept as read.
+func WithFixMIMEVersion(enabled bool) Option {
+	return func(o *options) {
+		o.fixMIMEVersion = enabled
+	}
+}
+
+// WithFixContainerEncoding enables removing the Content-Transfer-Encoding field of multipart
+// and message/rfc822 entities declaring an encoding other than 7bit, 8bit or binary, which
+// RFC 2045 forbids and some strict parsers reject. The body of these entities is processed as
+// not encoded; the header block of multiparts is held back until the end of their preamble, and
+// the field is kept if the preamble is not ended by a delimiter of the multipart, since its body
+// is then likely actually encoded.
+//
+// The field of message/rfc822 entities declared as base64 or quoted-printable is only removed
+// if their body starts with a header field which is not base64, and, for quoted-printable, if
+// decoding it would change nothing; their header block is held back along with their body
+// until then, up to 1MiB. Otherwise the field is kept and the body is output as is, unless
+// WithDecodeMultiparts decodes it.
+func WithFixContainerEncoding(enabled bool) Option {
+	return func(o *options) {
+		o.fixContainerEncoding = enabled
+	}
+}
+
+// WithFixUnusedBoundaries enables rewriting the Content-Type field of multiparts whose
+// boundary never appears in their body as text/plain, instead of closing them at the end of
+// their body as multiparts without parts, which some parsers reject. The header block of
+// multiparts is held back until the end of their preamble, and kept as is if the preamble is
+// larger than 1MiB.
+func WithFixUnusedBoundaries(enabled bool) Option {
+	return func(o *options) {
+		o.fixUnusedBoundaries = enabled
+	}
+}
+
+// WithQuoteBoundaries enables quoting the boundary parameter of Content-Type fields when it is
+// not a token but is unquoted, such as "boundary=a=b" or "boundary=b c", or when its quotes are
+// unbalanced, which strict parsers reject. The rest of the field is kept as is.
+func WithQuoteBoundaries(enabled bool) Option {
+	return func(o *options) {
+		o.quoteBoundaries = enabled
+	}
+}
+
+// WithRenameBoundaries enables renaming the boundary of multiparts that collides with the
+// boundary of an enclosing multipart, such as when a generator reuses the same boundary for
+// nested multiparts, so that their delimiters cannot be told apart. The boundary is renamed in
+// the Content-Type field, which is rewritten in a standard form, and in the delimiter lines
+// that match it, which are assigned to the innermost multipart they match.
+//
+// Collisions are reported as findings whether this option is set or not.
+func WithRenameBoundaries(enabled bool) Option {
+	return func(o *options) {
+		o.renameBoundaries = enabled
+	}
+}
+
+// WithFinalEmptyLine enables ending the fixed message with an empty line, for consumers that
+// require the message to end with CRLF CRLF. An empty message is output as a single empty line.
+//
+// The fixed message always ends with a CRLF line terminator otherwise, including when the last
+// line of a part decoded with WithBinary has none.
+func WithFinalEmptyLine(enabled bool) Option {
+	return func(o *options) {
+		o.finalEmptyLine = enabled
+	}
+}
+
+// WithDecodeMultiparts enables decoding the body of multiparts declared as base64, as some
+// versions of Exchange produce, before processing their boundaries, so that their parts are
+// visible to consumers. The Content-Transfer-Encoding field of these multiparts is removed.
+// Embedded messages declared as base64 are decoded likewise, so that they are fixed as any
+// other message, as are containers nested in decoded bodies.
+//
+// The body is only decoded if its first non-empty line only contains base64 characters, and
+// until a delimiter line, such as one of an enclosing multipart. Without this option, embedded
+// messages declared as base64 or quoted-printable are processed as opaque content.
+func WithDecodeMultiparts(enabled bool) Option {
+	return func(o *options) {
+		o.decodeMultiparts = enabled
+	}
+}
+
+// WithNormalizeCharsets enables rewriting common bogus charset labels in Content-Type fields,
+// such as utf8, ansi or iso8859-1, to their IANA preferred MIME name, so that charset
+// lookups succeed.
+func WithNormalizeCharsets(enabled bool) Option {
+	return func(o *options) {
+		o.normalizeCharsets = enabled
+	}
+}
+
+// EmptyMode is the behavior of a Reader on empty or whitespace-only input.
+type EmptyMode int
+
+const (
+	// EmptyPassThrough processes empty input as any other input. This is the default.
+	EmptyPassThrough EmptyMode = iota
+	// EmptyError makes the Reader return ErrEmptyMessage.
+	EmptyError
+	// EmptySynthesize makes the Reader output a minimal valid message instead, made of
+	// synthesized Date and From header fields and an empty body.
+	EmptySynthesize
+)
+
+// WithEmptyMode sets the behavior of the Reader on empty or whitespace-only input.
+func WithEmptyMode(mode EmptyMode) Option {
+	return func(o *options) {
+		o.emptyMode = mode
+	}
+}
+
+// HeaderlessMode is the behavior of a Reader on input without a header block, such as a raw
+// text blob stored as a message, whose first line is not a header field.
+type HeaderlessMode int
+
+const (
+	// HeaderlessIgnore processes header-less input as any other input, which makes its first
+	// lines header field continuations. This is the default.
+	HeaderlessIgnore HeaderlessMode = iota
+	// HeaderlessBody makes the Reader output the input as the body of a message with an empty
+	// header block.
+	HeaderlessBody
+	// HeaderlessSynthesize makes the Reader output the input as the body of a message with
+	// synthesized Date and From header fields, as with EmptySynthesize.
+	HeaderlessSynthesize
+)
+
+// WithHeaderless sets the behavior of the Reader on input without a header block.
+//
+// Input is deemed header-less when its first line is not a header field, that is when it does
+// not start with a valid field name followed by a colon. Header-less input is reported as
+// FindingHeaderless whatever the mode.
+func WithHeaderless(mode HeaderlessMode) Option {
+	return func(o *options) {
+		o.headerless = mode
+	}
+}
+
+// OrphanMode is the behavior of a Reader on a header block starting with a continuation
+// line, which has no field to continue, as is common in truncated messages.
+type OrphanMode int
+
+const (
+	// OrphanKeep outputs continuation lines starting a header block as is. This is the
+	// default.
+	OrphanKeep OrphanMode = iota
+	// OrphanUnindent removes the indentation of continuation lines starting a header block,
+	// so that they start a field. Lines that are not a field once unindented are promoted as
+	// with OrphanPromote.
+	OrphanUnindent
+	// OrphanPromote rewrites continuation lines starting a header block to an
+	// X-Broken-Header field holding the line.
+	OrphanPromote
+	// OrphanDrop removes continuation lines starting a header block.
+	OrphanDrop
+)
+
+// WithOrphanContinuations sets the behavior of the Reader on header blocks starting with a
+// continuation line, which strict parsers reject.
+//
+// The mode applies to the first lines of all header blocks, and takes precedence over
+// WithHeaderless for the message header block. Whitespace-only lines are handled by
+// WithBlankHeaderLines instead.
+func WithOrphanContinuations(mode OrphanMode) Option {
+	return func(o *options) {
+		o.orphans = mode
+	}
+}
+
+// BlankLineMode is the behavior of a Reader on whitespace-only lines in header blocks, which
+// strict parsers accept neither as a continuation line nor as the empty line ending the block.
+type BlankLineMode int
+
+const (
+	// BlankLinesKeep outputs whitespace-only lines in header blocks as continuation lines.
+	// This is the default.
+	BlankLinesKeep BlankLineMode = iota
+	// BlankLinesSeparate processes whitespace-only lines in header blocks as the empty line
+	// ending the block, and outputs an empty line instead.
+	BlankLinesSeparate
+	// BlankLinesRemove removes whitespace-only lines in header blocks.
+	BlankLinesRemove
+)
+
+// WithBlankHeaderLines sets the behavior of the Reader on whitespace-only lines in header
+// blocks.
+func WithBlankHeaderLines(mode BlankLineMode) Option {
+	return func(o *options) {
+		o.blankLines = mode
+	}
+}
+
+// WithTranscode enables transcoding the body of text parts to UTF-8.
+//
+// Text part bodies are decoded from their declared charset, and encoded again with the same
+// Content-Transfer-Encoding; their charset parameter is rewritten to UTF-8. Lines of text parts
+// without a charset, or declared as US-ASCII, are kept if they are valid UTF-8 and transcoded
+// from windows-1252 otherwise. Parts with an unknown charset, or a charset that is not a
+// superset of ASCII such as UTF-16, are left untouched.
+func WithTranscode(enabled bool) Option {
+	return func(o *options) {
+		o.transcode = enabled
+	}
+}
+
+// WithCanonicalKeys enables rewriting header field names to their canonical form,
+// as returned by textproto.CanonicalMIMEHeaderKey, except for well-known names such as
+// Message-ID or DKIM-Signature, which use their usual capitalization.
+//
+// This is useful for stores that compare or hash raw header lines.
+func WithCanonicalKeys(enabled bool) Option {
+	return func(o *options) {
+		o.canonicalKeys = enabled
+	}
+}
+
+// WithDispositionFromName enables adding a "Content-Disposition: attachment" field to the leaf
+// parts without one whose Content-Type field has a name parameter, with a filename parameter
+// of the same value, since strict consumers ignore the name parameter and show such parts as
+// unnamed attachments. The filename parameter is added to Content-Disposition fields without
+// one as well.
+func WithDispositionFromName(enabled bool) Option {
+	return func(o *options) {
+		o.dispositionFromName = enabled
+	}
+}
+
+// WithReplaceInvalidUTF8 enables replacing invalid UTF-8 byte sequences in the body of text parts
+// declared as UTF-8 with the passed replacement, which is usually "\uFFFD".
+func WithReplaceInvalidUTF8(replacement string) Option {
+	return func(o *options) {
+		o.replaceUTF8 = true
+		o.utf8Replacement = replacement
+	}
+}
+
+// WithStripControls enables replacing the control characters of header field bodies, such as
+// vertical tabs, form feeds or escape sequences, with the passed replacement, which is usually
+// "" to remove them or " ". Tabs are kept.
+func WithStripControls(replacement string) Option {
+	return func(o *options) {
+		o.stripControls = true
+		o.controlReplacement = replacement
+	}
+}
+
+// WithTruncateMailLoops enables removing the trace fields repeated by a mail loop from the
+// message header, keeping the oldest occurrence of each repeated Received and Delivered-To field.
+//
+// Mail loops are reported as FindingMailLoop whether this option is enabled or not.
+func WithTruncateMailLoops(enabled bool) Option {
+	return func(o *options) {
+		o.truncateMailLoops = enabled
+	}
+}
+
+// EncodingMismatch is the fix applied to parts declared as 7bit, explicitly or by default,
+// that contain 8-bit bytes.
+type EncodingMismatch int
+
+const (
+	// EncodingMismatchIgnore keeps such parts as is. This is the default.
+	EncodingMismatchIgnore EncodingMismatch = iota
+	// EncodingMismatch8Bit rewrites the Content-Transfer-Encoding of such parts to 8bit.
+	EncodingMismatch8Bit
+	// EncodingMismatchQuotedPrintable encodes such parts as quoted-printable, for
+	// consumers that only accept 7-bit messages.
+	EncodingMismatchQuotedPrintable
+)
+
+// WithEncodingMismatch sets the fix applied to 7bit parts that contain 8-bit bytes.
+//
+// Since the header block of a part must be rewritten before its body, the Reader holds
+// back the header block of each 7bit part until an 8-bit byte is found, or until 1MiB
+// of its body was read, in which case the fix is applied anyway.
+func WithEncodingMismatch(mode EncodingMismatch) Option {
+	return func(o *options) {
+		o.encodingMismatch = mode
+	}
+}
+
+// WithStripFromLine enables removing a leading mbox From_ line, such as
+// "From alice@example.com Mon Jan  1 00:00:00 2024", from the message.
+func WithStripFromLine(enabled bool) Option {
+	return func(o *options) {
+		o.stripFromLine = enabled
+	}
+}
+
+// WithUnescapeFrom enables removing the mbox quoting of text part lines starting with ">From ",
+// by removing one leading '>', as used by both the mboxo and mboxrd formats.
+func WithUnescapeFrom(enabled bool) Option {
+	return func(o *options) {
+		o.unescapeFrom = enabled
+	}
+}
+
+// WithFromLineDetection enables removing a leading mbox From_ line, as WithStripFromLine does,
+// and the mbox quoting of >From lines, as WithUnescapeFrom does, but only in messages that
+// started with a From_ line.
+//
+// This is useful for archives mixing messages extracted from mbox files, which kept their
+// From_ line and quoting, with clean messages, whose legitimate ">From" lines must be kept.
+func WithFromLineDetection(enabled bool) Option {
+	return func(o *options) {
+		o.detectFromLine = enabled
+	}
+}
+
+// WithRejoinDelimiters enables rejoining boundary delimiter lines folded across two lines by
+// some gateways, such as "--very-long-bound" followed by "ary".
+//
+// A line that is a prefix of an open delimiter line, at least half as long, is held back
+// until the next line; both are processed as a single delimiter line if they form one.
+// This may in rare cases join two genuine body lines.
+func WithRejoinDelimiters(enabled bool) Option {
+	return func(o *options) {
+		o.rejoinDelimiters = enabled
+	}
+}
+
+// WithPriority enables reconciling the X-Priority, Importance and Priority fields of the
+// message header into a consistent set, in the passed form.
+func WithPriority(form PriorityForm) Option {
+	return func(o *options) {
+		o.priority = form
+	}
+}
+
+// WithAutoSubmitted enables stamping the message header with an Auto-Submitted field
+// (RFC 3834) of the passed value, such as auto-generated, unless the message already
+// has an Auto-Submitted field with a value other than no.
+//
+// This is useful for gateways re-injecting fixed messages into SMTP.
+func WithAutoSubmitted(value string) Option {
+	return func(o *options) {
+		o.autoSubmitted = value
+	}
+}
+
+// WithPrecedence enables stamping the message header with a Precedence field of the passed
+// value, such as bulk, unless the message already has a Precedence field.
+func WithPrecedence(value string) Option {
+	return func(o *options) {
+		o.precedence = value
+	}
+}
+
+// WithDefaultContentType enables adding a Content-Type field of the passed value, usually
+// "text/plain; charset=us-ascii", to the message header when it has no Content-Type field,
+// for consumers that refuse to assume the default content type of RFC 2045.
+//
+// Only the message header is stamped: parts without a Content-Type field are kept as is,
+// since their default content type depends on their enclosing multipart.
+func WithDefaultContentType(value string) Option {
+	return func(o *options) {
+		o.defaultType = value
+	}
+}
+
+// WithBufferSize sets the initial and maximum sizes of the buffer of input lines of the Reader.
+//
+// The buffer grows as needed up to max bytes; input lines longer than max make the Reader return
+// ErrLineTooLong. The defaults are 4KiB and 64KiB, like bufio.Scanner. Small devices may use a
+// smaller initial size, while servers handling large messages with long lines, such as unfolded
+// base64 bodies, may use a larger initial and maximum size to avoid growing the buffer repeatedly.
+//
+// In BenchmarkReaderBufferSize, an initial size of 4KiB fixes a 4KB message as fast as 512
+// bytes, while 64KiB, which allocates 60KiB more per Reader, is about a third slower; it fixes
+// a 100MB message within 20% of 64KiB, while 512 bytes is about 30% slower.
+func WithBufferSize(initial, max int) Option {
+	return func(o *options) {
+		o.bufferSize = initial
+		o.maxBufferSize = max
+	}
+}
+
+// WithReadSize sets the maximum size of each Read call on the input io.Reader. By default,
+// the Reader reads as much as its buffer can hold.
+//
+// This is useful when the input io.Reader is costly to read in large chunks, for example
+// when it decrypts or decompresses its input on the fly. In BenchmarkReaderReadSize, reads of
+// 4KiB or more fix messages at the same speed as the default, while reads of 512 bytes are
+// about 2.5 times slower on a 100MB message, and reads of 64 bytes about 9 times slower.
+func WithReadSize(n int) Option {
+	return func(o *options) {
+		o.readSize = n
+	}
+}
+
+// WithContext makes the Reader stop reading once ctx is done, returning ctx.Err(), for
+// example to abort fixing a large message when its client disconnects.
+//
+// The context is checked between input lines; a Read call on the input io.Reader that blocks
+// is not interrupted, unless the input io.Reader itself stops on ctx.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithClock sets the clock of the values synthesized from the current time, such as the Date
+// field of messages synthesized in place of empty input and of quarantine messages, so that
+// fixing the same message twice produces the same bytes. By default, time.Now is used.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.clock = now
+	}
+}
+
+// WithRandom sets the source of the random bytes of the synthesized identifiers, such as the
+// Message-ID field of quarantine messages. A seeded math/rand.Rand, or a reader of fixed
+// bytes, makes the output reproducible. By default, crypto/rand.Reader is used.
+func WithRandom(random io.Reader) Option {
+	return func(o *options) {
+		o.random = random
+	}
+}
+
+// now returns the current time of the clock set with WithClock.
+func (o *options) now() time.Time {
+	if o.clock == nil {
+		return time.Now()
+	}
+	return o.clock()
+}
+
+// messageID returns a Message-ID field body synthesized from the source set with WithRandom.
+// The identifier is only made of zeros if the source fails.
+func (o *options) messageID() string {
+	random := o.random
+	if random == nil {
+		random = rand.Reader
+	}
+	id := make([]byte, 16)
+	io.ReadFull(random, id)
+	return "<" + hex.EncodeToString(id) + "@messagefix.invalid>"
+}
+
+// WithDotUnstuffing enables reading the input as dot-stuffed SMTP DATA (RFC 5321): the leading
+// dot of lines starting with a dot is removed, and a line made of a single dot ends the message.
+//
+// Since the Reader buffers its input, it may read past the line ending the message. When the
+// input can contain further data, it must be limited to that line, for example reading it one
+// byte at a time with WithReadSize(1).
+func WithDotUnstuffing(enabled bool) Option {
+	return func(o *options) {
+		o.dotUnstuffing = enabled
+	}
+}
+
+// WithDotStuffing enables writing the output as dot-stuffed SMTP DATA (RFC 5321): lines starting
+// with a dot are prefixed with another dot, and the output ends with a line made of a single dot,
+// after a CRLF if the fixed message does not end with one.
+//
+// Stuffing applies to the output of the Reader as a whole, including the closing delimiters
+// synthesized by the Reader. FixSized and NextChunk take stuffing into account; offsets in
+// Summary are in the fixed message, before stuffing.
+func WithDotStuffing(enabled bool) Option {
+	return func(o *options) {
+		o.dotStuffing = enabled
+	}
+}
+
+// WithLFOutput enables writing the output with LF line endings instead of CRLF, for storing
+// fixed messages in Maildir folders or git repositories. The message is fixed as with CRLF
+// line endings, which are only replaced in the output of the Reader as a whole, along with
+// any dot-stuffing; a CR not followed by LF is kept as is.
+//
+// FixSized and NextChunk take the LF line endings into account; offsets in Summary and
+// Structure are in the fixed message with CRLF line endings. With WithBinary, CRLF sequences
+// of decoded binary bodies are replaced as well.
+func WithLFOutput(enabled bool) Option {
+	return func(o *options) {
+		o.lfOutput = enabled
+	}
+}
+
+// WithParallelism enables processing the bodies of up to n leaf parts at once, on their own
+// goroutines, for n > 1. The output is the same as without parallelism.
+//
+// Only parts whose body is rewritten by a fix, such as WithBinary or WithTranscode, are processed
+// in parallel; parts whose header block is held back by WithEncodingMismatch are not. The Reader
+// keeps reading its input while a part is processed, up to 4MiB of output, so this mostly
+// benefits large messages with many parts, especially with WithSpillToDisk.
+func WithParallelism(n int) Option {
+	return func(o *options) {
+		o.parallelism = n
+	}
+}
+
+// WithSpillToDisk enables writing the output of parts processed in parallel to temporary files
+// in dir once it reaches threshold bytes, rather than keeping it in memory until it is read.
+// If dir is empty, the default directory for temporary files is used.
+//
+// The temporary files are removed once read; they are left behind if the Reader is not read
+// until the end.
+func WithSpillToDisk(dir string, threshold int64) Option {
+	return func(o *options) {
+		o.spillToDisk = true
+		o.spillDir = dir
+		o.spillThreshold = threshold
+	}
+}
@@ -0,0 +1,229 @@
+package messagefix
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	// jobBatch is the size of the batches of lines sent to a job.
+	jobBatch = 64 << 10
+	// maxReadAhead is the size of the output the Reader produces past a running job,
+	// before waiting for it.
+	maxReadAhead = 4 << 20
+	// spillChunk is the size of the reads of spilled job output.
+	spillChunk = 32 << 10
+)
+
+// segment is a part of the output queued before the Reader buffer: either bytes produced
+// by the Reader itself, or the output of a job.
+type segment struct {
+	data []byte
+	job  *job
+	// buf is the buffer of the reads of the spilled output of the job.
+	buf []byte
+	// loaded is set once the output of the job was moved to data.
+	loaded bool
+}
+
+// job processes the body of a leaf part on its own goroutine.
+//
+// The Reader sends the body lines of the part to the job by batches; the job writes them to
+// its lineWriter chain, whose output is out, or a temporary file once it grows too large.
+type job struct {
+	body lineWriter
+	out  []byte
+	// tail is the tail of the output of the job, once it is over.
+	tail      []byte
+	lines     chan []string
+	batch     []string
+	batchSize int
+	delimiter bool
+	// done is closed once the job is over, at which point size, file and err are set.
+	done chan struct{}
+	size int64
+	file *os.File
+	err  error
+
+	spillToDisk    bool
+	spillDir       string
+	spillThreshold int64
+}
+
+func (r *Reader) newJob() *job {
+	return &job{
+		lines:          make(chan []string, 4),
+		done:           make(chan struct{}),
+		spillToDisk:    r.opts.spillToDisk,
+		spillDir:       r.opts.spillDir,
+		spillThreshold: r.opts.spillThreshold,
+	}
+}
+
+// startJob queues the output of a job after the current output, then runs it and makes
+// it the body lineWriter of the current part.
+//
+// At most opts.parallelism jobs run at once; startJob waits for the oldest job otherwise.
+func (r *Reader) startJob(j *job) {
+	for len(r.active) >= r.opts.parallelism {
+		<-r.active[0].done
+		r.active = r.active[1:]
+	}
+	if len(r.buffer) > 0 {
+		r.queue = append(r.queue, &segment{data: r.buffer})
+		r.base += int64(len(r.buffer))
+		r.buffer = nil
+		r.spare = nil
+	}
+	r.queue = append(r.queue, &segment{job: j})
+	r.jobs = append(r.jobs, j)
+	r.active = append(r.active, j)
+	j.body, r.part.body = r.part.body, j
+	go j.run()
+}
+
+// waitJobs waits for all jobs to be over.
+func (r *Reader) waitJobs() {
+	for _, j := range r.active {
+		<-j.done
+	}
+	r.active = nil
+}
+
+// discardJobs ends all jobs, discarding their output and removing their temporary files.
+func (r *Reader) discardJobs() {
+	r.stopJob()
+	r.waitJobs()
+	for _, j := range r.jobs {
+		if j.file != nil {
+			j.closeFile()
+		}
+	}
+}
+
+// stopJob ends the job of the current part, if any, on a read error.
+func (r *Reader) stopJob() {
+	if j, ok := r.part.body.(*job); ok {
+		j.end(false)
+		r.part.body = nil
+	}
+}
+
+// queueHead returns the next bytes of the queued output. It returns no bytes and no error
+// when the head segment was exhausted, or when more input was read while its job runs.
+func (r *Reader) queueHead() ([]byte, error) {
+	s := r.queue[0]
+	if len(s.data) > 0 {
+		return s.data, nil
+	}
+	j := s.job
+	if j == nil || s.loaded {
+		r.queue = r.queue[1:]
+		return nil, nil
+	}
+	select {
+	case <-j.done:
+	default:
+		if len(r.buffer) < maxReadAhead && r.err == nil {
+			r.err = r.read()
+			return nil, nil
+		}
+		<-j.done
+	}
+	if j.err != nil {
+		return nil, j.err
+	}
+	if j.file == nil {
+		s.data = j.out
+		s.loaded = true
+		j.out = nil
+		return s.data, nil
+	}
+	if s.buf == nil {
+		s.buf = make([]byte, spillChunk)
+	}
+	n, err := j.file.Read(s.buf)
+	s.data = s.buf[:n]
+	if err == io.EOF {
+		err = j.closeFile()
+	}
+	if n > 0 {
+		return s.data, nil
+	}
+	return nil, err
+}
+
+func (j *job) writeLine(line string) {
+	j.batch = append(j.batch, line)
+	j.batchSize += len(line)
+	if j.batchSize >= jobBatch {
+		j.lines <- j.batch
+		j.batch = nil
+		j.batchSize = 0
+	}
+}
+
+func (j *job) end(delimiter bool) {
+	if len(j.batch) > 0 {
+		j.lines <- j.batch
+		j.batch = nil
+	}
+	j.delimiter = delimiter
+	close(j.lines)
+}
+
+func (j *job) run() {
+	defer close(j.done)
+	for lines := range j.lines {
+		for _, line := range lines {
+			j.body.writeLine(line)
+		}
+		if j.err == nil {
+			j.err = j.spill(false)
+		}
+	}
+	j.body.end(j.delimiter)
+	if j.err == nil {
+		j.err = j.spill(true)
+	}
+	j.tail = appendTail(j.tail, j.out)
+	if j.file == nil {
+		j.size += int64(len(j.out))
+	} else if j.err == nil {
+		_, j.err = j.file.Seek(0, io.SeekStart)
+	}
+}
+
+// spill writes the output of the job to its temporary file, if it is large enough or
+// the file already exists, creating it as needed.
+func (j *job) spill(last bool) error {
+	if !j.spillToDisk || len(j.out) == 0 {
+		return nil
+	}
+	if j.file == nil {
+		if last || int64(len(j.out)) < j.spillThreshold {
+			return nil
+		}
+		f, err := os.CreateTemp(j.spillDir, "messagefix-")
+		if err != nil {
+			return err
+		}
+		j.file = f
+	}
+	j.tail = appendTail(j.tail, j.out)
+	n, err := j.file.Write(j.out)
+	j.size += int64(n)
+	j.out = j.out[:0]
+	return err
+}
+
+// closeFile closes and removes the temporary file of the job.
+func (j *job) closeFile() error {
+	f := j.file
+	j.file = nil
+	err := f.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// paramlessFields are the MIME header fields whose value takes no parameters.
+var paramlessFields = []string{"Content-Transfer-Encoding", "MIME-Version"}
+
+// contentTypeParams are the Content-Type parameters relocated from paramless fields.
+var contentTypeParams = []string{"charset", "format", "delsp", "name"}
+
+// fixMisplacedParams strips the parameters of the fields that take none, such as
+// "Content-Transfer-Encoding: quoted-printable; charset=utf-8", relocating the Content-Type
+// parameters among them to the Content-Type field unless it already has them. It reports
+// whether the header was fixed.
+func fixMisplacedParams(header []*field) ([]*field, bool) {
+	fixed := false
+	relocated := make(map[string]string)
+	for _, f := range header {
+		if !isParamless(f) {
+			continue
+		}
+		value := f.value()
+		i := strings.IndexByte(value, ';')
+		if i < 0 {
+			continue
+		}
+		// fix: strip the parameters of a field that takes none
+		fixed = true
+		_, params := parseContentType(value)
+		for _, key := range contentTypeParams {
+			if v, ok := params[key]; ok && v != "" {
+				if _, ok := relocated[key]; !ok {
+					relocated[key] = v
+				}
+			}
+		}
+		f.setValue(strings.TrimRight(value[:i], " \t"))
+	}
+	if len(relocated) == 0 {
+		return header, fixed
+	}
+	// fix: relocate the Content-Type parameters to the Content-Type field
+	ct := lookup(header, "Content-Type")
+	if ct == nil {
+		ct = newField("Content-Type: text/plain")
+		header = append(header, ct)
+	}
+	_, params := parseContentType(ct.value())
+	for _, key := range contentTypeParams {
+		v, ok := relocated[key]
+		if !ok {
+			continue
+		}
+		if _, ok := params[key]; !ok {
+			setParam(ct, key, quoteParam(v))
+		}
+	}
+	return header, true
+}
+
+// addDisposition declares the file name of a leaf part declared only with the name parameter
+// of its Content-Type field in its Content-Disposition field, adding the field as an attachment
+// if there is none. It reports whether the header was fixed.
+func addDisposition(header []*field) ([]*field, bool) {
+	ct := lookup(header, "Content-Type")
+	if ct == nil {
+		return header, false
+	}
+	mediaType, params := parseContentType(ct.value())
+	name := params["name"]
+	if name == "" || strings.HasPrefix(mediaType, "multipart/") || strings.HasPrefix(mediaType, "message/") {
+		return header, false
+	}
+	cd := lookup(header, "Content-Disposition")
+	if cd == nil {
+		// fix: declare the file name of the part in a Content-Disposition field
+		return append(header, newField("Content-Disposition: attachment; filename="+quoteParam(name))), true
+	}
+	_, params = parseContentType(cd.value())
+	for key := range params {
+		if strings.HasPrefix(key, "filename") {
+			return header, false
+		}
+	}
+	// fix: add the file name of the part to its Content-Disposition field
+	setParam(cd, "filename", quoteParam(name))
+	return header, true
+}
+
+// fixMIMEVersion collapses the MIME-Version fields of the header into a single
+// "MIME-Version: 1.0" field if there are several, or if the value is not 1.0 or has unbalanced
+// comments. It reports whether the header was fixed.
+func fixMIMEVersion(header []*field) ([]*field, bool) {
+	var first *field
+	n := 0
+	for _, f := range header {
+		if f.is("MIME-Version") {
+			if first == nil {
+				first = f
+			}
+			n++
+		}
+	}
+	if first == nil {
+		return header, false
+	}
+	if n == 1 {
+		if value, ok := stripComments(first.value()); ok && strings.Join(strings.Fields(value), "") == "1.0" {
+			return header, false
+		}
+	}
+	// fix: collapse the MIME-Version fields into a single valid one
+	fixed := header[:0]
+	for _, f := range header {
+		if f == first {
+			f.setValue("1.0")
+		} else if f.is("MIME-Version") {
+			continue
+		}
+		fixed = append(fixed, f)
+	}
+	return fixed, true
+}
+
+// commentedFields are the MIME header fields whose comments are removed by
+// stripFieldComments.
+var commentedFields = []string{"Content-Type", "Content-Disposition", "Content-Transfer-Encoding", "MIME-Version"}
+
+// stripFieldComments removes the comments of the MIME header fields. It reports whether the
+// header was fixed.
+func stripFieldComments(header []*field) bool {
+	fixed := false
+	for _, f := range header {
+		for _, name := range commentedFields {
+			if !f.is(name) {
+				continue
+			}
+			if value, ok := stripParamComments(f.value()); ok {
+				// fix: remove the comments of a MIME header field
+				fixed = true
+				f.setValue(value)
+			}
+		}
+	}
+	return fixed
+}
+
+func isParamless(f *field) bool {
+	for _, name := range paramlessFields {
+		if f.is(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteParam returns a parameter value, as a quoted string if it is not a token.
+// quoteBoundary quotes the boundary parameter of the Content-Type field if it is not a token
+// and is not quoted, or has unbalanced quotes, keeping its value as read by parseContentType.
+// It reports whether the header was fixed.
+func quoteBoundary(header []*field) bool {
+	f := lookup(header, "Content-Type")
+	if f == nil {
+		return false
+	}
+	for i, line := range f.lines {
+		start, _ := findParam(line, "boundary")
+		if start < 0 {
+			continue
+		}
+		if line[start-1] == '"' {
+			start--
+			if strings.IndexByte(line[start+1:], '"') >= 0 {
+				return false
+			}
+		}
+		end := strings.IndexByte(line[start:], ';')
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += start
+		}
+		raw := strings.TrimRight(line[start:end], " \t")
+		quoted := quoteParam(strings.Trim(raw, "\""))
+		if quoted == raw {
+			return false
+		}
+		// fix: quote the boundary parameter
+		f.lines[i] = line[:start] + quoted + line[start+len(raw):]
+		return true
+	}
+	return false
+}
+
+func quoteParam(value string) string {
+	if isToken(value) {
+		return value
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '"' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(value[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,44 @@
+package messagefix
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+)
+
+// parseRetries are the options added by FixAndParse on each retry, cumulatively.
+var parseRetries = [][]Option{
+	{
+		WithStripFromLine(true),
+		WithEmptyMode(EmptySynthesize),
+		WithHeaderless(HeaderlessSynthesize),
+	},
+	{
+		WithNormalizeCharsets(true),
+		WithCanonicalKeys(true),
+		WithFixQuotedPrintable(true),
+		WithFixBase64Padding(true),
+		WithEncodingMismatch(EncodingMismatch8Bit),
+	},
+}
+
+// FixAndParse fixes the message read from r, and parses it with mail.ReadMessage.
+//
+// If the fixed message still cannot be parsed, FixAndParse retries with progressively more
+// fixes enabled on top of the passed options, and returns the error of the last attempt if
+// all of them fail. The message is read to memory first, so that it can be fixed again; the
+// body of the returned message reads the fixed body.
+func FixAndParse(r io.Reader, opts ...Option) (*mail.Message, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts[:len(opts):len(opts)]
+	for i := 0; ; i++ {
+		m, err := mail.ReadMessage(NewReader(bytes.NewReader(b), opts...))
+		if err == nil || i == len(parseRetries) {
+			return m, err
+		}
+		opts = append(opts, parseRetries[i]...)
+	}
+}
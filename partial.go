@@ -0,0 +1,174 @@
+package messagefix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// ErrIncompletePartial is returned by Reassemble when the fragments of a message/partial
+// message are missing or do not belong to the same message.
+var ErrIncompletePartial = errors.New("messagefix: incomplete partial message")
+
+// Partial identifies a fragment of a message split into message/partial messages
+// (RFC 2046 section 5.2.2).
+type Partial struct {
+	// ID is the identifier of the original message, shared by all its fragments.
+	ID string
+	// Number is the 1-based number of the fragment.
+	Number int
+	// Total is the count of fragments of the original message, or 0 if it is not declared by
+	// this fragment, which is only required of the last one.
+	Total int
+}
+
+// parsePartial returns the fragment declared by the value of a Content-Type field, or false
+// if it is not a valid message/partial Content-Type.
+func parsePartial(value string) (Partial, bool) {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		mediaType, params = parseContentType(value)
+	}
+	if mediaType != "message/partial" || params["id"] == "" {
+		return Partial{}, false
+	}
+	p := Partial{ID: params["id"]}
+	if p.Number, err = strconv.Atoi(params["number"]); err != nil || p.Number < 1 {
+		return Partial{}, false
+	}
+	if total, ok := params["total"]; ok {
+		if p.Total, err = strconv.Atoi(total); err != nil || p.Total < p.Number {
+			return Partial{}, false
+		}
+	}
+	return p, true
+}
+
+// ParsePartial returns the fragment identification of a message/partial message, or false
+// if the message is not a fragment.
+func ParsePartial(message []byte) (Partial, bool) {
+	header, _ := splitMessage(message)
+	for _, f := range rawFields(header, nil) {
+		if f.name == "content-type" {
+			return parsePartial(rawValue(f))
+		}
+	}
+	return Partial{}, false
+}
+
+// Reassemble returns the original message from all its message/partial fragments, in any
+// order, usually fixed by a Reader beforehand.
+//
+// The header of the original message is made of the fields of the header of the first
+// fragment, except its Content-*, Subject, Message-ID, Encrypted and MIME-Version fields,
+// which are taken from the header of the original message encapsulated in the first fragment.
+// Its body is the concatenation of the bodies of the fragments. The original message can be
+// broken as well, and fixed with a Reader.
+func Reassemble(fragments [][]byte) ([]byte, error) {
+	if len(fragments) == 0 {
+		return nil, fmt.Errorf("%w: no fragments", ErrIncompletePartial)
+	}
+	bodies := make([][]byte, len(fragments))
+	seen := make([]bool, len(fragments))
+	var first []string
+	id, total := "", 0
+	for _, b := range fragments {
+		header, body := splitMessage(b)
+		p, ok := ParsePartial(b)
+		if !ok {
+			return nil, fmt.Errorf("%w: message is not a message/partial fragment", ErrIncompletePartial)
+		}
+		if id == "" {
+			id = p.ID
+		} else if p.ID != id {
+			return nil, fmt.Errorf("%w: fragments of different messages %q and %q", ErrIncompletePartial, id, p.ID)
+		}
+		if p.Total > 0 {
+			if total > 0 && p.Total != total {
+				return nil, fmt.Errorf("%w: conflicting fragment counts %d and %d", ErrIncompletePartial, total, p.Total)
+			}
+			total = p.Total
+		}
+		if p.Number > len(fragments) {
+			return nil, fmt.Errorf("%w: fragment %d of %d fragments", ErrIncompletePartial, p.Number, len(fragments))
+		}
+		if seen[p.Number-1] {
+			return nil, fmt.Errorf("%w: duplicate fragment %d", ErrIncompletePartial, p.Number)
+		}
+		seen[p.Number-1] = true
+		bodies[p.Number-1] = body
+		if p.Number == 1 {
+			first = header
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: no fragment declares the count of fragments", ErrIncompletePartial)
+	} else if total != len(fragments) {
+		return nil, fmt.Errorf("%w: %d fragments of %d", ErrIncompletePartial, len(fragments), total)
+	}
+
+	encapsulated, body := splitMessage(bodies[0])
+	var b bytes.Buffer
+	for _, f := range rawFields(first, nil) {
+		if !encapsulatedField(f.name) {
+			writeRawField(&b, f)
+		}
+	}
+	for _, f := range rawFields(encapsulated, nil) {
+		if encapsulatedField(f.name) {
+			writeRawField(&b, f)
+		}
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	for _, body := range bodies[1:] {
+		b.Write(body)
+	}
+	return b.Bytes(), nil
+}
+
+// encapsulatedField reports whether the header field of the passed name, in lower case, of a
+// message reassembled from message/partial fragments comes from the encapsulated header.
+func encapsulatedField(name string) bool {
+	switch name {
+	case "subject", "message-id", "encrypted", "mime-version":
+		return true
+	}
+	return strings.HasPrefix(name, "content-")
+}
+
+func writeRawField(b *bytes.Buffer, f rawField) {
+	for _, line := range f.lines {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+}
+
+// rawValue returns the unfolded field body of f.
+func rawValue(f rawField) string {
+	_, value, _ := strings.Cut(strings.Join(f.lines, ""), ":")
+	return strings.TrimSpace(value)
+}
+
+// splitMessage returns the header lines of a message, without their line terminators, and
+// its body, after the empty line ending the header block.
+func splitMessage(message []byte) (header []string, body []byte) {
+	for len(message) > 0 {
+		line := message
+		i := bytes.IndexByte(message, '\n')
+		if i >= 0 {
+			line, message = message[:i], message[i+1:]
+		} else {
+			message = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			return header, message
+		}
+		header = append(header, string(line))
+	}
+	return header, nil
+}
@@ -0,0 +1,95 @@
+package messagefix
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// scanLines is the split function of the input scanner. It returns the chunk of complete lines
+// at the start of data when they are all passed through, as reported by passesChunks, so that
+// the body of large parts without body fixes is not split into lines, and the next line
+// otherwise, as bufio.ScanLines, recording its line terminator.
+func (r *Reader) scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	r.chunked = false
+	if r.passesChunks() {
+		if n := r.chunkSize(data); n > 0 {
+			r.chunked = true
+			return n, data[:n], nil
+		}
+	}
+	advance, token, err = bufio.ScanLines(data, atEOF)
+	if token == nil || !r.opts.preserveLineEnds {
+		return
+	}
+	switch advance - len(token) {
+	case 2:
+		r.end = "\r\n"
+	case 1:
+		if data[len(token)] == '\n' {
+			r.end = "\n"
+		} else {
+			// a CR ending the input
+			r.end = "\r"
+		}
+	default:
+		r.end = ""
+	}
+	return
+}
+
+// passesChunks reports whether the next input lines are passed through, up to the next line
+// that could be a delimiter line.
+func (r *Reader) passesChunks() bool {
+	return !r.empty && !r.opts.dotUnstuffing && r.passesBody()
+}
+
+// chunkSize returns the size of the chunk of complete lines at the start of data that cannot
+// be delimiter lines, or 0.
+func (r *Reader) chunkSize(data []byte) int {
+	n := bytes.LastIndexByte(data, '\n') + 1
+	if n == 0 || len(r.delimiters) == 0 {
+		return n
+	}
+	// any line starting with "--" may be a delimiter line
+	for i := 0; i < n; {
+		j := bytes.Index(data[i:n], []byte("--"))
+		if j < 0 {
+			break
+		}
+		i += j
+		if i == 0 || data[i-1] == '\n' {
+			return i
+		}
+		i++
+	}
+	return n
+}
+
+// passChunk outputs a chunk of input lines passed through.
+func (r *Reader) passChunk(b []byte) {
+	r.part.written = true
+	lines := bytes.Count(b, []byte("\n"))
+	if r.dkim == nil && (r.opts.preserveLineEnds || bytes.Count(b, []byte("\r\n")) == lines) {
+		r.line += lines
+		r.buffer = append(r.buffer, b...)
+		return
+	}
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		line, end := b[:i], b[i:i+1]
+		if i > 0 && line[i-1] == '\r' {
+			line, end = line[:i-1], b[i-1:i+1]
+		}
+		r.line++
+		if r.dkim != nil {
+			r.dkim.input(line, r.line)
+		}
+		r.buffer = append(r.buffer, line...)
+		if r.opts.preserveLineEnds {
+			r.buffer = append(r.buffer, end...)
+		} else {
+			r.buffer = append(r.buffer, "\r\n"...)
+		}
+		b = b[i+1:]
+	}
+}
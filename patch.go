@@ -0,0 +1,283 @@
+package messagefix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrInvalidPatch is returned when a patch is malformed or does not apply to a message.
+var ErrInvalidPatch = errors.New("messagefix: invalid patch")
+
+// patchMagic starts patches, identifying their format version.
+const patchMagic = "MFP1"
+
+// patchWindow is the count of lines past a difference searched for the next common line.
+const patchWindow = 64
+
+// FixWithPatch fixes the message read from r, and returns the fixed message along with a
+// patch from the original message to the fixed message.
+//
+// This is useful for stores that must keep the original messages but serve the fixed
+// messages: only one of them needs to be stored, along with the patch, which is usually small
+// since fixes are local. ApplyPatch then returns the fixed message from the original one, and
+// RevertPatch the original message from the fixed one.
+func FixWithPatch(r io.Reader, opts ...Option) (fixed, patch []byte, err error) {
+	original, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	fixed, err = io.ReadAll(NewReader(bytes.NewReader(original), opts...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return fixed, Diff(original, fixed), nil
+}
+
+// Diff returns a patch from an original message to its fixed form, for ApplyPatch and
+// RevertPatch.
+//
+// The patch is made of the line terminators of both messages, which are stored compactly,
+// and of the lines that differ, past their terminator.
+func Diff(original, fixed []byte) []byte {
+	a, aTerms := splitLines(original)
+	b, bTerms := splitLines(fixed)
+	p := []byte(patchMagic)
+	p = appendRuns(p, aTerms)
+	p = appendRuns(p, bTerms)
+	i, j := 0, 0
+	for {
+		n := 0
+		for i < len(a) && j < len(b) && bytes.Equal(a[i], b[j]) {
+			i++
+			j++
+			n++
+		}
+		x, y := resync(a[i:], b[j:])
+		p = binary.AppendUvarint(p, uint64(n))
+		p = appendLines(p, a[i:i+x])
+		p = appendLines(p, b[j:j+y])
+		i += x
+		j += y
+		if i == len(a) && j == len(b) {
+			return p
+		}
+	}
+}
+
+// ApplyPatch returns the fixed message from the original message and the patch returned by
+// FixWithPatch or Diff.
+func ApplyPatch(original, patch []byte) ([]byte, error) {
+	return applyPatch(original, patch, false)
+}
+
+// RevertPatch returns the original message from the fixed message and the patch returned by
+// FixWithPatch or Diff.
+func RevertPatch(fixed, patch []byte) ([]byte, error) {
+	return applyPatch(fixed, patch, true)
+}
+
+func applyPatch(src, patch []byte, revert bool) ([]byte, error) {
+	if !bytes.HasPrefix(patch, []byte(patchMagic)) {
+		return nil, ErrInvalidPatch
+	}
+	p := patchReader{b: patch[len(patchMagic):]}
+	srcTerms, dstTerms := p.runs(), p.runs()
+	if revert {
+		srcTerms, dstTerms = dstTerms, srcTerms
+	}
+	lines, terms := splitLines(src)
+	if p.err != nil || !equalRuns(terms, srcTerms) {
+		return nil, ErrInvalidPatch
+	}
+	var out [][]byte
+	i := 0
+	for len(p.b) > 0 && p.err == nil {
+		n := int(p.uvarint())
+		removed, added := p.lines(), p.lines()
+		if revert {
+			removed, added = added, removed
+		}
+		if n < 0 || n > len(lines)-i {
+			return nil, ErrInvalidPatch
+		}
+		out = append(out, lines[i:i+n]...)
+		i += n
+		for _, line := range removed {
+			if i >= len(lines) || !bytes.Equal(lines[i], line) {
+				return nil, ErrInvalidPatch
+			}
+			i++
+		}
+		out = append(out, added...)
+	}
+	if p.err != nil || i != len(lines) {
+		return nil, ErrInvalidPatch
+	}
+	return joinLines(out, dstTerms)
+}
+
+// resync returns the count of lines of a and b before their next common line, or the count of
+// all their lines if one of them is empty.
+func resync(a, b [][]byte) (x, y int) {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a), len(b)
+	}
+	for k := 1; k <= patchWindow; k++ {
+		for x := 0; x <= k; x++ {
+			y := k - x
+			if x < len(a) && y < len(b) && bytes.Equal(a[x], b[y]) {
+				return x, y
+			}
+		}
+	}
+	return 1, 1
+}
+
+// Line terminators.
+const (
+	termNone byte = iota
+	termLF
+	termCRLF
+)
+
+// run is a run of lines with the same terminator.
+type run struct {
+	term byte
+	n    int
+}
+
+// splitLines returns the lines of b without their terminator, and the runs of their
+// terminators.
+func splitLines(b []byte) (lines [][]byte, terms []run) {
+	for len(b) > 0 {
+		line, term := b, termNone
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, term = b[:i], termLF
+			if i > 0 && b[i-1] == '\r' {
+				line, term = b[:i-1], termCRLF
+			}
+			b = b[i+1:]
+		} else {
+			b = nil
+		}
+		lines = append(lines, line)
+		if len(terms) > 0 && terms[len(terms)-1].term == term {
+			terms[len(terms)-1].n++
+		} else {
+			terms = append(terms, run{term, 1})
+		}
+	}
+	return lines, terms
+}
+
+// joinLines returns the lines terminated by the terminators of the runs.
+func joinLines(lines [][]byte, terms []run) ([]byte, error) {
+	var out []byte
+	i := 0
+	for _, t := range terms {
+		if t.n > len(lines)-i || t.n < 0 {
+			return nil, ErrInvalidPatch
+		}
+		for _, line := range lines[i : i+t.n] {
+			out = append(out, line...)
+			switch t.term {
+			case termLF:
+				out = append(out, '\n')
+			case termCRLF:
+				out = append(out, "\r\n"...)
+			}
+		}
+		i += t.n
+	}
+	if i != len(lines) {
+		return nil, ErrInvalidPatch
+	}
+	return out, nil
+}
+
+func equalRuns(a, b []run) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func appendRuns(p []byte, terms []run) []byte {
+	p = binary.AppendUvarint(p, uint64(len(terms)))
+	for _, t := range terms {
+		p = append(p, t.term)
+		p = binary.AppendUvarint(p, uint64(t.n))
+	}
+	return p
+}
+
+func appendLines(p []byte, lines [][]byte) []byte {
+	p = binary.AppendUvarint(p, uint64(len(lines)))
+	for _, line := range lines {
+		p = binary.AppendUvarint(p, uint64(len(line)))
+		p = append(p, line...)
+	}
+	return p
+}
+
+// patchReader reads the fields of a patch, setting err if it is malformed.
+type patchReader struct {
+	b   []byte
+	err error
+}
+
+func (p *patchReader) uvarint() uint64 {
+	v, n := binary.Uvarint(p.b)
+	if n <= 0 {
+		p.err = ErrInvalidPatch
+		p.b = nil
+		return 0
+	}
+	p.b = p.b[n:]
+	return v
+}
+
+func (p *patchReader) runs() []run {
+	n := p.uvarint()
+	if n > uint64(len(p.b)) {
+		p.err = ErrInvalidPatch
+		return nil
+	}
+	terms := make([]run, 0, n)
+	for i := uint64(0); i < n && p.err == nil; i++ {
+		if len(p.b) == 0 {
+			p.err = ErrInvalidPatch
+			break
+		}
+		term := p.b[0]
+		p.b = p.b[1:]
+		terms = append(terms, run{term, int(p.uvarint())})
+	}
+	return terms
+}
+
+func (p *patchReader) lines() [][]byte {
+	n := p.uvarint()
+	if n > uint64(len(p.b)) {
+		p.err = ErrInvalidPatch
+		return nil
+	}
+	lines := make([][]byte, 0, n)
+	for i := uint64(0); i < n && p.err == nil; i++ {
+		size := p.uvarint()
+		if size > uint64(len(p.b)) {
+			p.err = ErrInvalidPatch
+			break
+		}
+		lines = append(lines, p.b[:size])
+		p.b = p.b[size:]
+	}
+	return lines
+}
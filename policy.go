@@ -0,0 +1,32 @@
+package messagefix
+
+// WithPolicy sets a function choosing additional options for each message from its header,
+// for example to apply aggressive fixes only to the senders known to need them, from their
+// From domain or X-Mailer field, in the same streaming pass.
+//
+// policy is called once, with the message header before it is fixed, and the options it
+// returns are applied on top of the options of the Reader for the rest of the message. Options
+// applying to the input read before the message header, such as WithBufferSize,
+// WithReadSize, WithDotUnstuffing, WithEmptyMode or WithStripFromLine, have no effect, and
+// WithPolicy is ignored. WithDKIM, WithSigner and the MessageSize of WithLimits, which are
+// set up when the Reader starts reading, have no effect either; they must be passed to the
+// Reader itself.
+func WithPolicy(policy func(h *Header) []Option) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// applyPolicy applies the options returned by the policy for the message header.
+func (r *Reader) applyPolicy(h *Header) {
+	opts := r.opts.policy(h)
+	r.opts.policy = nil
+	if len(opts) == 0 {
+		return
+	}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	r.opts.policy = nil
+	r.configure()
+}
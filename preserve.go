@@ -0,0 +1,101 @@
+package messagefix
+
+import (
+	"bytes"
+)
+
+// WithPreserveLineEndings enables keeping the line terminators of the input lines output as
+// read, such as LF line terminators, instead of terminating all lines with CRLF, so that a
+// message that needs no fix is output byte for byte, and only the lines changed by fixes
+// differ from the input. The last line of a message without a line terminator is kept
+// without one.
+//
+// The lines changed, added or held back by fixes are terminated with CRLF, as well as the
+// lines of part bodies that are decoded or rewritten as a whole, such as with WithBinary.
+// WithParallelism has no effect with WithPreserveLineEndings.
+func WithPreserveLineEndings(enabled bool) Option {
+	return func(o *options) {
+		o.preserveLineEnds = enabled
+	}
+}
+
+// inputLine is a line of the input, along with its line terminator.
+type inputLine struct {
+	line, end string
+}
+
+// terminator returns the line terminator output after line: the terminator of the input line
+// being processed with WithPreserveLineEndings if line is output as read, or CRLF.
+func (r *Reader) terminator(line string) string {
+	if r.opts.preserveLineEnds && line == r.current {
+		return r.end
+	}
+	return "\r\n"
+}
+
+// endLine appends a line terminator to the output, after the bytes of a line. A line without
+// a full CRLF or LF terminator is completed with CRLF if any line is output after it.
+func (r *Reader) endLine(end string) {
+	r.buffer = append(r.buffer, end...)
+	if end == "" || end == "\r" {
+		r.unterminated = "\r\n"[len(end):]
+	}
+}
+
+// startLine completes the terminator of the last output line, if any, before another line is
+// output.
+func (r *Reader) startLine() {
+	if r.unterminated != "" {
+		r.buffer = append(r.buffer, r.unterminated...)
+		r.unterminated = ""
+	}
+}
+
+// recordEnd records the line terminator of a line read into a header field, so that it is
+// kept when the field is output as read.
+func (r *Reader) recordEnd(f *field, line string) {
+	if r.opts.preserveLineEnds {
+		f.input = append(f.input, inputLine{line, r.terminator(line)})
+	}
+}
+
+// delimiterEnd returns the size of the line terminator preceding a delimiter line, which
+// belongs to the delimiter.
+func (r *Reader) delimiterEnd() int {
+	if !r.opts.preserveLineEnds {
+		return 2
+	}
+	switch t := r.tail(); {
+	case r.unterminated != "":
+		// the line terminator is only output along with the delimiter line
+		return 0
+	case bytes.HasSuffix(t, []byte("\r\n")):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// terminatePreserved is terminate with WithPreserveLineEndings: the last line is kept
+// without a line terminator if it has none in the input, and lines terminated with LF end the
+// message as well as lines terminated with CRLF.
+func (r *Reader) terminatePreserved() {
+	t := r.tail()
+	if r.unterminated == "" && len(t) > 0 && t[len(t)-1] != '\n' {
+		// the last line was decoded without a line terminator
+		r.buffer = append(r.buffer, "\r\n"...)
+		t = appendTail(t, []byte("\r\n"))
+	}
+	if !r.opts.finalEmptyLine {
+		return
+	}
+	if r.unterminated == "" {
+		last := bytes.TrimSuffix(bytes.TrimSuffix(t, []byte("\n")), []byte("\r"))
+		if len(last) == 0 || last[len(last)-1] == '\n' {
+			return
+		}
+	}
+	// fix: end the message with an empty line
+	r.fixed(FixFinalEmptyLine)
+	r.emit("")
+}
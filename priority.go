@@ -0,0 +1,134 @@
+package messagefix
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PriorityForm is the set of header fields used to express the priority of a message.
+type PriorityForm int
+
+const (
+	// PriorityKeep keeps the priority header fields as is. This is the default.
+	PriorityKeep PriorityForm = iota
+	// PriorityXPriority expresses the priority with an X-Priority field only.
+	PriorityXPriority
+	// PriorityImportance expresses the priority with an Importance field only.
+	PriorityImportance
+	// PriorityAll expresses the priority with consistent X-Priority, Importance and Priority fields.
+	PriorityAll
+)
+
+var xPriorityNames = [...]string{"Highest", "High", "Normal", "Low", "Lowest"}
+
+// parsePriority returns the priority of a priority field, from 1 (highest) to 5 (lowest),
+// or 0 if it cannot be parsed.
+func parsePriority(f *field) int {
+	value := strings.ToLower(f.value())
+	switch {
+	case f.is("X-Priority"):
+		if i := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+			value = value[:i]
+		}
+		if level, err := strconv.Atoi(value); err == nil && level >= 1 && level <= 5 {
+			return level
+		}
+	case f.is("Importance"):
+		switch value {
+		case "high":
+			return 1
+		case "normal":
+			return 3
+		case "low":
+			return 5
+		}
+	case f.is("Priority"):
+		switch value {
+		case "urgent":
+			return 1
+		case "normal":
+			return 3
+		case "non-urgent":
+			return 5
+		}
+	}
+	return 0
+}
+
+// priorityFields returns the fields expressing a priority in the passed form.
+func priorityFields(form PriorityForm, level int) []*field {
+	var fields []*field
+	if form == PriorityXPriority || form == PriorityAll {
+		fields = append(fields, newField("X-Priority: "+strconv.Itoa(level)+" ("+xPriorityNames[level-1]+")"))
+	}
+	if form == PriorityImportance || form == PriorityAll {
+		fields = append(fields, newField("Importance: "+[...]string{"high", "high", "normal", "low", "low"}[level-1]))
+	}
+	if form == PriorityAll {
+		fields = append(fields, newField("Priority: "+[...]string{"urgent", "urgent", "normal", "non-urgent", "non-urgent"}[level-1]))
+	}
+	return fields
+}
+
+// normalizePriority rewrites the priority fields of the header to the passed form. On
+// conflicting values, X-Priority takes precedence over Importance, which takes
+// precedence over Priority. It reports whether the header was fixed.
+func normalizePriority(header []*field, form PriorityForm) ([]*field, bool) {
+	var levels [3]int
+	first := -1
+	for i, f := range header {
+		for j, name := range []string{"X-Priority", "Importance", "Priority"} {
+			if !f.is(name) {
+				continue
+			}
+			if first < 0 {
+				first = i
+			}
+			if level := parsePriority(f); level != 0 && levels[j] == 0 {
+				levels[j] = level
+			}
+		}
+	}
+	level := 0
+	for _, l := range levels {
+		if l != 0 {
+			level = l
+			break
+		}
+	}
+	if level == 0 {
+		return header, false
+	}
+	fields := priorityFields(form, level)
+	// keep the header as is if it already is in the passed form
+	var existing []*field
+	for _, f := range header {
+		if f.is("X-Priority") || f.is("Importance") || f.is("Priority") {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == len(fields) {
+		same := true
+		for i, f := range existing {
+			if !f.is(fields[i].name) || parsePriority(f) != parsePriority(fields[i]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return header, false
+		}
+	}
+	// fix: reconcile the priority fields
+	var out []*field
+	for i, f := range header {
+		if i == first {
+			out = append(out, fields...)
+		}
+		if f.is("X-Priority") || f.is("Importance") || f.is("Priority") {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out, true
+}
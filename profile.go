@@ -0,0 +1,378 @@
+package messagefix
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Profile is a set of fixes targeting a specific consumer of the fixed messages, with an
+// explicit compatibility contract.
+type Profile int
+
+const (
+	// ProfileDefault applies no fixes besides the ones enabled by other options. This is
+	// the default.
+	ProfileDefault Profile = iota
+	// ProfileStdlib makes the output parse without error with the standard library: the
+	// message with net/mail.ReadMessage, and each multipart entity, recursively, with
+	// mime.ParseMediaType and mime/multipart.Reader. The Reader returns an error matching
+	// ErrUnfixable if the fixed header block of the message still cannot be parsed.
+	ProfileStdlib
+	// ProfileGoMessage makes the output parse without error with github.com/emersion/go-message,
+	// without a charset reader: it extends ProfileStdlib with WithNormalizeCharsets,
+	// WithTranscode, WithFixQuotedPrintable, WithFixBase64Padding, WithFixMisplacedParams and
+	// WithFixContainerEncoding.
+	ProfileGoMessage
+	// ProfileDovecot makes the output suitable for storage in Dovecot, so that FETCH BINARY
+	// does not fail with UNKNOWN-CTE: it extends ProfileStdlib with WithFixQuotedPrintable,
+	// WithFixBase64Padding, WithFixMisplacedParams, WithEncodingMismatch(EncodingMismatch8Bit)
+	// and WithStripFromLine.
+	ProfileDovecot
+	// ProfileGmailImport makes the output suitable for importing into Gmail, which rejects
+	// empty messages: it extends ProfileStdlib with WithNormalizeCharsets, WithFixQuotedPrintable,
+	// WithFixBase64Padding, WithEncodingMismatch(EncodingMismatch8Bit), WithStripFromLine,
+	// WithEmptyMode(EmptySynthesize) and WithHeaderless(HeaderlessSynthesize).
+	ProfileGmailImport
+	// ProfileArchive produces a canonical form of messages for long-term archives, so that
+	// each message has a single consistent representation. In the canonical form of version
+	// ArchiveVersion, on top of the guarantees of ProfileStdlib:
+	//   - a leading mbox From_ line is removed;
+	//   - header field names have their canonical form, and header lines longer than 78
+	//     characters are folded at whitespace;
+	//   - charset labels have their IANA name, and text parts are transcoded to UTF-8, with
+	//     invalid UTF-8 replaced with U+FFFD;
+	//   - quoted-printable and base64 bodies are repaired, and 7bit parts containing 8-bit bytes
+	//     are declared as 8bit;
+	//   - parameters misplaced on fields that take none are moved to Content-Type, and invalid
+	//     encodings of multiparts and of embedded messages that are not actually encoded are
+	//     removed;
+	//   - leaf parts whose media type is neither text nor message, such as attachments, are
+	//     encoded as base64 in lines of 76 characters.
+	//
+	// It extends ProfileStdlib with WithStripFromLine, WithCanonicalKeys, WithFoldHeaders(78),
+	// WithNormalizeCharsets, WithTranscode, WithReplaceInvalidUTF8("\uFFFD"),
+	// WithFixQuotedPrintable, WithFixBase64Padding, WithEncodingMismatch(EncodingMismatch8Bit),
+	// WithFixMisplacedParams, WithFixContainerEncoding and WithEncodeAttachments.
+	ProfileArchive
+	// ProfileArchive1 produces the canonical form of version 1 of ProfileArchive, so that
+	// messages archived in that form can still be checked against it. It is ProfileArchive,
+	// except that the header field names given their usual form in version 2, such as
+	// BIMI-Selector, keep the form of textproto.CanonicalMIMEHeaderKey, such as Bimi-Selector,
+	// and that embedded messages declared as base64 or quoted-printable keep their encoding.
+	ProfileArchive1
+)
+
+// options returns the options enabled by the profile, besides the strict structure fixes.
+func (p Profile) options() []Option {
+	switch p {
+	case ProfileGoMessage:
+		return []Option{
+			WithNormalizeCharsets(true),
+			WithTranscode(true),
+			WithFixQuotedPrintable(true),
+			WithFixBase64Padding(true),
+			WithFixMisplacedParams(true),
+			WithFixContainerEncoding(true),
+		}
+	case ProfileDovecot:
+		return []Option{
+			WithFixQuotedPrintable(true),
+			WithFixBase64Padding(true),
+			WithFixMisplacedParams(true),
+			WithEncodingMismatch(EncodingMismatch8Bit),
+			WithStripFromLine(true),
+		}
+	case ProfileGmailImport:
+		return []Option{
+			WithNormalizeCharsets(true),
+			WithFixQuotedPrintable(true),
+			WithFixBase64Padding(true),
+			WithEncodingMismatch(EncodingMismatch8Bit),
+			WithStripFromLine(true),
+			WithEmptyMode(EmptySynthesize),
+			WithHeaderless(HeaderlessSynthesize),
+		}
+	case ProfileArchive, ProfileArchive1:
+		return []Option{
+			WithStripFromLine(true),
+			WithCanonicalKeys(true),
+			WithFoldHeaders(78),
+			WithNormalizeCharsets(true),
+			WithTranscode(true),
+			WithReplaceInvalidUTF8("\uFFFD"),
+			WithFixQuotedPrintable(true),
+			WithFixBase64Padding(true),
+			WithEncodingMismatch(EncodingMismatch8Bit),
+			WithFixMisplacedParams(true),
+			WithFixContainerEncoding(true),
+			WithEncodeAttachments(true),
+		}
+	default:
+		return nil
+	}
+}
+
+// WithProfile enables the fixes of a profile, on top of the fixes enabled by other options.
+//
+// The options bundled by the profile are applied at the position of WithProfile, so that
+// options passed after it override them.
+func WithProfile(profile Profile) Option {
+	return func(o *options) {
+		o.strict = profile != ProfileDefault
+		o.archive1 = profile == ProfileArchive1
+		for _, opt := range profile.options() {
+			opt(o)
+		}
+	}
+}
+
+// Preset is a level of fixes, for users who do not need to pick individual fixes.
+type Preset int
+
+const (
+	// PresetLenient only applies the fixes that are always applied, such as closing the
+	// multiparts still open at EOF, and disables all other fixes. This is the default.
+	PresetLenient Preset = iota
+	// PresetStandard also repairs encodings without changing the content of the message:
+	// it enables WithFixQuotedPrintable, WithFixBase64Padding, WithFixMisplacedParams,
+	// WithFixContainerEncoding, WithNormalizeCharsets, WithEncodingMismatch(EncodingMismatch8Bit)
+	// and WithStripFromLine.
+	PresetStandard
+	// PresetAggressive also rewrites the message so that as many consumers as possible accept
+	// it: it extends PresetStandard with ProfileStdlib, WithTranscode,
+	// WithReplaceInvalidUTF8("\uFFFD"), WithCanonicalKeys, WithTruncateMailLoops,
+	// WithUnescapeFrom, WithDecodeMultiparts, WithEmptyMode(EmptySynthesize) and
+	// WithHeaderless(HeaderlessSynthesize).
+	PresetAggressive
+)
+
+// WithPreset sets the fixes to the ones of a preset.
+//
+// The preset is applied at the position of WithPreset, so that options passed after it
+// override it, and it overrides the options passed before it.
+func WithPreset(preset Preset) Option {
+	return func(o *options) {
+		standard := preset >= PresetStandard
+		aggressive := preset >= PresetAggressive
+		o.fixQuotedPrintable = standard
+		o.fixBase64Padding = standard
+		o.fixMisplacedParams = standard
+		o.fixContainerEncoding = standard
+		o.normalizeCharsets = standard
+		o.stripFromLine = standard
+		o.encodingMismatch = EncodingMismatchIgnore
+		if standard {
+			o.encodingMismatch = EncodingMismatch8Bit
+		}
+		o.strict = aggressive
+		o.transcode = aggressive
+		o.replaceUTF8 = aggressive
+		if aggressive {
+			o.utf8Replacement = "\uFFFD"
+		}
+		o.canonicalKeys = aggressive
+		o.truncateMailLoops = aggressive
+		o.unescapeFrom = aggressive
+		o.decodeMultiparts = aggressive
+		o.emptyMode = EmptyPassThrough
+		o.headerless = HeaderlessIgnore
+		if aggressive {
+			o.emptyMode = EmptySynthesize
+			o.headerless = HeaderlessSynthesize
+		}
+	}
+}
+
+// fixContentType rewrites a Content-Type field that mime.ParseMediaType rejects, from its
+// tolerant parse, such as one with an unquoted boundary containing special characters or
+// with duplicate parameters. Fields that have no valid media type are removed, leaving
+// any next Content-Type field to be fixed in turn. It reports whether the header was fixed.
+func fixContentType(header []*field, smtputf8 bool) ([]*field, bool) {
+	for fixed := false; ; fixed = true {
+		f := lookup(header, "Content-Type")
+		if f == nil {
+			return header, fixed
+		}
+		value := f.value()
+		if _, _, err := mime.ParseMediaType(value); err == nil {
+			return header, fixed
+		}
+		mediaType, params := parseContentType(value)
+		for key, value := range params {
+			if !isToken(key) || (key == "boundary" && !isPrintable(value)) {
+				delete(params, key)
+			}
+		}
+		// fix: rewrite the Content-Type field in a standard form
+		if s := formatMediaType(mediaType, params, smtputf8); s != "" {
+			f.setValue(s)
+			return header, true
+		}
+		header = removeField(header, f)
+	}
+}
+
+// fixFieldNames rewrites the field names that net/textproto rejects, stripping any whitespace
+// before the colon and replacing invalid characters with dashes. Fields with an empty name
+// are removed. It reports whether the header was fixed.
+func fixFieldNames(header []*field) ([]*field, bool) {
+	fixed := header[:0]
+	changed := false
+	for _, f := range header {
+		name := strings.TrimRight(f.name, " \t")
+		if name == "" {
+			// fix: remove fields with an empty name
+			changed = true
+			continue
+		}
+		if name == f.name && strings.IndexFunc(name, notFieldNameChar) < 0 {
+			fixed = append(fixed, f)
+			continue
+		}
+		// fix: make the field name a valid token
+		changed = true
+		name = strings.Map(func(r rune) rune {
+			if notFieldNameChar(r) {
+				return '-'
+			}
+			return r
+		}, name)
+		f.lines[0] = name + f.lines[0][len(f.name):]
+		f.name = name
+		fixed = append(fixed, f)
+	}
+	return fixed, changed
+}
+
+// removeField returns header without f.
+func removeField(header []*field, f *field) []*field {
+	for i, hf := range header {
+		if hf == f {
+			return append(header[:i], header[i+1:]...)
+		}
+	}
+	return header
+}
+
+// collides reports whether the delimiters of boundary and of an open boundary cannot be told
+// apart by mime/multipart.Reader.
+func (r *Reader) collides(boundary string) bool {
+	delimiter := "--" + boundary
+	for _, open := range r.delimiters {
+		if strings.HasPrefix(delimiter, open) {
+			if _, ok := matchLenientDelimiter(delimiter, open); ok {
+				return true
+			}
+		}
+		if strings.HasPrefix(open, delimiter) {
+			if _, ok := matchLenientDelimiter(open, delimiter); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameBoundary renames the boundary of the current multipart, which collides with the
+// boundary of an enclosing multipart, to a synthesized one.
+func (r *Reader) renameBoundary() {
+	depth := len(r.entities)
+	boundary := synthesizedBoundary(depth)
+	for n := 1; r.collides(boundary); n++ {
+		boundary = synthesizedBoundary(depth) + "_" + strconv.Itoa(n)
+	}
+	params := map[string]string{"boundary": boundary}
+	for key, value := range r.part.params {
+		if isToken(key) && key != "boundary" {
+			params[key] = value
+		}
+	}
+	value := formatMediaType(r.part.mediaType, params, r.opts.smtputf8)
+	if value == "" {
+		return
+	}
+	// fix: rename the boundary of a multipart colliding with the boundary of an enclosing one
+	r.fixed(FixBoundaryCollisions)
+	original := r.part.boundary
+	lookup(r.header, "Content-Type").setValue(value)
+	r.part = newPart(r.header, r.opts.strict)
+	r.entities[len(r.entities)-1].original = "--" + original
+}
+
+// renameDelimiter rewrites a delimiter line of a renamed boundary to a delimiter line of its
+// new name. Delimiter lines belong to the innermost multipart whose boundary in the input they
+// match.
+func (r *Reader) renameDelimiter(line string) string {
+	if len(line) < 2 || line[0] != '-' || line[1] != '-' {
+		return line
+	}
+	for i := len(r.delimiters) - 1; i >= 0; i-- {
+		original := r.entities[r.containers[i]].original
+		delimiter := r.delimiters[i]
+		if original != "" {
+			delimiter = original
+		}
+		if _, ok := r.matchesDelimiter(line, delimiter); ok {
+			if original == "" {
+				return line
+			}
+			return r.delimiters[i] + line[len(original):]
+		}
+	}
+	return line
+}
+
+// synthesizedBoundary returns the boundary declared for a multipart without one, at the
+// passed entity depth.
+func synthesizedBoundary(depth int) string {
+	return "=_messagefix_" + strconv.Itoa(depth)
+}
+
+// isToken reports whether s is a non-empty token (RFC 2045).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7F && !strings.ContainsRune(`()<>@,;:\"/[]?=`, rune(c))
+}
+
+// isPrintable reports whether s only contains printable ASCII characters.
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] >= 0x7F {
+			return false
+		}
+	}
+	return true
+}
+
+// notFieldNameChar reports whether r is not valid in a field name for net/textproto, which
+// only accepts tokens as defined by RFC 7230.
+func notFieldNameChar(r rune) bool {
+	return r >= utf8.RuneSelf || !isTokenChar(byte(r)) || r == '{' || r == '}'
+}
+
+// matchLenientDelimiter returns whether line is an open or close delimiter of delimiter as
+// recognized by mime/multipart.Reader, which matches the delimiter followed by a space or a tab
+// and any content, or by two dashes and any content.
+func matchLenientDelimiter(line, delimiter string) (closing bool, ok bool) {
+	rest := line[len(delimiter):]
+	switch {
+	case strings.HasPrefix(rest, "--"):
+		return true, true
+	case rest == "" || rest[0] == ' ' || rest[0] == '\t':
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,197 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// WithFixDoubleQuotedPrintable enables decoding one layer of the quoted-printable parts that
+// were encoded twice, such as by broken relays, whose decoded text shows escapes like "=3D" or
+// "=C3=A9" instead of the actual characters.
+//
+// A part is considered encoded twice when its first 1MiB has escapes of "=" followed by the
+// digits of an escape of an 8-bit byte or of "=", such as "=3DC3" or "=3D3D", and no other
+// escapes than those, escapes of "=" ending a line and escapes of whitespace. The body of
+// the part is held back until then.
+func WithFixDoubleQuotedPrintable(enabled bool) Option {
+	return func(o *options) {
+		o.fixDoubleQP = enabled
+	}
+}
+
+// doubleQPDecoder decodes one layer of the body of a quoted-printable part encoded twice.
+// The body lines are held back until it is known whether the part is encoded twice.
+type doubleQPDecoder struct {
+	next   lineWriter
+	report reporter
+	lines  []string
+	size   int
+	// decided is set once it is known whether the part is encoded twice, in which case
+	// double is set.
+	decided, double bool
+	dec             qpDecoder
+	buf             []byte
+}
+
+func (d *doubleQPDecoder) writeLine(line string) {
+	if d.decided {
+		d.write(line)
+		return
+	}
+	d.lines = append(d.lines, line)
+	d.size += len(line)
+	if d.size > maxHeldBody {
+		d.decide()
+	}
+}
+
+func (d *doubleQPDecoder) end(delimiter bool) {
+	if !d.decided {
+		d.decide()
+	}
+	if d.double {
+		d.dec.end(delimiter)
+		d.flush()
+		if len(d.buf) > 0 {
+			d.writeDecoded(d.buf)
+			d.buf = d.buf[:0]
+		}
+	}
+	d.next.end(delimiter)
+}
+
+// decide tells whether the part is encoded twice from the held lines, and writes them.
+func (d *doubleQPDecoder) decide() {
+	d.decided = true
+	d.double = isDoubleQP(d.lines)
+	if d.double {
+		// fix: decode a layer of a quoted-printable part encoded twice
+		d.report.fixed(FixDoubleQuotedPrintable)
+		d.dec.out = &d.buf
+	}
+	for _, line := range d.lines {
+		d.write(line)
+	}
+	d.lines = nil
+}
+
+func (d *doubleQPDecoder) write(line string) {
+	if !d.double {
+		d.next.writeLine(line)
+		return
+	}
+	d.dec.writeLine(line)
+	d.flush()
+}
+
+// flush writes the complete lines decoded so far.
+func (d *doubleQPDecoder) flush() {
+	b := d.buf
+	for {
+		i := strings.Index(string(b), "\r\n")
+		if i < 0 {
+			break
+		}
+		d.writeDecoded(b[:i])
+		b = b[i+2:]
+	}
+	d.buf = append(d.buf[:0], b...)
+}
+
+// writeDecoded writes a decoded line, escaping its trailing whitespace, which decoders
+// would remove otherwise.
+func (d *doubleQPDecoder) writeDecoded(line []byte) {
+	if n := len(line); n > 0 && (line[n-1] == ' ' || line[n-1] == '\t') {
+		d.next.writeLine(string(line[:n-1]) + qpEscape(line[n-1]))
+		return
+	}
+	d.next.writeLine(string(line))
+}
+
+// isDoubleQP reports whether quoted-printable lines are encoded twice: they have escapes of
+// the inner encoding, and no other escapes than those, inner soft line breaks and escapes of
+// whitespace.
+func isDoubleQP(lines []string) bool {
+	inner := false
+	for _, line := range lines {
+		line = trimRight(line)
+		for i := strings.IndexByte(line, '='); i >= 0; i = strings.IndexByte(line, '=') {
+			rest := line[i+1:]
+			switch {
+			case rest == "":
+				// outer soft line break
+			case strings.HasPrefix(rest, "20") || strings.HasPrefix(rest, "09"):
+			case !strings.HasPrefix(rest, "3D"):
+				return false
+			case len(rest) == 2 || len(rest) == 3 && rest[2] == '=':
+				// inner soft line break, which is not enough to tell the encoding apart from
+				// text ending with "="
+			case len(rest) >= 4 && isInnerEscape(rest[2], rest[3]):
+				inner = true
+			default:
+				return false
+			}
+			line = rest
+		}
+	}
+	return inner
+}
+
+// isInnerEscape reports whether the hexadecimal digits of the escape of an inner
+// quoted-printable encoding are uppercase, and encode "=" or an 8-bit byte.
+func isInnerEscape(a, b byte) bool {
+	if strings.IndexByte(upperHex, a) < 0 || strings.IndexByte(upperHex, b) < 0 {
+		return false
+	}
+	return a >= '8' || a == '3' && b == 'D'
+}
+
+// WithWrapQuotedPrintable enables inserting soft line breaks into the lines of
+// quoted-printable part bodies longer than the 76 characters allowed by RFC 2045, which
+// strict consumers reject. The soft line breaks are never inserted inside an escape.
+func WithWrapQuotedPrintable(enabled bool) Option {
+	return func(o *options) {
+		o.wrapQP = enabled
+	}
+}
+
+// maxQPLine is the maximum length of a quoted-printable line, including any soft line break.
+const maxQPLine = 76
+
+// qpWrapper splits long quoted-printable lines with soft line breaks.
+type qpWrapper struct {
+	next   lineWriter
+	report reporter
+}
+
+func (q qpWrapper) writeLine(line string) {
+	if len(line) <= maxQPLine {
+		q.next.writeLine(line)
+		return
+	}
+	// fix: split a long quoted-printable line with soft line breaks
+	q.report.fixed(FixQuotedPrintableLines)
+	for _, l := range wrapQP(line) {
+		q.next.writeLine(l)
+	}
+}
+
+func (q qpWrapper) end(delimiter bool) {
+	q.next.end(delimiter)
+}
+
+// wrapQP splits a quoted-printable line into lines of at most maxQPLine characters,
+// ending all of them but the last with a soft line break.
+func wrapQP(line string) []string {
+	var lines []string
+	for len(line) > maxQPLine {
+		i := maxQPLine - 1
+		if line[i-1] == '=' {
+			i--
+		} else if line[i-2] == '=' {
+			i -= 2
+		}
+		lines = append(lines, line[:i]+"=")
+		line = line[i:]
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,83 @@
+package messagefix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFixDoubleQuotedPrintable(t *testing.T) {
+	const header = "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n"
+	opts := []Option{WithFixDoubleQuotedPrintable(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "encoded twice",
+			input: header + "caf=3DC3=3DA9 a=3D3Db\r\n",
+			opts:  opts,
+			want:  header + "caf=C3=A9 a=3Db\r\n",
+			fixes: []FixID{FixDoubleQuotedPrintable},
+		},
+		{
+			name:  "encoded twice with soft line breaks",
+			input: header + "caf=3DC3=3DA9 soft=3D=\r\n\r\nbreak=20\r\n",
+			opts:  opts,
+			want:  header + "caf=C3=A9 soft=\r\nbreak=20\r\n",
+			fixes: []FixID{FixDoubleQuotedPrintable},
+		},
+		{
+			name:  "encoded twice before a delimiter",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "caf=3DC3=3DA9\r\n--b--\r\n",
+			opts:  opts,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n" + header + "caf=C3=A9\r\n--b--\r\n",
+			fixes: []FixID{FixDoubleQuotedPrintable},
+		},
+		{
+			name:  "encoded once",
+			input: header + "caf=C3=A9 a=3Db\r\n",
+			opts:  opts,
+			want:  header + "caf=C3=A9 a=3Db\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "text with escaped equal signs",
+			input: header + "a=3DC3 is not an escape =C3=A9\r\n",
+			opts:  opts,
+			want:  header + "a=3DC3 is not an escape =C3=A9\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
+
+func TestWrapQuotedPrintable(t *testing.T) {
+	const header = "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n"
+	opts := []Option{WithWrapQuotedPrintable(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "long line",
+			input: header + strings.Repeat("a", 100) + "\r\n",
+			opts:  opts,
+			want:  header + strings.Repeat("a", 75) + "=\r\n" + strings.Repeat("a", 25) + "\r\n",
+			fixes: []FixID{FixQuotedPrintableLines},
+		},
+		{
+			name:  "escape at the end of a line",
+			input: header + strings.Repeat("a", 74) + "=C3=A9" + strings.Repeat("b", 10) + "\r\n",
+			opts:  opts,
+			want:  header + strings.Repeat("a", 74) + "=\r\n=C3=A9" + strings.Repeat("b", 10) + "\r\n",
+			fixes: []FixID{FixQuotedPrintableLines},
+		},
+		{
+			name:  "escape across the end of a line",
+			input: header + strings.Repeat("a", 73) + "=C3=A9" + strings.Repeat("b", 10) + "\r\n",
+			opts:  opts,
+			want:  header + strings.Repeat("a", 73) + "=\r\n=C3=A9" + strings.Repeat("b", 10) + "\r\n",
+			fixes: []FixID{FixQuotedPrintableLines},
+		},
+		{
+			name:  "line of 76 characters",
+			input: header + strings.Repeat("a", 76) + "\r\n",
+			opts:  opts,
+			want:  header + strings.Repeat("a", 76) + "\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,147 @@
+package messagefix
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FixOrQuarantine fixes the message read from r, or returns a new quarantine message wrapping
+// it if it cannot be fixed into a valid message, so that ingestion pipelines never have to
+// drop mail. A message cannot be fixed when the Reader gives up with an error matching
+// ErrUnfixable, which it does when the fixed message cannot be parsed with
+// net/mail.ReadMessage, when it returns a *LimitError, or when it rejects a fix with a
+// *FixError.
+//
+// The quarantine message is a multipart/mixed message with a Date, a From and a Message-ID
+// field, made of a text/plain part describing cause, and of the original message attached
+// as is: as a message/rfc822 part if it has a valid header block of ASCII lines of at most
+// 998 characters, or as a base64 application/octet-stream part otherwise. Its lines end with
+// LF if WithLFOutput was passed, and its Date and Message-ID fields are synthesized with the
+// clock and the source set by WithClock and WithRandom.
+//
+// FixOrQuarantine returns the error that caused the message to be quarantined as cause, or
+// nil if the message was fixed. The message is read to memory first, so that it can be
+// attached; err is returned if r cannot be read, or if the Reader returns any other error,
+// such as the error of the context set with WithContext.
+func FixOrQuarantine(r io.Reader, opts ...Option) (msg []byte, cause error, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], func(o *options) {
+		o.checkHeader = true
+	})
+	fixed, cause := io.ReadAll(NewReader(bytes.NewReader(b), opts...))
+	if cause == nil {
+		return fixed, nil, nil
+	}
+	var limitErr *LimitError
+	var fixErr *FixError
+	if !errors.Is(cause, ErrUnfixable) && !errors.As(cause, &limitErr) && !errors.As(cause, &fixErr) {
+		return nil, nil, cause
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return quarantine(b, cause, &o), cause, nil
+}
+
+// quarantineBoundary is the boundary of quarantine messages, suffixed with a counter if the
+// original message contains its delimiter.
+const quarantineBoundary = "=_messagefix_quarantine"
+
+// quarantine returns a quarantine message wrapping the original message b, which could not
+// be fixed because of cause.
+func quarantine(b []byte, cause error, o *options) []byte {
+	crlf := "\r\n"
+	if o.lfOutput {
+		crlf = "\n"
+	}
+	boundary := quarantineBoundary
+	for n := 1; bytes.Contains(b, []byte("--"+boundary)); n++ {
+		boundary = quarantineBoundary + "_" + strconv.Itoa(n)
+	}
+
+	var buf bytes.Buffer
+	line := func(s string) {
+		buf.WriteString(s)
+		buf.WriteString(crlf)
+	}
+	line("Date: " + o.now().Format(time.RFC1123Z))
+	line("From: " + synthesizedFrom)
+	line("Subject: Quarantined message")
+	line("Message-ID: " + o.messageID())
+	line("MIME-Version: 1.0")
+	line(`Content-Type: multipart/mixed; boundary="` + boundary + `"`)
+	line("")
+	line("--" + boundary)
+	line("Content-Type: text/plain; charset=us-ascii")
+	line("")
+	line("The original message could not be fixed into a valid message, and is attached as is.")
+	line("")
+	line("Cause: " + strings.Map(func(r rune) rune {
+		if r < ' ' || r >= 0x7f {
+			return '?'
+		}
+		return r
+	}, cause.Error()))
+	line("--" + boundary)
+	if embeddable(b, crlf) {
+		line("Content-Type: message/rfc822")
+		line("Content-Disposition: attachment")
+		line("")
+		// the line terminator preceding the delimiter belongs to it
+		buf.Write(b)
+		buf.WriteString(crlf)
+	} else {
+		line("Content-Type: application/octet-stream")
+		line(`Content-Disposition: attachment; filename="original.eml"`)
+		line("Content-Transfer-Encoding: base64")
+		line("")
+		for len(b) > 0 {
+			n := base64Line
+			if n > len(b) {
+				n = len(b)
+			}
+			line(base64.StdEncoding.EncodeToString(b[:n]))
+			b = b[n:]
+		}
+	}
+	line("--" + boundary + "--")
+	return buf.Bytes()
+}
+
+// embeddable reports whether the original message b can be attached as is as a
+// message/rfc822 part: it has a valid header block, and is made of ASCII lines of at most
+// 998 characters ending with crlf.
+func embeddable(b []byte, crlf string) bool {
+	if _, err := mail.ReadMessage(bytes.NewReader(b)); err != nil {
+		return false
+	}
+	for len(b) > 0 {
+		line, rest, terminated := bytes.Cut(b, []byte("\n"))
+		if terminated && crlf == "\r\n" {
+			if len(line) == 0 || line[len(line)-1] != '\r' {
+				return false
+			}
+			line = line[:len(line)-1]
+		}
+		if len(line) > 998 {
+			return false
+		}
+		for _, c := range line {
+			if c >= 0x80 || c == 0 || c == '\r' {
+				return false
+			}
+		}
+		b = rest
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package messagefix
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFixOrQuarantine(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		input string
+		opts  []Option
+		// cause is the error the message is quarantined for, or nil if it is fixed.
+		cause error
+		// err is the error returned instead of a message.
+		err error
+	}{
+		{
+			name:  "fixed",
+			input: "Subject: test\n\nbody\n",
+		},
+		{
+			name:  "control character in a field",
+			input: "Subject: a\x01b\r\n\r\nbody\r\n",
+			cause: ErrUnfixable,
+		},
+		{
+			name:  "control character in a field stripped",
+			input: "Subject: a\x01b\r\n\r\nbody\r\n",
+			opts:  []Option{WithStripControls(" ")},
+		},
+		{
+			name:  "line too long",
+			input: "Subject: test\r\n\r\n" + strings.Repeat("a", 64) + "\r\n",
+			opts:  []Option{WithBufferSize(16, 32)},
+			cause: ErrLineTooLong,
+		},
+		{
+			name:  "limit",
+			input: "Subject: test\r\nFrom: a@example.org\r\n\r\nbody\r\n",
+			opts:  []Option{WithLimits(Limits{HeaderFields: 1})},
+			cause: ErrHeaderTooLarge,
+		},
+		{
+			name:  "context",
+			input: "Subject: test\r\n\r\nbody\r\n",
+			opts:  []Option{WithContext(canceled)},
+			err:   context.Canceled,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, cause, err := FixOrQuarantine(strings.NewReader(tc.input), tc.opts...)
+			if !errors.Is(err, tc.err) || tc.err == nil && err != nil {
+				t.Fatalf("error: %v, want %v", err, tc.err)
+			}
+			if !errors.Is(cause, tc.cause) || tc.cause == nil && cause != nil {
+				t.Fatalf("cause: %v, want %v", cause, tc.cause)
+			}
+			if tc.err != nil {
+				return
+			}
+			quarantined := strings.Contains(string(msg), "Subject: Quarantined message\r\n")
+			if quarantined != (tc.cause != nil) {
+				t.Errorf("quarantined: %v, output:\n%q", quarantined, msg)
+			}
+		})
+	}
+}
+
+func TestUnfixable(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  []Option
+	}{
+		{
+			name:  "control character in a field",
+			input: "Subject: a\x01b\r\n\r\nbody\r\n",
+			opts:  []Option{WithProfile(ProfileStdlib)},
+		},
+		{
+			name:  "empty message",
+			input: "\r\n",
+			opts:  []Option{WithEmptyMode(EmptyError)},
+		},
+		{
+			name:  "line too long",
+			input: strings.Repeat("a", 64),
+			opts:  []Option{WithBufferSize(16, 32)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := io.ReadAll(NewReader(strings.NewReader(tc.input), tc.opts...))
+			if !errors.Is(err, ErrUnfixable) {
+				t.Errorf("error: %v, want %v", err, ErrUnfixable)
+			}
+		})
+	}
+}
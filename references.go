@@ -0,0 +1,117 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// WithFixReferences enables repairing the References and In-Reply-To fields of messages,
+// which threading clients silently ignore when they are not lists of msg-ids (RFC 5322):
+//   - bare msg-ids, such as "1234@example.org", are enclosed in angle brackets;
+//   - whitespace inside angle brackets is removed;
+//   - commas, comments and free text between msg-ids, such as "Your message of ...", are
+//     removed.
+//
+// Repaired fields are folded at 78 characters, between msg-ids. Fields without any msg-id
+// are kept as is.
+func WithFixReferences(enabled bool) Option {
+	return func(o *options) {
+		o.fixReferences = enabled
+	}
+}
+
+// fixReferences rewrites the References and In-Reply-To fields of the header as lists of
+// msg-ids. It reports whether the header was fixed.
+func fixReferences(header []*field) bool {
+	fixed := false
+	for _, f := range header {
+		if !f.is("References") && !f.is("In-Reply-To") {
+			continue
+		}
+		value := f.value()
+		ids := parseMessageIDs(value)
+		if len(ids) == 0 {
+			continue
+		}
+		normalized := strings.Join(ids, " ")
+		if normalized == strings.Join(strings.Fields(value), " ") {
+			continue
+		}
+		// fix: rewrite a References or In-Reply-To field as a list of msg-ids
+		fixed = true
+		f.setValue(normalized)
+		foldField(f, 78)
+	}
+	return fixed
+}
+
+// parseMessageIDs returns the msg-ids of a References or In-Reply-To field body in angle
+// brackets, skipping comments, quoted strings and words that are not msg-ids.
+func parseMessageIDs(value string) []string {
+	var ids []string
+	for i := 0; i < len(value); {
+		switch c := value[i]; c {
+		case ' ', '\t', '\r', '\n', ',', ';':
+			i++
+		case '(':
+			n := commentLen(value[i:])
+			if n < 0 {
+				return ids
+			}
+			i += n
+		case '"':
+			n := quotedLen(value[i:])
+			if n < 0 {
+				return ids
+			}
+			i += n
+		case '<':
+			j := strings.IndexByte(value[i:], '>')
+			if j < 0 {
+				j = len(value) - i
+			}
+			if id := strings.Join(strings.Fields(value[i+1:i+j]), ""); id != "" {
+				ids = append(ids, "<"+id+">")
+			}
+			i += j + 1
+		default:
+			j := strings.IndexAny(value[i:], " \t\r\n,;(\"<")
+			if j < 0 {
+				j = len(value) - i
+			}
+			if word := strings.TrimRight(value[i:i+j], ".>"); isBareMessageID(word) {
+				ids = append(ids, "<"+word+">")
+			}
+			i += j
+		}
+	}
+	return ids
+}
+
+// quotedLen returns the length of the quoted string at the start of s, or -1 if it is not
+// closed.
+func quotedLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// isBareMessageID reports whether a word of a References or In-Reply-To field body is a
+// msg-id missing its angle brackets, with a single "@" between its left and right parts.
+func isBareMessageID(word string) bool {
+	left, right, ok := strings.Cut(word, "@")
+	if !ok || left == "" || right == "" || strings.ContainsAny(right, "@>") {
+		return false
+	}
+	for i := 0; i < len(word); i++ {
+		if c := word[i]; isControl(c) || c == ' ' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package messagefix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFixReferences(t *testing.T) {
+	opts := []Option{WithFixReferences(true)}
+	var ids []string
+	for i := 0; i < 6; i++ {
+		ids = append(ids, "<message-"+strings.Repeat("x", 10)+string(rune('a'+i))+"@example.org>")
+	}
+	runFixTests(t, []fixTest{
+		{
+			name:  "bare msg-ids",
+			input: "References: a@example.org b@example.org\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "References: <a@example.org> <b@example.org>\r\n\r\nbody\r\n",
+			fixes: []FixID{FixReferences},
+		},
+		{
+			name:  "commas and free text",
+			input: "In-Reply-To: Your message of Monday, <a@example.org> (comment)\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "In-Reply-To: <a@example.org>\r\n\r\nbody\r\n",
+			fixes: []FixID{FixReferences},
+		},
+		{
+			name:  "whitespace in angle brackets",
+			input: "References: < a@example.org >\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "References: <a@example.org>\r\n\r\nbody\r\n",
+			fixes: []FixID{FixReferences},
+		},
+		{
+			name:  "folded",
+			input: "References: " + strings.Join(ids, ",") + "\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "References: " + ids[0] + "\r\n " + ids[1] + " " + ids[2] + "\r\n " + ids[3] + " " + ids[4] + "\r\n " + ids[5] + "\r\n\r\nbody\r\n",
+			fixes: []FixID{FixReferences},
+		},
+		{
+			name:  "valid",
+			input: "References: <a@example.org>\r\n <b@example.org>\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "References: <a@example.org>\r\n <b@example.org>\r\n\r\nbody\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "without msg-ids",
+			input: "In-Reply-To: your message\r\n\r\nbody\r\n",
+			opts:  opts,
+			want:  "In-Reply-To: your message\r\n\r\nbody\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,154 @@
+package messagefix
+
+import (
+	"sort"
+)
+
+// Report describes what a Reader found in a message.
+type Report struct {
+	// Summary is the summary of the message, or nil if it was not fully read.
+	Summary *Summary
+	// Findings are the issues found in the message.
+	Findings []Finding
+	// Fixes are the counts of the fixes applied to each part of the message, except
+	// FixLineEndings, in order of the parts, then of Fixes.
+	Fixes []FixCount
+}
+
+// FixCount is the count of times a fix was applied to a part of a message.
+type FixCount struct {
+	ID FixID
+	// Path is the path of the part, such as "1.2", or "" for the message.
+	Path string
+	// Risk is the risk of the fix, as described by Fixes.
+	Risk  Risk
+	Count int
+}
+
+// Report returns a report of the message read so far.
+func (r *Reader) Report() Report {
+	return Report{
+		Summary:  r.summary,
+		Findings: r.findings,
+		Fixes:    r.fixCounts(),
+	}
+}
+
+// fixCounts returns the counts of the fixes applied to each part.
+func (r *Reader) fixCounts() []FixCount {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	if len(r.stats.parts) == 0 {
+		return nil
+	}
+	paths := make(map[string]int)
+	for _, e := range r.parts {
+		if _, ok := paths[e.path]; !ok {
+			paths[e.path] = len(paths)
+		}
+	}
+	counts := make([]FixCount, 0, len(r.stats.parts))
+	for k, n := range r.stats.parts {
+		counts = append(counts, FixCount{
+			ID:    k.id,
+			Path:  k.path,
+			Risk:  fixes[fixIndexes[k.id]].Risk,
+			Count: n,
+		})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if pi, pj := paths[counts[i].Path], paths[counts[j].Path]; pi != pj {
+			return pi < pj
+		}
+		return fixIndexes[counts[i].ID] < fixIndexes[counts[j].ID]
+	})
+	return counts
+}
+
+// FixesAtLeast returns the fix counts of the report whose risk is at least risk, for example
+// RiskHigh to review only the fixes that dropped or replaced content.
+func (r Report) FixesAtLeast(risk Risk) []FixCount {
+	var counts []FixCount
+	for _, c := range r.Fixes {
+		if c.Risk >= risk {
+			counts = append(counts, c)
+		}
+	}
+	return counts
+}
+
+// FixesByID returns the count of times each fix was applied to the message.
+func (r Report) FixesByID() map[FixID]int {
+	m := make(map[FixID]int)
+	for _, c := range r.Fixes {
+		m[c.ID] += c.Count
+	}
+	return m
+}
+
+// FixesByPath returns the count of fixes applied to each part of the message.
+func (r Report) FixesByPath() map[string]int {
+	m := make(map[string]int)
+	for _, c := range r.Fixes {
+		m[c.Path] += c.Count
+	}
+	return m
+}
+
+// FixesByRisk returns the count of fixes of each risk applied to the message.
+func (r Report) FixesByRisk() map[Risk]int {
+	m := make(map[Risk]int)
+	for _, c := range r.Fixes {
+		m[c.Risk] += c.Count
+	}
+	return m
+}
+
+// Aggregate aggregates the reports of many messages, for example to summarize a bulk
+// migration. The zero value is an empty aggregate.
+type Aggregate struct {
+	// Messages is the count of messages.
+	Messages int
+	// Fixed is the count of messages with at least one fix applied.
+	Fixed int
+	// Fixes is the count of times each fix was applied, and FixedBy the count of messages
+	// each fix was applied to.
+	Fixes   map[FixID]int
+	FixedBy map[FixID]int
+	// MaxRisk is the count of messages whose riskiest fix has each risk.
+	MaxRisk map[Risk]int
+	// Findings is the count of messages with each kind of finding.
+	Findings map[FindingKind]int
+}
+
+// Add adds the report of a message to the aggregate.
+func (a *Aggregate) Add(r Report) {
+	if a.Fixes == nil {
+		a.Fixes = make(map[FixID]int)
+		a.FixedBy = make(map[FixID]int)
+		a.MaxRisk = make(map[Risk]int)
+		a.Findings = make(map[FindingKind]int)
+	}
+	a.Messages++
+	if len(r.Fixes) > 0 {
+		a.Fixed++
+		max := RiskLow
+		for _, c := range r.Fixes {
+			if c.Risk > max {
+				max = c.Risk
+			}
+		}
+		a.MaxRisk[max]++
+	}
+	for id, n := range r.FixesByID() {
+		a.Fixes[id] += n
+		a.FixedBy[id]++
+	}
+	kinds := make(map[FindingKind]bool)
+	for _, f := range r.Findings {
+		if !kinds[f.Kind] {
+			kinds[f.Kind] = true
+			a.Findings[f.Kind]++
+		}
+	}
+}
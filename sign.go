@@ -0,0 +1,84 @@
+package messagefix
+
+import (
+	"io"
+)
+
+// Signer signs fixed messages, such as a DKIM or ARC signer, for WithSigner.
+type Signer interface {
+	// Write is passed the fixed message as it is read.
+	io.Writer
+	// Sign returns the signature header fields of the message written to the Signer, such
+	// as a DKIM-Signature field, along with their CRLF line terminators. It is called once
+	// the whole message was written.
+	Sign() ([]byte, error)
+}
+
+// WithSigner enables signing the fixed message with the Signer returned by newSigner, which is
+// called for each message, for forwarders that must fix and then re-sign messages.
+//
+// If prepend is set, the Reader buffers the whole fixed message, then outputs the signature
+// header fields returned by Sign followed by the message. Otherwise, the message is output as
+// it is fixed, and the signature header fields are returned by Reader.Signature once the
+// whole message was read. Errors returned by newSigner, Write or Sign are returned by Read.
+//
+// With WithDotStuffing, the message is signed before being dot-stuffed. The offsets of
+// Reader.Structure do not account for the prepended signature header fields.
+func WithSigner(newSigner func() (Signer, error), prepend bool) Option {
+	return func(o *options) {
+		o.newSigner = newSigner
+		o.prependSignature = prepend
+	}
+}
+
+// Signature returns the signature header fields of the message returned by the Signer of
+// WithSigner, or nil until the whole message was read.
+func (r *Reader) Signature() []byte {
+	return r.signature
+}
+
+// signedHead returns the next bytes of the fixed message, preceded by its signature header
+// fields with WithSigner and prepend.
+func (r *Reader) signedHead() ([]byte, error) {
+	if r.signer == nil || !r.opts.prependSignature {
+		return r.produce()
+	}
+	for r.signature == nil {
+		b, err := r.produce()
+		if err == io.EOF && r.signature != nil {
+			r.unsigned = append(append([]byte(nil), r.signature...), r.unsigned...)
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.unsigned = append(r.unsigned, b...)
+		r.consume(len(b))
+	}
+	if len(r.unsigned) == 0 {
+		return nil, io.EOF
+	}
+	return r.unsigned, nil
+}
+
+// skip consumes n bytes of the output returned by signedHead.
+func (r *Reader) skip(n int) {
+	if r.signer == nil || !r.opts.prependSignature {
+		r.consume(n)
+		return
+	}
+	r.unsigned = r.unsigned[n:]
+}
+
+// sign gets the signature of the message from the Signer, once the whole output was
+// consumed.
+func (r *Reader) sign() error {
+	if r.signErr != nil {
+		return r.signErr
+	}
+	signature, err := r.signer.Sign()
+	if err != nil {
+		return err
+	}
+	r.signature = append([]byte{}, signature...)
+	return nil
+}
@@ -0,0 +1,60 @@
+package messagefix
+
+import (
+	"bytes"
+	"io"
+)
+
+// fixedSize returns the size of the fixed message read from r, by fixing it and discarding
+// its output.
+func fixedSize(r io.Reader, opts ...Option) (int64, error) {
+	fr := NewReader(r, opts...)
+	var n int64
+	for {
+		b, err := fr.head()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+		n += int64(len(b))
+		fr.advance(len(b))
+	}
+}
+
+// FixSized returns a Reader fixing the message read from r, along with the size of its output,
+// for callers that need the size before streaming the fixed message, such as for IMAP APPEND.
+//
+// The size is computed by fixing the message a first time and discarding its output, which
+// costs as much as fixing it. The output of a Reader only depends on its input and options,
+// except for the synthesized Date and Message-ID fields, whose size does not depend on the
+// clock and the source set by WithClock and WithRandom.
+//
+// If r is an io.Seeker, such as an os.File, the message is read twice: once to compute the
+// size, then from the same offset by the returned Reader. Otherwise, the whole message is read
+// to memory first.
+func FixSized(r io.Reader, opts ...Option) (*Reader, int64, error) {
+	if s, ok := r.(io.ReadSeeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			n, err := fixedSize(s, opts...)
+			if err != nil {
+				return nil, 0, err
+			}
+			if _, err := s.Seek(start, io.SeekStart); err != nil {
+				return nil, 0, err
+			}
+			return NewReader(s, opts...), n, nil
+		}
+		// the Seeker does not actually support seeking, such as a pipe
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := fixedSize(bytes.NewReader(b), opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return NewReader(bytes.NewReader(b), opts...), n, nil
+}
@@ -0,0 +1,101 @@
+package messagefix
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// WithSniffContentType enables declaring the media type of messages without a Content-Type
+// field from the first lines of their body, such as mail generated by web forms, which
+// downstream clients render as garbage:
+//   - bodies starting with an HTML document, such as "<!DOCTYPE html>" or "<html>", are
+//     declared as text/html;
+//   - bodies made mostly of binary bytes, that is control characters and bytes that are not
+//     valid UTF-8, are declared as application/octet-stream, and encoded as base64;
+//   - other bodies are declared as text/plain.
+//
+// Text bodies containing valid UTF-8 are declared with a UTF-8 charset. The header block is
+// held back along with the first lines of the body, as for a LookaheadFixer; only messages
+// whose body is not declared as base64 or quoted-printable are sniffed. Messages given a
+// Content-Type field by WithDefaultContentType are not sniffed.
+func WithSniffContentType(enabled bool) Option {
+	return func(o *options) {
+		o.sniffContentType = enabled
+	}
+}
+
+// sniffs reports whether the media type of the current part is sniffed from its body.
+func (r *Reader) sniffs() bool {
+	if !r.opts.sniffContentType || len(r.entities) != 1 || r.part.signed || lookup(r.header, "Content-Type") != nil {
+		return false
+	}
+	switch r.part.encoding {
+	case "", "7bit", "8bit", "binary":
+		return true
+	}
+	return false
+}
+
+// sniff declares the media type of the message from the first lines of its body. It reports
+// whether the body is binary content, declared as base64.
+func (r *Reader) sniff(lines []string) bool {
+	mediaType, binary := sniffContentType(lines)
+	// fix: declare the media type sniffed from the body of a message without one
+	r.fixed(FixSniffContentType)
+	r.header = append(r.header, newField("Content-Type: "+mediaType))
+	if binary {
+		r.setEncoding("base64")
+	}
+	return binary
+}
+
+// htmlPrefixes are the lowercase prefixes of the bodies sniffed as HTML.
+var htmlPrefixes = []string{"<!doctype html", "<html", "<head", "<body"}
+
+// sniffContentType returns the media type of a body from its first lines, and whether it is
+// binary content.
+func sniffContentType(lines []string) (string, bool) {
+	n, bin := 0, 0
+	utf8Text := false
+	for _, line := range lines {
+		n += len(line) + 2
+		for i := 0; i < len(line); {
+			c := line[i]
+			if c < utf8.RuneSelf {
+				if isControl(c) && c != '\f' && c != 0x1b {
+					bin++
+				}
+				i++
+				continue
+			}
+			r, size := utf8.DecodeRuneInString(line[i:])
+			if r == utf8.RuneError && size == 1 {
+				bin++
+			} else {
+				utf8Text = true
+			}
+			i += size
+		}
+	}
+	if n > 0 && bin*10 > n*3 {
+		return "application/octet-stream", true
+	}
+	mediaType := "text/plain"
+	for _, line := range lines {
+		line = strings.TrimLeft(strings.TrimPrefix(line, "\ufeff"), " \t")
+		if line == "" {
+			continue
+		}
+		line = strings.ToLower(line)
+		for _, prefix := range htmlPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				mediaType = "text/html"
+			}
+		}
+		break
+	}
+	if utf8Text && bin == 0 {
+		mediaType += "; charset=utf-8"
+	}
+	return mediaType, false
+}
@@ -0,0 +1,47 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// readInput processes an input line, rejoining delimiter lines split across two lines.
+func (r *Reader) readInput(line string) {
+	if len(r.decoders) > 0 {
+		r.decodeInput(0, line)
+		return
+	}
+	if r.split != "" {
+		split := r.split
+		r.split = ""
+		if _, _, ok := r.matchDelimiter(split + line); ok {
+			// fix: rejoin a delimiter line split across two lines
+			r.fixed(FixSplitDelimiters)
+			r.readLine(split + line)
+			return
+		}
+		r.readLine(split)
+	}
+	if r.opts.rejoinDelimiters && r.isSplitDelimiter(line) {
+		// hold the line until the next one tells whether it continues the delimiter
+		r.split = line
+		return
+	}
+	r.readLine(line)
+}
+
+// isSplitDelimiter reports whether line could be the start of an open delimiter line split
+// across two lines: a proper prefix of the delimiter, at least half as long.
+func (r *Reader) isSplitDelimiter(line string) bool {
+	if len(line) <= 2 || !strings.HasPrefix(line, "--") {
+		return false
+	}
+	if _, _, ok := r.matchDelimiter(line); ok {
+		return false
+	}
+	for _, delimiter := range r.delimiters {
+		if len(line) < len(delimiter) && 2*len(line) >= len(delimiter) && strings.HasPrefix(delimiter, line) {
+			return true
+		}
+	}
+	return false
+}
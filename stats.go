@@ -0,0 +1,98 @@
+package messagefix
+
+import (
+	"io"
+	"sync"
+)
+
+// Stats are aggregate counters of a Reader, such as for exporting metrics.
+type Stats struct {
+	// Fixes is the count of times each fix was applied, except FixLineEndings, as reported
+	// by WithOnFix.
+	Fixes map[FixID]int
+	// BytesIn is the count of bytes read from the input io.Reader.
+	BytesIn int64
+	// BytesOut is the count of bytes of the fixed message returned by Read, NextChunk and
+	// WriteTo.
+	BytesOut int64
+}
+
+// stats are the counters of a Reader, which are updated by jobs for the fixes of part bodies.
+type stats struct {
+	mu    sync.Mutex
+	fixes map[FixID]int
+	// parts are the counts of the fixes applied to each part.
+	parts map[partFix]int
+	in    int64
+	out   int64
+	// reject are the fixes rejected by WithRejectFixes, and rejected the error of the first
+	// rejected fix applied.
+	reject   map[FixID]bool
+	rejected error
+}
+
+// partFix is a fix applied to the part of a path.
+type partFix struct {
+	path string
+	id   FixID
+}
+
+// Stats returns the counters of the message read so far.
+func (r *Reader) Stats() Stats {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	s := Stats{
+		Fixes:    make(map[FixID]int, len(r.stats.fixes)),
+		BytesIn:  r.stats.in,
+		BytesOut: r.stats.out,
+	}
+	for id, n := range r.stats.fixes {
+		s.Fixes[id] = n
+	}
+	return s
+}
+
+func (s *stats) fixed(f Fix) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixes == nil {
+		s.fixes = make(map[FixID]int)
+		s.parts = make(map[partFix]int)
+	}
+	s.fixes[f.ID]++
+	s.parts[partFix{f.Path, f.ID}]++
+	if s.reject[f.ID] && s.rejected == nil {
+		s.rejected = &FixError{Fix: f}
+	}
+}
+
+// rejection returns the error of the first rejected fix applied, if any.
+func (s *stats) rejection() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+func (s *stats) read(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.in += int64(n)
+}
+
+func (s *stats) written(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out += int64(n)
+}
+
+// countingReader is an io.Reader counting the bytes read from r.
+type countingReader struct {
+	r     io.Reader
+	stats *stats
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.stats.read(n)
+	return n, err
+}
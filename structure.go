@@ -0,0 +1,57 @@
+package messagefix
+
+// Part is a MIME entity of the structure of a fixed message, as returned by Reader.Structure.
+type Part struct {
+	// Path is the IMAP part specifier of the entity, as in PartSummary.
+	Path string
+	// MediaType is the lowercase media type of the entity, or "" if it has no Content-Type.
+	MediaType string
+	// Boundary is the boundary of a multipart entity in the fixed message, or "" for other
+	// entities and for multiparts processed as opaque content.
+	Boundary string
+	// Embedded is set for a message embedded in a message/rfc822 or message/global part.
+	Embedded bool
+	// Depth is the nesting depth of the entity: 0 for the message itself, and the depth of
+	// its parent plus one for other entities.
+	Depth int
+	// Offset is the byte offset of the entity in the fixed message. HeaderSize and BodySize
+	// are as in PartSummary: the body of the entity starts at Offset+HeaderSize.
+	Offset               int64
+	HeaderSize, BodySize int64
+	// Children are the parts of a multipart entity, or the embedded message of a
+	// message/rfc822 part.
+	Children []*Part
+}
+
+// Structure returns the tree of the MIME entities of the fixed message, rooted at the message
+// itself, or nil if the message was not fully read yet.
+//
+// This is the structure the Reader detected and fixed while reading the message, without
+// parsing the fixed message again.
+func (r *Reader) Structure() *Part {
+	if r.summary == nil || len(r.parts) == 0 {
+		return nil
+	}
+	parts := make(map[*entity]*Part, len(r.parts))
+	var root *Part
+	for i, e := range r.parts {
+		s := r.summary.Parts[i]
+		p := &Part{
+			Path:       e.path,
+			MediaType:  e.mediaType,
+			Boundary:   e.boundary,
+			Embedded:   e.embedded,
+			Offset:     r.resolve(e.start),
+			HeaderSize: s.HeaderSize,
+			BodySize:   s.BodySize,
+		}
+		parts[e] = p
+		if parent := parts[e.parent]; parent != nil {
+			p.Depth = parent.Depth + 1
+			parent.Children = append(parent.Children, p)
+		} else if root == nil {
+			root = p
+		}
+	}
+	return root
+}
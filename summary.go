@@ -0,0 +1,174 @@
+package messagefix
+
+import (
+	"strconv"
+)
+
+// Summary describes a message once it has been fully read.
+type Summary struct {
+	// EndedInHeader is set when the input ended within a header block.
+	EndedInHeader bool
+	// ClosedBoundaries are the boundaries of the multiparts that were still open at the end
+	// of the input, and were closed by the Reader, innermost first.
+	ClosedBoundaries []string
+	// Parts are the MIME entities of the fixed message, in order of appearance:
+	// the message itself, then its parts and embedded messages, recursively.
+	Parts []PartSummary
+	// Size is the size in bytes of the fixed message.
+	Size int64
+}
+
+// PartSummary describes a MIME entity of a fixed message.
+type PartSummary struct {
+	// Path is the IMAP part specifier of the entity, such as "2.1", or "" for the message
+	// itself. An embedded message has the same path as the message/rfc822 part containing it.
+	Path string
+	// MediaType is the lowercase media type of the entity, or "" if it has no Content-Type.
+	MediaType string
+	// HeaderSize is the size in bytes of the header block of the entity, including the
+	// empty line ending it.
+	HeaderSize int64
+	// BodySize is the size in bytes of the body of the entity, excluding the CRLF preceding
+	// the delimiter that ends it.
+	BodySize int64
+	// StartLine and EndLine are the 1-based input line numbers of the first and last lines
+	// of the entity. EndLine is StartLine-1 for an empty entity.
+	StartLine, EndLine int
+}
+
+// Summary returns a summary of the message, or nil if the message was not fully read yet.
+func (r *Reader) Summary() *Summary {
+	return r.summary
+}
+
+// entity is a MIME entity of the fixed message, whose offsets are in the output.
+type entity struct {
+	path      string
+	mediaType string
+	// boundary is the boundary of a multipart entity, once its header block is fixed.
+	boundary string
+	// original is the delimiter of the boundary of a multipart entity in the input, when
+	// the boundary was renamed.
+	original string
+	// parent is the multipart entity or the message/rfc822 part containing the entity.
+	parent *entity
+	start  mark
+	// bodyStart is only valid once hasBody is set.
+	bodyStart mark
+	hasBody   bool
+	end       mark
+	// trim is the size of the line terminator preceding end that belongs to the delimiter
+	// ending the entity, if any.
+	trim int
+	// startLine and endLine are the input line numbers of the first and last lines of the
+	// entity.
+	startLine, endLine int
+	// embedded is set for the entity of a message embedded in a part.
+	embedded bool
+	children int
+}
+
+// mark is an offset in the output. Since the output of running jobs is not known yet,
+// the offset is resolved once all jobs are done.
+type mark struct {
+	// inline is the count of bytes produced by the Reader itself before the offset.
+	inline int64
+	// jobs is the count of jobs started before the offset.
+	jobs int
+}
+
+// offset returns the current offset in the output.
+func (r *Reader) offset() mark {
+	return mark{
+		inline: r.base + int64(len(r.buffer)),
+		jobs:   len(r.jobs),
+	}
+}
+
+// resolve returns the offset of a mark, once all jobs are done.
+func (r *Reader) resolve(m mark) int64 {
+	off := m.inline
+	for _, j := range r.jobs[:m.jobs] {
+		off += j.size
+	}
+	return off
+}
+
+// openEntity starts a new entity at the current output offset.
+func (r *Reader) openEntity(path string) {
+	var parent *entity
+	if len(r.entities) > 0 {
+		parent = r.entities[len(r.entities)-1]
+	}
+	e := &entity{
+		path:      path,
+		parent:    parent,
+		start:     r.offset(),
+		startLine: r.line + 1,
+	}
+	r.entities = append(r.entities, e)
+	r.parts = append(r.parts, e)
+}
+
+// openChild starts a new entity in the multipart entity at the passed depth.
+func (r *Reader) openChild(depth int) {
+	parent := r.entities[depth]
+	parent.children++
+	path := strconv.Itoa(parent.children)
+	if parent.path != "" {
+		path = parent.path + "." + path
+	}
+	r.openEntity(path)
+}
+
+// closeEntities ends all entities deeper than depth at the current output offset.
+// delimiter is set when the entities are ended by a boundary delimiter.
+func (r *Reader) closeEntities(depth int, delimiter bool) {
+	off := r.offset()
+	for len(r.entities) > depth {
+		e := r.entities[len(r.entities)-1]
+		r.entities = r.entities[:len(r.entities)-1]
+		e.end = off
+		e.endLine = r.line
+		if delimiter && !r.ended {
+			// the delimiter line belongs to the enclosing entity
+			e.endLine--
+		}
+		if !e.hasBody {
+			e.bodyStart = off
+			e.hasBody = true
+		} else {
+			e.trim = 0
+			if delimiter {
+				e.trim = r.delimiterEnd()
+			}
+		}
+	}
+}
+
+// summarize sets the summary of the message, whose multiparts of the passed delimiters
+// were closed at EOF.
+func (r *Reader) summarize(endedInHeader bool, delimiters []string) {
+	s := &Summary{
+		EndedInHeader: endedInHeader,
+		Size:          r.resolve(r.offset()),
+	}
+	for i := len(delimiters) - 1; i >= 0; i-- {
+		s.ClosedBoundaries = append(s.ClosedBoundaries, delimiters[i][2:])
+	}
+	for _, e := range r.parts {
+		start, bodyStart, end := r.resolve(e.start), r.resolve(e.bodyStart), r.resolve(e.end)
+		if end-int64(e.trim) >= bodyStart {
+			end -= int64(e.trim)
+		}
+		s.Parts = append(s.Parts, PartSummary{
+			Path:       e.path,
+			MediaType:  e.mediaType,
+			HeaderSize: bodyStart - start,
+			BodySize:   end - bodyStart,
+			StartLine:  e.startLine,
+			EndLine:    e.endLine,
+		})
+	}
+	r.summary = s
+}
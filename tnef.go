@@ -0,0 +1,352 @@
+package messagefix
+
+import (
+	"encoding/binary"
+	"errors"
+	"mime"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// WithExpandTNEF enables replacing application/ms-tnef parts, such as the winmail.dat
+// attachments of messages sent by Outlook, by a multipart/mixed part with a base64 part for
+// each file attached in the TNEF container, so that clients other than Outlook show them.
+//
+// The rest of the TNEF container, such as its RTF body and message properties, is dropped.
+// TNEF parts are held back until they end, up to 32MiB, and kept as is if they are larger,
+// cannot be decoded, or contain no files. Attachments use the media type declared in the
+// TNEF container, or application/octet-stream.
+func WithExpandTNEF(enabled bool) Option {
+	return func(o *options) {
+		o.expandTNEF = enabled
+	}
+}
+
+// maxHeldTNEF is the maximum size of a TNEF part body held back to be expanded.
+const maxHeldTNEF = 32 << 20
+
+// expandsTNEF reports whether the current part is a TNEF container expanded into its files.
+func (r *Reader) expandsTNEF() bool {
+	if !r.opts.expandTNEF || r.part.signed {
+		return false
+	}
+	if r.part.mediaType != "application/ms-tnef" && r.part.mediaType != "application/vnd.ms-tnef" {
+		return false
+	}
+	switch r.part.encoding {
+	case "", "7bit", "8bit", "binary", "base64", "quoted-printable":
+		return true
+	}
+	return false
+}
+
+// tnefExpander holds the body of a TNEF part back until it ends, then replaces it with the
+// files of the TNEF container.
+type tnefExpander struct {
+	r     *Reader
+	next  lineWriter
+	lines []string
+	size  int
+	dec   lineWriter
+	buf   []byte
+}
+
+func newTNEFExpander(r *Reader, next lineWriter) *tnefExpander {
+	x := &tnefExpander{r: r, next: next}
+	x.dec = r.part.decoder(&x.buf, &identityDecoder{out: &x.buf})
+	return x
+}
+
+func (x *tnefExpander) writeLine(line string) {
+	if !x.r.part.held {
+		x.next.writeLine(line)
+		return
+	}
+	x.lines = append(x.lines, line)
+	x.size += len(line)
+	x.dec.writeLine(line)
+	if x.size > maxHeldTNEF {
+		x.release()
+	}
+}
+
+func (x *tnefExpander) end(delimiter bool) {
+	if x.r.part.held {
+		x.dec.end(delimiter)
+		if delimiter {
+			// the CRLF preceding the delimiter belongs to it
+			x.buf = x.buf[:len(x.buf)-2]
+		}
+		if files, err := parseTNEF(x.buf); err == nil && len(files) > 0 {
+			x.expand(files)
+		} else {
+			x.release()
+		}
+	}
+	x.next.end(delimiter)
+}
+
+// release outputs the held header block and body lines as is.
+func (x *tnefExpander) release() {
+	x.r.releaseHeader()
+	for _, line := range x.lines {
+		x.next.writeLine(line)
+	}
+	x.lines = nil
+	x.buf = nil
+}
+
+// expand rewrites the part as multipart/mixed, with a part for each file.
+func (x *tnefExpander) expand(files []tnefFile) {
+	// fix: replace a TNEF container with the files it contains
+	x.r.fixed(FixTNEF)
+	boundary := synthesizedBoundary(len(x.r.entities))
+	header := x.r.header[:0]
+	for _, f := range x.r.header {
+		if !f.is("Content-Type") && !f.is("Content-Transfer-Encoding") && !f.is("Content-Disposition") {
+			header = append(header, f)
+		}
+	}
+	x.r.header = append(header, newField("Content-Type: multipart/mixed; boundary=\""+boundary+"\""))
+	e := x.r.entities[len(x.r.entities)-1]
+	e.mediaType = "multipart/mixed"
+	e.boundary = boundary
+	x.r.releaseHeader()
+	for _, f := range files {
+		mediaType := "application/octet-stream"
+		if t, _, err := mime.ParseMediaType(f.mediaType); err == nil {
+			mediaType = t
+		}
+		x.next.writeLine("--" + boundary)
+		x.next.writeLine("Content-Type: " + mime.FormatMediaType(mediaType, map[string]string{"name": f.name}))
+		x.next.writeLine("Content-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": f.name}))
+		x.next.writeLine("Content-Transfer-Encoding: base64")
+		x.next.writeLine("")
+		enc := &base64Encoder{buf: f.data, next: x.next}
+		enc.flush(true)
+	}
+	x.next.writeLine("--" + boundary + "--")
+	x.lines = nil
+	x.buf = nil
+}
+
+// tnefFile is a file attached in a TNEF container.
+type tnefFile struct {
+	name      string
+	mediaType string
+	data      []byte
+}
+
+const (
+	tnefSignature = 0x223e9f78
+
+	tnefLevelAttachment = 2
+
+	tnefAttachRenddata = 0x00069002
+	tnefAttachTitle    = 0x00018010
+	tnefAttachData     = 0x0006800f
+	tnefAttachment     = 0x00069005
+	tnefOEMCodepage    = 0x00069007
+
+	mapiAttachLongFilename = 0x3707
+	mapiAttachMIMETag      = 0x370e
+)
+
+var errTNEF = errors.New("invalid TNEF container")
+
+// parseTNEF returns the files attached in a TNEF container (MS-OXTNEF). Attachments without
+// data, such as embedded messages, are skipped.
+func parseTNEF(b []byte) ([]tnefFile, error) {
+	if len(b) < 6 || binary.LittleEndian.Uint32(b) != tnefSignature {
+		return nil, errTNEF
+	}
+	b = b[6:]
+	var files []tnefFile
+	var cur *tnefFile
+	codepage := ""
+	for len(b) > 0 {
+		if len(b) < 9 {
+			return nil, errTNEF
+		}
+		level := b[0]
+		id := binary.LittleEndian.Uint32(b[1:])
+		n := binary.LittleEndian.Uint32(b[5:])
+		if uint64(n)+11 > uint64(len(b)) {
+			return nil, errTNEF
+		}
+		data := b[9 : 9+n]
+		// the checksum following the data is not checked
+		b = b[11+n:]
+		switch {
+		case id == tnefOEMCodepage && len(data) >= 4:
+			codepage = strconv.Itoa(int(binary.LittleEndian.Uint32(data)))
+		case level != tnefLevelAttachment:
+		case id == tnefAttachRenddata:
+			files = append(files, tnefFile{})
+			cur = &files[len(files)-1]
+		case cur == nil:
+		case id == tnefAttachTitle:
+			if cur.name == "" {
+				cur.name = tnefString(data, codepage)
+			}
+		case id == tnefAttachData:
+			cur.data = data
+		case id == tnefAttachment:
+			// the properties of the attachment are optional: ignore them if they are invalid
+			props, _ := mapiStrings(data)
+			if name := props[mapiAttachLongFilename]; name != "" {
+				cur.name = name
+			}
+			cur.mediaType = props[mapiAttachMIMETag]
+		}
+	}
+	attached := files[:0]
+	for _, f := range files {
+		if f.data == nil {
+			continue
+		}
+		if f.name == "" {
+			f.name = "attachment" + strconv.Itoa(len(attached)+1)
+		}
+		attached = append(attached, f)
+	}
+	return attached, nil
+}
+
+// tnefString decodes a null-terminated string of a TNEF container, in UTF-8 or in the
+// Windows codepage of the container.
+func tnefString(b []byte, codepage string) string {
+	for i, c := range b {
+		if c == 0 {
+			b = b[:i]
+			break
+		}
+	}
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	if e := lookupCharset("windows-" + codepage); codepage != "" && e != nil {
+		if s, err := e.NewDecoder().Bytes(b); err == nil {
+			return string(s)
+		}
+	}
+	s, _ := charmap.Windows1252.NewDecoder().Bytes(b)
+	return string(s)
+}
+
+const (
+	mapiTypeMultiple = 0x1000
+	mapiTypeString8  = 0x001e
+	mapiTypeUnicode  = 0x001f
+	mapiTypeBinary   = 0x0102
+	mapiTypeObject   = 0x000d
+)
+
+// mapiFixedSizes are the sizes of the values of the fixed-size MAPI property types.
+var mapiFixedSizes = map[uint16]int{
+	0x0002: 2, 0x0003: 4, 0x0004: 4, 0x0005: 8, 0x0006: 8, 0x0007: 8,
+	0x000a: 4, 0x000b: 2, 0x0014: 8, 0x0040: 8, 0x0048: 16,
+}
+
+// mapiStrings returns the string properties of an encoded MAPI property list, by property ID.
+// It reports whether the whole list is valid.
+func mapiStrings(b []byte) (map[uint16]string, bool) {
+	props := make(map[uint16]string)
+	if len(b) < 4 {
+		return props, false
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		if len(b) < 4 {
+			return props, false
+		}
+		typ := binary.LittleEndian.Uint16(b)
+		id := binary.LittleEndian.Uint16(b[2:])
+		b = b[4:]
+		if id >= 0x8000 {
+			// named property: skip its GUID and name
+			if len(b) < 20 {
+				return props, false
+			}
+			kind := binary.LittleEndian.Uint32(b[16:])
+			b = b[20:]
+			if kind != 0 {
+				var ok bool
+				if _, b, ok = mapiValue(b); !ok {
+					return props, false
+				}
+			} else if len(b) < 4 {
+				return props, false
+			} else {
+				b = b[4:]
+			}
+		}
+		values := uint32(1)
+		base := typ &^ mapiTypeMultiple
+		variable := base == mapiTypeString8 || base == mapiTypeUnicode || base == mapiTypeBinary || base == mapiTypeObject
+		if typ&mapiTypeMultiple != 0 || variable {
+			if len(b) < 4 {
+				return props, false
+			}
+			values = binary.LittleEndian.Uint32(b)
+			b = b[4:]
+		}
+		for j := uint32(0); j < values; j++ {
+			if !variable {
+				size, ok := mapiFixedSizes[base]
+				if !ok {
+					return props, false
+				}
+				size = (size + 3) &^ 3
+				if len(b) < size {
+					return props, false
+				}
+				b = b[size:]
+				continue
+			}
+			v, rest, ok := mapiValue(b)
+			if !ok {
+				return props, false
+			}
+			b = rest
+			if j > 0 {
+				continue
+			}
+			switch typ {
+			case mapiTypeString8:
+				props[id] = tnefString(v, "")
+			case mapiTypeUnicode:
+				u := make([]uint16, len(v)/2)
+				for k := range u {
+					u[k] = binary.LittleEndian.Uint16(v[2*k:])
+				}
+				for k, c := range u {
+					if c == 0 {
+						u = u[:k]
+						break
+					}
+				}
+				props[id] = string(utf16.Decode(u))
+			}
+		}
+	}
+	return props, true
+}
+
+// mapiValue returns a variable-size MAPI value, prefixed by its size and padded to 4 bytes,
+// and the bytes following it.
+func mapiValue(b []byte) (v, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := uint64(binary.LittleEndian.Uint32(b))
+	padded := (n + 3) &^ 3
+	if padded+4 > uint64(len(b)) {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+padded:], true
+}
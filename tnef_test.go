@@ -0,0 +1,87 @@
+package messagefix
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// tnefTestAttribute returns a TNEF attribute, with a zero checksum.
+func tnefTestAttribute(level byte, id uint32, data []byte) []byte {
+	b := []byte{level}
+	b = binary.LittleEndian.AppendUint32(b, id)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	return append(b, 0, 0)
+}
+
+// tnefTestContainer returns the base64 lines of a TNEF container attaching a file for each
+// name and content pair of files, with the MIME tag mediaType if it is not empty.
+func tnefTestContainer(mediaType string, files ...string) string {
+	b := binary.LittleEndian.AppendUint32(nil, tnefSignature)
+	b = append(b, 0, 0)
+	b = append(b, tnefTestAttribute(1, 0x00089006, []byte("IPM.Microsoft Mail.Note\x00"))...)
+	for i := 0; i+1 < len(files); i += 2 {
+		b = append(b, tnefTestAttribute(tnefLevelAttachment, tnefAttachRenddata, make([]byte, 14))...)
+		b = append(b, tnefTestAttribute(tnefLevelAttachment, tnefAttachTitle, []byte(files[i]+"\x00"))...)
+		b = append(b, tnefTestAttribute(tnefLevelAttachment, tnefAttachData, []byte(files[i+1]))...)
+		if mediaType != "" {
+			props := binary.LittleEndian.AppendUint32(nil, 1)
+			props = binary.LittleEndian.AppendUint16(props, mapiTypeString8)
+			props = binary.LittleEndian.AppendUint16(props, mapiAttachMIMETag)
+			props = binary.LittleEndian.AppendUint32(props, 1)
+			value := []byte(mediaType + "\x00")
+			props = binary.LittleEndian.AppendUint32(props, uint32(len(value)))
+			props = append(props, value...)
+			for len(props)%4 != 0 {
+				props = append(props, 0)
+			}
+			b = append(b, tnefTestAttribute(tnefLevelAttachment, tnefAttachment, props)...)
+		}
+	}
+	var sb strings.Builder
+	for len(b) > 0 {
+		n := min(len(b), 57)
+		sb.WriteString(base64.StdEncoding.EncodeToString(b[:n]))
+		sb.WriteString("\r\n")
+		b = b[n:]
+	}
+	return sb.String()
+}
+
+func TestExpandTNEF(t *testing.T) {
+	const header = "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\ntext\r\n--b\r\nContent-Type: application/ms-tnef; name=winmail.dat\r\nContent-Disposition: attachment; filename=winmail.dat\r\nContent-Transfer-Encoding: base64\r\n"
+	const expanded = "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\ntext\r\n--b\r\nContent-Type: multipart/mixed; boundary=\"=_messagefix_2\"\r\n\r\n"
+	opts := []Option{WithExpandTNEF(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "files",
+			input: header + "\r\n" + tnefTestContainer("", "a.txt", "hello", "b.bin", "world") + "--b--\r\n",
+			opts:  opts,
+			want:  expanded + "--=_messagefix_2\r\nContent-Type: application/octet-stream; name=a.txt\r\nContent-Disposition: attachment; filename=a.txt\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8=\r\n--=_messagefix_2\r\nContent-Type: application/octet-stream; name=b.bin\r\nContent-Disposition: attachment; filename=b.bin\r\nContent-Transfer-Encoding: base64\r\n\r\nd29ybGQ=\r\n--=_messagefix_2--\r\n--b--\r\n",
+			fixes: []FixID{FixTNEF},
+		},
+		{
+			name:  "media type",
+			input: header + "\r\n" + tnefTestContainer("text/plain", "a.txt", "hello") + "--b--\r\n",
+			opts:  opts,
+			want:  expanded + "--=_messagefix_2\r\nContent-Type: text/plain; name=a.txt\r\nContent-Disposition: attachment; filename=a.txt\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8=\r\n--=_messagefix_2--\r\n--b--\r\n",
+			fixes: []FixID{FixTNEF},
+		},
+		{
+			name:  "no files",
+			input: header + "\r\n" + tnefTestContainer("") + "--b--\r\n",
+			opts:  opts,
+			want:  header + "\r\n" + tnefTestContainer("") + "--b--\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "invalid container",
+			input: header + "\r\naGVsbG8gd29ybGQ=\r\n--b--\r\n",
+			opts:  opts,
+			want:  header + "\r\naGVsbG8gd29ybGQ=\r\n--b--\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,147 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// WithFoldTraceFields enables folding the Received, Authentication-Results and
+// ARC-Authentication-Results fields with lines longer than width characters, at the
+// whitespace between their clauses, into lines of at most width characters where possible:
+//   - Received fields are folded before their "from", "by", "via", "with", "id" and "for"
+//     clauses, and after the ";" preceding their date;
+//   - Authentication-Results fields are folded after the ";" ending each result.
+//
+// Clauses longer than width are folded at the whitespace between their words. Comments and
+// quoted strings are never folded, unlike with WithFoldHeaders, which then leaves these fields
+// as folded. A width of 0 disables folding.
+func WithFoldTraceFields(width int) Option {
+	return func(o *options) {
+		o.foldTraceWidth = width
+	}
+}
+
+// receivedClauses are the keywords starting the clauses of a Received field (RFC 5321).
+var receivedClauses = []string{"from", "by", "via", "with", "id", "for"}
+
+// foldTraceFields folds the trace fields of the header with lines longer than width,
+// reporting whether the header was fixed.
+func foldTraceFields(header []*field, width int) bool {
+	fixed := false
+	for _, f := range header {
+		if isTraceField(f) && foldClauses(f, width, f.is("Received")) {
+			fixed = true
+		}
+	}
+	return fixed
+}
+
+// isTraceField reports whether f is folded by WithFoldTraceFields.
+func isTraceField(f *field) bool {
+	return f.is("Received") || f.is("Authentication-Results") || f.is("ARC-Authentication-Results")
+}
+
+// foldClauses folds a field with lines longer than width at the whitespace between its
+// clauses, reporting whether it was folded. Clauses are packed on each line as long as it
+// fits within width characters.
+func foldClauses(f *field, width int, received bool) bool {
+	long := false
+	for _, line := range f.lines {
+		long = long || len(line) > width
+	}
+	if !long {
+		return false
+	}
+	s := strings.Join(f.lines, "")
+	clauses, words := traceBreaks(s, len(f.name)+1, received)
+	var lines []string
+	start := 0
+	for _, end := range append(packBreaks(0, len(s), clauses, width), len(s)) {
+		// clauses longer than width are folded between their words
+		for _, i := range packBreaks(start, end, words, width) {
+			lines = append(lines, s[start:i])
+			start = i
+		}
+		lines = append(lines, s[start:end])
+		start = end
+	}
+	if len(lines) == len(f.lines) {
+		same := true
+		for i := range lines {
+			same = same && lines[i] == f.lines[i]
+		}
+		if same {
+			return false
+		}
+	}
+	// fix: fold long trace fields between their clauses
+	f.lines = lines
+	return true
+}
+
+// traceBreaks returns the indexes of the whitespace of s past min where a trace field can
+// be folded, outside of comments and quoted strings: clauses are the indexes after a ";",
+// or before a Received clause keyword if received is set, and words are all the indexes.
+func traceBreaks(s string, min int, received bool) (clauses, words []int) {
+	depth := 0
+	quoted := false
+	for i := min; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0):
+			i++
+		case quoted:
+			quoted = c != '"'
+		case c == '(':
+			depth++
+		case depth > 0:
+			if c == ')' {
+				depth--
+			}
+		case c == '"':
+			quoted = true
+		case (c == ' ' || c == '\t') && i > min && s[i-1] != ' ' && s[i-1] != '\t':
+			next := strings.TrimLeft(s[i:], " \t")
+			if next == "" {
+				return clauses, words
+			}
+			if s[i-1] == ';' || received && isClauseKeyword(next) {
+				clauses = append(clauses, i)
+			}
+			words = append(words, i)
+		}
+	}
+	return clauses, words
+}
+
+// packBreaks returns the breaks to fold s[start:end] at, packing as much of it on each line
+// as fits within width.
+func packBreaks(start, end int, breaks []int, width int) []int {
+	var folds []int
+	last := start
+	for _, i := range breaks {
+		if i <= start || i >= end {
+			continue
+		}
+		if i-start > width && last > start {
+			folds = append(folds, last)
+			start = last
+		}
+		last = i
+	}
+	if end-start > width && last > start {
+		folds = append(folds, last)
+	}
+	return folds
+}
+
+// isClauseKeyword reports whether s starts with a Received clause keyword followed by
+// whitespace.
+func isClauseKeyword(s string) bool {
+	for _, keyword := range receivedClauses {
+		n := len(keyword)
+		if len(s) > n && strings.EqualFold(s[:n], keyword) && (s[n] == ' ' || s[n] == '\t') {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package messagefix
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// lookupCharset returns the encoding of a charset label, or nil if it is unknown.
+func lookupCharset(label string) encoding.Encoding {
+	if name, ok := canonicalCharset(label); ok {
+		label = name
+	}
+	if e, err := ianaindex.MIME.Encoding(label); err == nil && e != nil {
+		return e
+	}
+	if e, err := htmlindex.Get(label); err == nil {
+		return e
+	}
+	return nil
+}
+
+// transcode sets up the transcoding of the current text part body to UTF-8, rewriting its
+// header accordingly, and returns the lineWriter doing it, or next if the part is not transcoded.
+func (r *Reader) transcode(next lineWriter) lineWriter {
+	if !strings.HasPrefix(r.part.mediaType, "text/") {
+		return next
+	}
+	var convert func([]byte) []byte
+	charset := strings.ToLower(r.part.params["charset"])
+	switch charset {
+	case "", "us-ascii":
+		// detect the charset of each line
+		convert = detectUTF8
+	default:
+		e := lookupCharset(charset)
+		if e == nil || e == encoding.Nop {
+			return next
+		}
+		if b, err := e.NewEncoder().Bytes([]byte("=\r\n")); err != nil || string(b) != "=\r\n" {
+			// the charset is not ASCII-compatible, and cannot be processed line by line
+			return next
+		}
+		if name, _ := ianaindex.MIME.Name(e); strings.EqualFold(name, "UTF-8") {
+			return next
+		}
+		dec := e.NewDecoder()
+		convert = func(line []byte) []byte {
+			b, err := dec.Bytes(line)
+			if err != nil {
+				return line
+			}
+			return b
+		}
+		if r.part.encoding == "" || r.part.encoding == "7bit" {
+			r.setEncoding("8bit")
+		}
+	}
+	// fix: transcode the part to UTF-8
+	r.fixed(FixTranscode)
+	setParam(lookup(r.header, "Content-Type"), "charset", "UTF-8")
+	return newTranscoder(r.part.encoding, next, convert)
+}
+
+// detectUTF8 returns a line if it is valid UTF-8, and its transcoding from windows-1252 otherwise.
+func detectUTF8(line []byte) []byte {
+	if utf8.Valid(line) {
+		return line
+	}
+	// the most common non-UTF-8 charset for undeclared 8-bit text is windows-1252
+	b, err := charmap.Windows1252.NewDecoder().Bytes(line)
+	if err != nil {
+		return line
+	}
+	return b
+}
+
+// replaceUTF8 sets up the replacement of invalid UTF-8 in the current part body, if
+// it is declared as UTF-8, and returns the lineWriter doing it, or next otherwise.
+func (r *Reader) replaceUTF8(next lineWriter) lineWriter {
+	if !strings.HasPrefix(r.part.mediaType, "text/") {
+		return next
+	}
+	charset := r.part.params["charset"]
+	if name, ok := canonicalCharset(charset); ok {
+		charset = name
+	}
+	if !strings.EqualFold(charset, "utf-8") {
+		return next
+	}
+	replacement := r.opts.utf8Replacement
+	report := r.bodyReporter()
+	return newTranscoder(r.part.encoding, next, func(line []byte) []byte {
+		if utf8.Valid(line) {
+			return line
+		}
+		// fix: replace invalid UTF-8
+		report.fixed(FixInvalidUTF8)
+		return bytes.ToValidUTF8(line, []byte(replacement))
+	})
+}
+
+// transcoder is the lineWriter converting the content of text part bodies line by line.
+//
+// Encoded bodies are decoded, converted line by line, then encoded again.
+type transcoder struct {
+	next    lineWriter
+	convert func(line []byte) []byte
+	// encoding is the part Content-Transfer-Encoding.
+	encoding string
+	// body decodes the part Content-Transfer-Encoding to buf, or is nil for unencoded bodies.
+	body lineWriter
+	buf  []byte
+	// b64 is the transcoded data that is not base64-encoded yet.
+	b64 []byte
+}
+
+func newTranscoder(encoding string, next lineWriter, convert func([]byte) []byte) *transcoder {
+	t := &transcoder{
+		next:     next,
+		convert:  convert,
+		encoding: encoding,
+	}
+	switch encoding {
+	case "base64":
+		t.body = &base64Decoder{out: &t.buf}
+	case "quoted-printable":
+		t.body = &qpDecoder{out: &t.buf}
+	}
+	return t
+}
+
+func (t *transcoder) writeLine(line string) {
+	if t.body == nil {
+		t.next.writeLine(string(t.convert([]byte(line))))
+		return
+	}
+	t.body.writeLine(line)
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			break
+		}
+		t.writeDecoded(t.buf[:i+1])
+		t.buf = t.buf[i+1:]
+	}
+}
+
+func (t *transcoder) end(delimiter bool) {
+	if t.body != nil {
+		t.body.end(false)
+		if len(t.buf) > 0 {
+			t.writeDecoded(t.buf)
+		}
+		t.buf = nil
+		if len(t.b64) > 0 {
+			t.next.writeLine(base64.StdEncoding.EncodeToString(t.b64))
+			t.b64 = nil
+		}
+	}
+	t.next.end(delimiter)
+}
+
+// writeDecoded transcodes and encodes a decoded line, including its line terminator, if any.
+func (t *transcoder) writeDecoded(line []byte) {
+	content := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	terminator := line[len(content):]
+	content = t.convert(content)
+	switch t.encoding {
+	case "base64":
+		t.b64 = append(t.b64, content...)
+		t.b64 = append(t.b64, terminator...)
+		const n = 76 / 4 * 3
+		for len(t.b64) >= n {
+			t.next.writeLine(base64.StdEncoding.EncodeToString(t.b64[:n]))
+			t.b64 = t.b64[n:]
+		}
+	case "quoted-printable":
+		for _, l := range encodeQP(content) {
+			t.next.writeLine(l)
+		}
+	}
+}
+
+// encodeQP returns the quoted-printable encoding of a line, split by soft line breaks
+// into lines of at most 76 characters.
+func encodeQP(line []byte) []string {
+	var lines []string
+	var sb strings.Builder
+	for i, c := range line {
+		var s string
+		switch {
+		case c == '=' || c != '\t' && (c < ' ' || c > '~'):
+			s = qpEscape(c)
+		case (c == ' ' || c == '\t') && i == len(line)-1:
+			s = qpEscape(c)
+		default:
+			s = string(c)
+		}
+		if sb.Len()+len(s) > 75 {
+			sb.WriteByte('=')
+			lines = append(lines, sb.String())
+			sb.Reset()
+		}
+		sb.WriteString(s)
+	}
+	return append(lines, sb.String())
+}
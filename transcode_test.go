@@ -0,0 +1,67 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+func TestTranscode(t *testing.T) {
+	opts := []Option{WithTranscode(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "latin-1",
+			input: "Content-Type: text/plain; charset=iso-8859-1\r\n\r\ncaf\xe9\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{FixTranscode},
+		},
+		{
+			name:  "quoted-printable latin-1",
+			input: "Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ncaf=E9=\r\n au lait\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ncaf=C3=A9 au lait\r\n",
+			fixes: []FixID{FixTranscode},
+		},
+		{
+			name:  "base64 latin-1",
+			input: "Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: base64\r\n\r\nY2Fm6Q==\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: base64\r\n\r\nY2Fmw6k=\r\n",
+			fixes: []FixID{FixTranscode},
+		},
+		{
+			name:  "undeclared windows-1252",
+			input: "Content-Type: text/plain\r\n\r\n\x93quoted\x94\r\ncaf\xc3\xa9\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=UTF-8\r\n\r\n\xe2\x80\x9cquoted\xe2\x80\x9d\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{FixTranscode},
+		},
+		{
+			name:  "utf-8",
+			input: "Content-Type: text/plain; charset=utf-8\r\n\r\ncaf\xc3\xa9\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=utf-8\r\n\r\ncaf\xc3\xa9\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "utf-16",
+			input: "Content-Type: text/plain; charset=utf-16le\r\nContent-Transfer-Encoding: base64\r\n\r\nYwBhAGYA6QA=\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=utf-16le\r\nContent-Transfer-Encoding: base64\r\n\r\nYwBhAGYA6QA=\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "unknown charset",
+			input: "Content-Type: text/plain; charset=x-unknown\r\n\r\ncaf\xe9\r\n",
+			opts:  opts,
+			want:  "Content-Type: text/plain; charset=x-unknown\r\n\r\ncaf\xe9\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "attachment",
+			input: "Content-Type: application/octet-stream\r\n\r\ncaf\xe9\r\n",
+			opts:  opts,
+			want:  "Content-Type: application/octet-stream\r\n\r\ncaf\xe9\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
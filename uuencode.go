@@ -0,0 +1,93 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// WithUUEncodedAttachments enables rewriting messages without MIME structure whose text body
+// contains uuencoded files, between "begin 644 name" and "end" lines, as multipart/mixed
+// messages with a base64 attachment for each file, so that modern clients show them.
+//
+// Only messages whose header declares no Content-Type or text/plain are rewritten, once a
+// uuencode begin line is found in the first 1MiB of their body; the header block is held
+// back until then. Messages checked by WithEncodingMismatch are not rewritten. Attachments
+// use the application/octet-stream media type.
+func WithUUEncodedAttachments(enabled bool) Option {
+	return func(o *options) {
+		o.uuAttachments = enabled
+	}
+}
+
+// uuBegin returns the file name of a uuencode begin line, such as "begin 644 name".
+func uuBegin(line string) (string, bool) {
+	if !strings.HasPrefix(line, "begin ") {
+		return "", false
+	}
+	mode, name, _ := strings.Cut(line[len("begin "):], " ")
+	name = strings.TrimSpace(name)
+	if len(mode) < 3 || len(mode) > 4 || strings.Trim(mode, "01234567") != "" || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// uudecode appends the bytes encoded by a uuencoded line to dst. It reports whether the line
+// is valid. Missing trailing spaces, which some encoders strip, are decoded as zeroes.
+func uudecode(dst []byte, line string) ([]byte, bool) {
+	if line == "" || line[0] < ' ' || line[0] > '`' {
+		return dst, false
+	}
+	n := int(line[0]-' ') & 63
+	if (len(line)-1)*3/4 < n-3 {
+		return dst, false
+	}
+	var b [4]byte
+	for i := 0; i < n; i += 3 {
+		for j := range b {
+			c := byte(' ')
+			if k := 1 + i/3*4 + j; k < len(line) {
+				c = line[k]
+			}
+			if c < ' ' || c > '`' {
+				return dst, false
+			}
+			b[j] = (c - ' ') & 63
+		}
+		chunk := []byte{b[0]<<2 | b[1]>>4, b[1]<<4 | b[2]>>2, b[2]<<6 | b[3]}
+		if n-i < 3 {
+			chunk = chunk[:n-i]
+		}
+		dst = append(dst, chunk...)
+	}
+	return dst, true
+}
+
+// uuDecoder rewrites a part body declared as x-uuencode as base64. The lines outside of the
+// begin and end lines, and the invalid lines, are dropped.
+type uuDecoder struct {
+	enc base64Encoder
+	// begun is set once the begin line was read, and ended once the end line was read.
+	begun, ended bool
+}
+
+func newUUDecoder(next lineWriter) *uuDecoder {
+	return &uuDecoder{enc: base64Encoder{next: next}}
+}
+
+func (d *uuDecoder) writeLine(line string) {
+	switch {
+	case d.ended:
+	case !d.begun:
+		_, d.begun = uuBegin(line)
+	case line == "end":
+		d.ended = true
+	default:
+		d.enc.buf, _ = uudecode(d.enc.buf, line)
+		d.enc.flush(false)
+	}
+}
+
+func (d *uuDecoder) end(delimiter bool) {
+	d.enc.flush(true)
+	d.enc.next.end(delimiter)
+}
@@ -0,0 +1,53 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+// uuTestHeader and uuTestAttachment are the MIME header fields of the messages rewritten as
+// multipart/mixed, and their part of the file hello.txt containing "hello world".
+const (
+	uuTestHeader     = "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"=_messagefix_1\"\r\n\r\n"
+	uuTestAttachment = "--=_messagefix_1\r\nContent-Type: application/octet-stream; name=hello.txt\r\nContent-Disposition: attachment; filename=hello.txt\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8gd29ybGQ=\r\n"
+)
+
+func TestUUEncodedAttachments(t *testing.T) {
+	opts := []Option{WithUUEncodedAttachments(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "attachment",
+			input: "Subject: file\r\n\r\nsee attached\r\n\r\nbegin 644 hello.txt\r\n+:&5L;&\\@=V]R;&0 \r\n`\r\nend\r\nbye\r\n",
+			opts:  opts,
+			want:  "Subject: file\r\n" + uuTestHeader + "--=_messagefix_1\r\n\r\nsee attached\r\n\r\n" + uuTestAttachment + "--=_messagefix_1\r\n\r\nbye\r\n--=_messagefix_1--\r\n",
+			fixes: []FixID{FixUUEncodedAttachments},
+		},
+		{
+			name:  "stripped trailing spaces",
+			input: "Subject: file\r\n\r\nbegin 600 hello.txt\r\n+:&5L;&\\@=V]R;&0\r\n`\r\nend\r\n",
+			opts:  opts,
+			want:  "Subject: file\r\n" + uuTestHeader + uuTestAttachment + "--=_messagefix_1--\r\n",
+			fixes: []FixID{FixUUEncodedAttachments},
+		},
+		{
+			name:  "text/plain message",
+			input: "Content-Type: text/plain; charset=us-ascii\r\n\r\nbegin 644 hello.txt\r\n+:&5L;&\\@=V]R;&0 \r\nend\r\n",
+			opts:  opts,
+			want:  uuTestHeader + uuTestAttachment + "--=_messagefix_1--\r\n",
+			fixes: []FixID{FixUUEncodedAttachments},
+		},
+		{
+			name:  "text without a begin line",
+			input: "Subject: text\r\n\r\nbegin here\r\nend\r\n",
+			opts:  opts,
+			want:  "Subject: text\r\n\r\nbegin here\r\nend\r\n",
+			fixes: []FixID{},
+		},
+		{
+			name:  "multipart",
+			input: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbegin 644 hello.txt\r\n+:&5L;&\\@=V]R;&0 \r\nend\r\n--b--\r\n",
+			opts:  opts,
+			want:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbegin 644 hello.txt\r\n+:&5L;&\\@=V]R;&0 \r\nend\r\n--b--\r\n",
+			fixes: []FixID{},
+		},
+	})
+}
@@ -0,0 +1,113 @@
+package messagefix
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// VerifyError is returned by VerifyFixed when an entity of the fixed message cannot be
+// parsed.
+type VerifyError struct {
+	// Path is the IMAP part specifier of the entity, as in PartSummary.
+	Path string
+	// Err is the error returned by the parser of the entity.
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	s := "messagefix: fixed message"
+	if e.Path != "" {
+		s += ": part " + e.Path
+	}
+	return s + ": " + e.Err.Error()
+}
+
+// Unwrap returns e.Err.
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// errMissingBoundary is the error of a multipart without a boundary parameter.
+var errMissingBoundary = errors.New("multipart without a boundary parameter")
+
+// VerifyFixed fixes the message read from r, and parses the fixed message as strict consumers
+// would: its header blocks with net/mail, the parts of its multiparts with mime/multipart, the
+// messages embedded in its message/rfc822 parts, and its base64 and quoted-printable bodies.
+//
+// It returns a *VerifyError describing the first entity that cannot be parsed, or the error
+// returned by the Reader, or nil if the whole fixed message could be parsed. The fixed
+// message is parsed as it is read, without buffering it.
+func VerifyFixed(r io.Reader, opts ...Option) error {
+	fr := &readError{r: NewReader(r, opts...)}
+	err := verifyMessage(fr, "")
+	if err == nil {
+		_, err = io.Copy(io.Discard, fr)
+	}
+	if fr.err != nil {
+		return fr.err
+	}
+	return err
+}
+
+// verifyMessage parses the message read from r, at path.
+func verifyMessage(r io.Reader, path string) error {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return &VerifyError{Path: path, Err: err}
+	}
+	return verifyBody(textproto.MIMEHeader(m.Header), m.Body, path)
+}
+
+// verifyBody parses the body of the entity of header h read from r, at path.
+func verifyBody(h textproto.MIMEHeader, r io.Reader, path string) error {
+	mediaType := "text/plain"
+	var params map[string]string
+	if v := h.Get("Content-Type"); v != "" {
+		var err error
+		if mediaType, params, err = mime.ParseMediaType(v); err != nil {
+			return &VerifyError{Path: path, Err: err}
+		}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			return &VerifyError{Path: path, Err: errMissingBoundary}
+		}
+		mr := multipart.NewReader(r, params["boundary"])
+		for i := 1; ; i++ {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return &VerifyError{Path: path, Err: err}
+			}
+			child := strconv.Itoa(i)
+			if path != "" {
+				child = path + "." + child
+			}
+			if err := verifyBody(p.Header, p, child); err != nil {
+				return err
+			}
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	if mediaType == "message/rfc822" || mediaType == "message/global" {
+		return verifyMessage(r, path)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return &VerifyError{Path: path, Err: err}
+	}
+	return nil
+}
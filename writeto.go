@@ -0,0 +1,32 @@
+package messagefix
+
+import (
+	"io"
+)
+
+// WriteTo writes the fixed message to w, until EOF or an error. It implements io.WriterTo,
+// so that io.Copy writes the output of the Reader to w as it is produced, without copying it
+// to an intermediate buffer.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	for {
+		b, err := r.head()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		m, err := w.Write(b)
+		if m > len(b) {
+			m = len(b)
+		}
+		r.advance(m)
+		r.stats.written(m)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if m < len(b) {
+			return n, io.ErrShortWrite
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package messagefix
+
+import (
+	"strings"
+)
+
+// WithYEncAttachments enables rewriting messages without MIME structure whose text body
+// contains yEnc-encoded files, between "=ybegin ... name=name" and "=yend" lines, as
+// multipart/mixed messages with a base64 attachment for each file. yEnc is common in messages
+// gatewayed from news, and its raw 8-bit content breaks strict 7-bit pipelines.
+//
+// Messages are rewritten as with WithUUEncodedAttachments, which can be enabled along with it.
+// The sizes and checksums of the "=yend" lines are not checked, and the files of "=ypart"
+// lines are extracted as is, without reassembling the parts of other messages.
+func WithYEncAttachments(enabled bool) Option {
+	return func(o *options) {
+		o.yencAttachments = enabled
+	}
+}
+
+// yencBegin returns the file name of a yEnc begin line, such as
+// "=ybegin line=128 size=123 name=file.bin". The name parameter is always last, and extends
+// to the end of the line.
+func yencBegin(line string) (string, bool) {
+	if !strings.HasPrefix(line, "=ybegin ") {
+		return "", false
+	}
+	i := strings.Index(line, " name=")
+	if i < 0 {
+		return "", false
+	}
+	name := strings.TrimSpace(line[i+len(" name="):])
+	if name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// ydecode appends the bytes encoded by a yEnc-encoded line to dst. Each byte is offset by
+// 42, and critical bytes are escaped by "=" and an additional offset of 64.
+func ydecode(dst []byte, line string) []byte {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '=' && i+1 < len(line) {
+			i++
+			c = line[i] - 64
+		}
+		dst = append(dst, c-42)
+	}
+	return dst
+}
@@ -0,0 +1,39 @@
+package messagefix
+
+import (
+	"testing"
+)
+
+func TestYEncAttachments(t *testing.T) {
+	opts := []Option{WithYEncAttachments(true)}
+	runFixTests(t, []fixTest{
+		{
+			name:  "attachment",
+			input: "Subject: file\r\n\r\nsee attached\r\n=ybegin line=128 size=11 name=hello.txt\r\n\x92\x8f\x96\x96\x99J\xa1\x99\x9c\x96\x8e\r\n=yend size=11\r\n",
+			opts:  opts,
+			want:  "Subject: file\r\n" + uuTestHeader + "--=_messagefix_1\r\n\r\nsee attached\r\n" + uuTestAttachment + "--=_messagefix_1--\r\n",
+			fixes: []FixID{FixYEncAttachments},
+		},
+		{
+			name:  "escaped bytes",
+			input: "Subject: file\r\n\r\n=ybegin line=128 size=3 name=bytes.bin\r\n=@=J=M\r\n=yend size=3\r\n",
+			opts:  opts,
+			want:  "Subject: file\r\n" + uuTestHeader + "--=_messagefix_1\r\nContent-Type: application/octet-stream; name=bytes.bin\r\nContent-Disposition: attachment; filename=bytes.bin\r\nContent-Transfer-Encoding: base64\r\n\r\n1uDj\r\n--=_messagefix_1--\r\n",
+			fixes: []FixID{FixYEncAttachments},
+		},
+		{
+			name:  "with uuencoded files",
+			input: "Subject: files\r\n\r\nbegin 644 hello.txt\r\n+:&5L;&\\@=V]R;&0 \r\nend\r\n=ybegin line=128 size=11 name=hello.txt\r\n\x92\x8f\x96\x96\x99J\xa1\x99\x9c\x96\x8e\r\n=yend size=11\r\n",
+			opts:  append([]Option{WithUUEncodedAttachments(true)}, opts...),
+			want:  "Subject: files\r\n" + uuTestHeader + uuTestAttachment + uuTestAttachment + "--=_messagefix_1--\r\n",
+			fixes: []FixID{FixUUEncodedAttachments, FixYEncAttachments},
+		},
+		{
+			name:  "begin line without a name",
+			input: "Subject: text\r\n\r\n=ybegin line=128 size=11\r\ntext\r\n",
+			opts:  opts,
+			want:  "Subject: text\r\n\r\n=ybegin line=128 size=11\r\ntext\r\n",
+			fixes: []FixID{},
+		},
+	})
+}